@@ -0,0 +1,507 @@
+package backup
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/db"
+)
+
+// A backup directory holds:
+//
+//	meta/<id>          files of backup id with their sizes and checksums
+//	shared/<file>      tables and value logs, shared by the backups
+//	                   holding them
+//	private/<id>/...   WALs and manifest of backup id
+//
+// Shared files are named after the file, its size and checksum, so that a
+// table present in several backups is stored once.
+const (
+	META_DIR    string = "meta"
+	SHARED_DIR  string = "shared"
+	PRIVATE_DIR string = "private"
+	// where the checkpoint of a backup in progress is taken.
+	TMP_DIR string = "tmp"
+)
+
+var (
+	ErrBackupNotFound = errors.New("backup: backup not found")
+	ErrCorruptBackup  = errors.New("backup: corrupt backup")
+	ErrRestoreTarget  = errors.New("backup: restore target is not empty")
+)
+
+// Info describes a backup.
+type Info struct {
+	ID        uint64
+	Timestamp time.Time
+	// total size of the files of the backup, shared ones included.
+	Size     uint64
+	NumFiles int
+}
+
+// backupFile is a file of a backup.
+type backupFile struct {
+	// path in the store directory.
+	path string
+	// path in the backup directory.
+	stored string
+	size   uint64
+	crc    uint32
+}
+
+// Engine stores versioned backups of stores in a directory.
+type Engine struct {
+	rwmu sync.RWMutex
+	dir  string
+}
+
+// Open opens the backup directory dir, creating it if needed.
+func Open(dir string) (*Engine, error) {
+	for _, sub := range []string{META_DIR, SHARED_DIR, PRIVATE_DIR} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Engine{dir: dir}, nil
+}
+
+// CreateBackup backs up store from a checkpoint, so writes to it are only
+// blocked while the checkpoint is taken. Tables and value logs already in
+// the backup directory are not copied again.
+func (e *Engine) CreateBackup(store *db.DB) (Info, error) {
+	e.rwmu.Lock()
+	defer e.rwmu.Unlock()
+
+	ids, err := e.ids()
+	if err != nil {
+		return Info{}, err
+	}
+
+	id := uint64(1)
+	if len(ids) > 0 {
+		id = ids[len(ids)-1] + 1
+	}
+
+	tmp := filepath.Join(e.dir, TMP_DIR)
+	if err := os.RemoveAll(tmp); err != nil {
+		return Info{}, err
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := store.CreateCheckpoint(tmp); err != nil {
+		return Info{}, err
+	}
+
+	files, err := e.store(id, tmp)
+	if err != nil {
+		os.RemoveAll(e.privateDir(id))
+		e.collectShared()
+		return Info{}, err
+	}
+
+	info := Info{ID: id, Timestamp: time.Now()}
+	if err := e.writeMeta(info, files); err != nil {
+		os.RemoveAll(e.privateDir(id))
+		e.collectShared()
+		return Info{}, err
+	}
+
+	return newInfo(id, info.Timestamp, files), nil
+}
+
+// store copies the files of the checkpoint in dir to the backup directory.
+func (e *Engine) store(id uint64, dir string) ([]backupFile, error) {
+	var files []backupFile
+
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		crc, err := checksum(path)
+		if err != nil {
+			return err
+		}
+
+		f := backupFile{path: filepath.ToSlash(rel), size: uint64(fi.Size()), crc: crc}
+
+		switch filepath.Ext(path) {
+		case db.TABLE_SUFFIX, db.VALUE_LOG_SUFFIX:
+			base := filepath.Base(path)
+			f.stored = fmt.Sprintf("%s/%s_%d_%08x%s", SHARED_DIR, strings.TrimSuffix(base, filepath.Ext(base)), f.size, f.crc, filepath.Ext(base))
+
+			// already stored by another backup.
+			if _, err := os.Stat(filepath.Join(e.dir, f.stored)); err == nil {
+				files = append(files, f)
+				return nil
+			}
+		default:
+			f.stored = fmt.Sprintf("%s/%d/%s", PRIVATE_DIR, id, f.path)
+		}
+
+		if _, err := copyFile(path, filepath.Join(e.dir, f.stored)); err != nil {
+			return err
+		}
+		files = append(files, f)
+
+		return nil
+	})
+
+	return files, err
+}
+
+// ListBackups returns the backups, oldest first.
+func (e *Engine) ListBackups() ([]Info, error) {
+	e.rwmu.RLock()
+	defer e.rwmu.RUnlock()
+
+	ids, err := e.ids()
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []Info
+	for _, id := range ids {
+		timestamp, files, err := e.readMeta(id)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, newInfo(id, timestamp, files))
+	}
+
+	return infos, nil
+}
+
+// DeleteBackup removes backup id and the shared files no other backup
+// holds.
+func (e *Engine) DeleteBackup(id uint64) error {
+	e.rwmu.Lock()
+	defer e.rwmu.Unlock()
+
+	if err := os.Remove(e.metaPath(id)); os.IsNotExist(err) {
+		return ErrBackupNotFound
+	} else if err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(e.privateDir(id)); err != nil {
+		return err
+	}
+
+	return e.collectShared()
+}
+
+// RestoreBackup writes the files of backup id to dir, which must be empty
+// or missing, checking their checksums. dir can then be opened as a store.
+func (e *Engine) RestoreBackup(id uint64, dir string) error {
+	e.rwmu.RLock()
+	defer e.rwmu.RUnlock()
+
+	_, files, err := e.readMeta(id)
+	if err != nil {
+		return err
+	}
+
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return ErrRestoreTarget
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, f := range files {
+		dst := filepath.Join(dir, filepath.FromSlash(f.path))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+
+		crc, err := copyFile(filepath.Join(e.dir, f.stored), dst)
+		if err != nil {
+			return err
+		}
+
+		if crc != f.crc {
+			return fmt.Errorf("%w: %s: checksum mismatch", ErrCorruptBackup, f.stored)
+		}
+	}
+
+	return nil
+}
+
+// VerifyBackup checks the sizes and checksums of the files of backup id.
+func (e *Engine) VerifyBackup(id uint64) error {
+	e.rwmu.RLock()
+	defer e.rwmu.RUnlock()
+
+	_, files, err := e.readMeta(id)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		path := filepath.Join(e.dir, f.stored)
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrCorruptBackup, err)
+		}
+
+		if uint64(fi.Size()) != f.size {
+			return fmt.Errorf("%w: %s: size %d, expected %d", ErrCorruptBackup, f.stored, fi.Size(), f.size)
+		}
+
+		crc, err := checksum(path)
+		if err != nil {
+			return err
+		}
+
+		if crc != f.crc {
+			return fmt.Errorf("%w: %s: checksum mismatch", ErrCorruptBackup, f.stored)
+		}
+	}
+
+	return nil
+}
+
+func newInfo(id uint64, timestamp time.Time, files []backupFile) Info {
+	info := Info{ID: id, Timestamp: timestamp, NumFiles: len(files)}
+	for _, f := range files {
+		info.Size += f.size
+	}
+
+	return info
+}
+
+func (e *Engine) metaPath(id uint64) string {
+	return filepath.Join(e.dir, META_DIR, strconv.FormatUint(id, 10))
+}
+
+func (e *Engine) privateDir(id uint64) string {
+	return filepath.Join(e.dir, PRIVATE_DIR, strconv.FormatUint(id, 10))
+}
+
+// ids returns the ids of the backups in ascending order.
+func (e *Engine) ids() ([]uint64, error) {
+	entries, err := os.ReadDir(filepath.Join(e.dir, META_DIR))
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint64
+	for _, entry := range entries {
+		id, err := strconv.ParseUint(entry.Name(), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids, nil
+}
+
+// writeMeta records the files of a backup. The backup exists once its
+// meta file is renamed into place.
+//
+// The first line holds the Unix time of the backup, and each following
+// line the size, checksum, stored path and store path of a file.
+func (e *Engine) writeMeta(info Info, files []backupFile) error {
+	path := e.metaPath(info.ID)
+	tmp := path + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(f)
+	fmt.Fprintf(bw, "%d\n", info.Timestamp.UnixNano())
+	for _, file := range files {
+		fmt.Fprintf(bw, "%d %08x %s %s\n", file.size, file.crc, file.stored, file.path)
+	}
+
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func (e *Engine) readMeta(id uint64) (time.Time, []backupFile, error) {
+	f, err := os.Open(e.metaPath(id))
+	if os.IsNotExist(err) {
+		return time.Time{}, nil, ErrBackupNotFound
+	} else if err != nil {
+		return time.Time{}, nil, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	if !sc.Scan() {
+		return time.Time{}, nil, fmt.Errorf("%w: empty meta file of backup %d", ErrCorruptBackup, id)
+	}
+
+	nanos, err := strconv.ParseInt(sc.Text(), 10, 64)
+	if err != nil {
+		return time.Time{}, nil, fmt.Errorf("%w: %v", ErrCorruptBackup, err)
+	}
+
+	var files []backupFile
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 4 {
+			return time.Time{}, nil, fmt.Errorf("%w: malformed meta line %q", ErrCorruptBackup, sc.Text())
+		}
+
+		size, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return time.Time{}, nil, fmt.Errorf("%w: %v", ErrCorruptBackup, err)
+		}
+
+		crc, err := strconv.ParseUint(fields[1], 16, 32)
+		if err != nil {
+			return time.Time{}, nil, fmt.Errorf("%w: %v", ErrCorruptBackup, err)
+		}
+
+		files = append(files, backupFile{path: fields[3], stored: fields[2], size: size, crc: uint32(crc)})
+	}
+
+	if err := sc.Err(); err != nil {
+		return time.Time{}, nil, err
+	}
+
+	return time.Unix(0, nanos), files, nil
+}
+
+// collectShared removes the shared files no backup holds.
+func (e *Engine) collectShared() error {
+	ids, err := e.ids()
+	if err != nil {
+		return err
+	}
+
+	live := map[string]bool{}
+	for _, id := range ids {
+		_, files, err := e.readMeta(id)
+		if err != nil {
+			return err
+		}
+
+		for _, f := range files {
+			live[f.stored] = true
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(e.dir, SHARED_DIR))
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		stored := SHARED_DIR + "/" + entry.Name()
+		if live[stored] {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(e.dir, stored)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NumSharedFiles returns the number of tables and value logs stored for
+// all backups.
+func (e *Engine) NumSharedFiles() (int, error) {
+	e.rwmu.RLock()
+	defer e.rwmu.RUnlock()
+
+	entries, err := os.ReadDir(filepath.Join(e.dir, SHARED_DIR))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(entries), nil
+}
+
+func checksum(path string) (uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	crc := crc32.NewIEEE()
+	if _, err := io.Copy(crc, f); err != nil {
+		return 0, err
+	}
+
+	return crc.Sum32(), nil
+}
+
+// copyFile copies src to dst through a temporary file and returns the
+// checksum of the copied bytes.
+func copyFile(src, dst string) (uint32, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return 0, err
+	}
+
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, err
+	}
+
+	crc := crc32.NewIEEE()
+	if _, err := io.Copy(io.MultiWriter(out, crc), in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	return crc.Sum32(), os.Rename(tmp, dst)
+}
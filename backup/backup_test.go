@@ -0,0 +1,177 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/db"
+)
+
+func TestEngine(t *testing.T) {
+	for scenario, fn := range map[string]func(
+		t *testing.T, store *db.DB, e *Engine, dir string,
+	){
+		"CreateRestore": test_backup_CreateRestore,
+		"Delete":        test_backup_Delete,
+		"Verify":        test_backup_Verify,
+	} {
+		fn := fn // https://github.com/golang/go/wiki/CommonMistakes
+		t.Run(scenario, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "test_backup_")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			store, err := db.Open(filepath.Join(dir, "db"), nil)
+			require.NoError(t, err)
+			defer store.Close()
+
+			e, err := Open(filepath.Join(dir, "backup"))
+			require.NoError(t, err)
+
+			fn(t, store, e, dir)
+		})
+	}
+}
+
+func requireGet(t *testing.T, store *db.DB, key string, expected string, expectedFound bool) {
+	t.Helper()
+
+	value, err := store.Get([]byte(key))
+	if !expectedFound {
+		require.ErrorIs(t, err, db.ErrNotFound)
+		return
+	}
+
+	require.NoError(t, err)
+	require.Equal(t, []byte(expected), value)
+}
+
+func test_backup_CreateRestore(t *testing.T, store *db.DB, e *Engine, dir string) {
+	require.NoError(t, store.Put([]byte("a"), []byte("A")))
+	require.NoError(t, store.Flush())
+	require.NoError(t, store.Put([]byte("b"), []byte("B")))
+
+	first, err := e.CreateBackup(store)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), first.ID)
+
+	require.NoError(t, store.Put([]byte("c"), []byte("C")))
+	require.NoError(t, store.Flush())
+
+	second, err := e.CreateBackup(store)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), second.ID)
+
+	// the first table is stored once.
+	n, err := e.NumSharedFiles()
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	infos, err := e.ListBackups()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(infos))
+	require.Equal(t, first.ID, infos[0].ID)
+	require.Equal(t, first.NumFiles, infos[0].NumFiles)
+	require.Equal(t, first.Size, infos[0].Size)
+	require.True(t, first.Timestamp.Equal(infos[0].Timestamp))
+
+	for _, expected := range []struct {
+		id       uint64
+		c        string
+		cInStore bool
+	}{
+		{first.ID, "", false},
+		{second.ID, "C", true},
+	} {
+		target := filepath.Join(dir, "restore", strconv.FormatUint(expected.id, 10))
+		require.NoError(t, e.RestoreBackup(expected.id, target))
+
+		restored, err := db.Open(target, nil)
+		require.NoError(t, err)
+
+		requireGet(t, restored, "a", "A", true)
+		requireGet(t, restored, "b", "B", true)
+		requireGet(t, restored, "c", expected.c, expected.cInStore)
+		require.NoError(t, restored.Close())
+
+		require.ErrorIs(t, e.RestoreBackup(expected.id, target), ErrRestoreTarget)
+	}
+
+	require.ErrorIs(t, e.RestoreBackup(3, filepath.Join(dir, "missing")), ErrBackupNotFound)
+}
+
+func test_backup_Delete(t *testing.T, store *db.DB, e *Engine, dir string) {
+	require.NoError(t, store.Put([]byte("a"), []byte("A")))
+	require.NoError(t, store.Flush())
+
+	first, err := e.CreateBackup(store)
+	require.NoError(t, err)
+
+	// the table of the first backup is compacted away in the store.
+	require.NoError(t, store.Put([]byte("b"), []byte("B")))
+	require.NoError(t, store.Compact())
+
+	second, err := e.CreateBackup(store)
+	require.NoError(t, err)
+
+	n, err := e.NumSharedFiles()
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	require.NoError(t, e.DeleteBackup(first.ID))
+	require.ErrorIs(t, e.DeleteBackup(first.ID), ErrBackupNotFound)
+
+	n, err = e.NumSharedFiles()
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	infos, err := e.ListBackups()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(infos))
+	require.Equal(t, second.ID, infos[0].ID)
+	require.NoError(t, e.VerifyBackup(second.ID))
+
+	// ids are not reused.
+	third, err := e.CreateBackup(store)
+	require.NoError(t, err)
+	require.Equal(t, second.ID+1, third.ID)
+
+	require.NoError(t, e.DeleteBackup(second.ID))
+	require.NoError(t, e.DeleteBackup(third.ID))
+
+	n, err = e.NumSharedFiles()
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+}
+
+func test_backup_Verify(t *testing.T, store *db.DB, e *Engine, dir string) {
+	require.NoError(t, store.Put([]byte("a"), []byte("A")))
+	require.NoError(t, store.Flush())
+
+	info, err := e.CreateBackup(store)
+	require.NoError(t, err)
+	require.NoError(t, e.VerifyBackup(info.ID))
+	require.ErrorIs(t, e.VerifyBackup(info.ID+1), ErrBackupNotFound)
+
+	entries, err := os.ReadDir(filepath.Join(e.dir, SHARED_DIR))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(entries))
+	path := filepath.Join(e.dir, SHARED_DIR, entries[0].Name())
+
+	buf, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	// a flipped bit.
+	buf[0] ^= 0xff
+	require.NoError(t, os.WriteFile(path, buf, 0644))
+	require.ErrorIs(t, e.VerifyBackup(info.ID), ErrCorruptBackup)
+	require.ErrorIs(t, e.RestoreBackup(info.ID, filepath.Join(dir, "restore")), ErrCorruptBackup)
+
+	// a truncated file.
+	require.NoError(t, os.WriteFile(path, buf[:len(buf)-1], 0644))
+	require.ErrorIs(t, e.VerifyBackup(info.ID), ErrCorruptBackup)
+}
@@ -0,0 +1,66 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the current time. Stores read it through their options so
+// that tests can control time, e.g. to expire keys with a TTL.
+type Clock interface {
+	Now() time.Time
+}
+
+var (
+	// reads the system wall clock.
+	System Clock = systemClock{}
+)
+
+// OrDefault returns c, or System when c is nil.
+func OrDefault(c Clock) Clock {
+	if c == nil {
+		return System
+	}
+
+	return c
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Manual is a clock that only moves when told to.
+type Manual struct {
+	rwmu sync.RWMutex
+	now  time.Time
+}
+
+func NewManual(now time.Time) *Manual {
+	return &Manual{
+		now: now,
+	}
+}
+
+func (m *Manual) Now() time.Time {
+	m.rwmu.RLock()
+	defer m.rwmu.RUnlock()
+
+	return m.now
+}
+
+// Advance moves the clock forward by d.
+func (m *Manual) Advance(d time.Duration) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+
+	m.now = m.now.Add(d)
+}
+
+func (m *Manual) Set(now time.Time) {
+	m.rwmu.Lock()
+	defer m.rwmu.Unlock()
+
+	m.now = now
+}
@@ -0,0 +1,42 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClock(t *testing.T) {
+	for scenario, fn := range map[string]func(
+		t *testing.T,
+	){
+		"Manual":    test_Manual,
+		"OrDefault": test_OrDefault,
+	} {
+		fn := fn // https://github.com/golang/go/wiki/CommonMistakes
+		t.Run(scenario, func(t *testing.T) {
+			fn(t)
+		})
+	}
+}
+
+func test_Manual(t *testing.T) {
+	start := time.Unix(1000, 0)
+	c := NewManual(start)
+
+	require.Equal(t, start, c.Now())
+
+	c.Advance(time.Second)
+	require.Equal(t, start.Add(time.Second), c.Now())
+
+	c.Set(start)
+	require.Equal(t, start, c.Now())
+}
+
+func test_OrDefault(t *testing.T) {
+	require.Equal(t, System, OrDefault(nil))
+
+	c := NewManual(time.Unix(0, 0))
+	require.Equal(t, Clock(c), OrDefault(c))
+}
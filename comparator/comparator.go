@@ -0,0 +1,124 @@
+package comparator
+
+import (
+	"bytes"
+)
+
+// Comparator defines a total order over keys. Tables persist the name of
+// the comparator they were built with, so a store must always be opened
+// with a comparator of the same name.
+type Comparator interface {
+	// Compare returns -1, 0 or +1 depending on whether a is less than,
+	// equal to or greater than b.
+	Compare(a, b []byte) int
+	// Name identifies the ordering. It must change whenever the ordering
+	// changes.
+	Name() string
+	// FindShortestSeparator returns a short key k with start <= k < limit,
+	// used to shrink index keys. Returning start is always valid.
+	FindShortestSeparator(start, limit []byte) []byte
+	// FindShortSuccessor returns a short key k >= key. Returning key is
+	// always valid.
+	FindShortSuccessor(key []byte) []byte
+}
+
+// Normalizer is implemented by comparators under which distinct byte
+// strings can compare equal, e.g. case-insensitive ones. NormalizeKey maps
+// a key to a form shared by every key equal to it, which bloom filters
+// hash in place of the key.
+type Normalizer interface {
+	NormalizeKey(key []byte) []byte
+}
+
+var (
+	// orders keys lexicographically by byte value.
+	Bytewise Comparator = bytewiseComparator{}
+	// orders keys by descending byte value.
+	ReverseBytewise Comparator = reverseBytewiseComparator{}
+)
+
+// OrDefault returns cmp, or Bytewise when cmp is nil.
+func OrDefault(cmp Comparator) Comparator {
+	if cmp == nil {
+		return Bytewise
+	}
+
+	return cmp
+}
+
+// FilterKey returns the form of key that bloom filters hash under cmp:
+// the key itself when equal keys are equal bytes, as with Bytewise and
+// ReverseBytewise, or its normalized form under a Normalizer. It returns
+// false for other comparators, whose keys cannot be filtered.
+func FilterKey(cmp Comparator, key []byte) ([]byte, bool) {
+	switch cmp := OrDefault(cmp).(type) {
+	case bytewiseComparator, reverseBytewiseComparator:
+		return key, true
+	case Normalizer:
+		return cmp.NormalizeKey(key), true
+	}
+
+	return nil, false
+}
+
+type bytewiseComparator struct{}
+
+func (bytewiseComparator) Compare(a, b []byte) int {
+	return bytes.Compare(a, b)
+}
+
+func (bytewiseComparator) Name() string {
+	return "lsm.BytewiseComparator"
+}
+
+func (bytewiseComparator) FindShortestSeparator(start, limit []byte) []byte {
+	// find length of common prefix
+	n := 0
+	for n < len(start) && n < len(limit) && start[n] == limit[n] {
+		n += 1
+	}
+
+	// one is a prefix of the other, do not shorten.
+	if n >= len(start) || n >= len(limit) {
+		return append([]byte(nil), start...)
+	}
+
+	if c := start[n]; c < 0xff && c+1 < limit[n] {
+		sep := append([]byte(nil), start[:n+1]...)
+		sep[n] += 1
+		return sep
+	}
+
+	return append([]byte(nil), start...)
+}
+
+func (bytewiseComparator) FindShortSuccessor(key []byte) []byte {
+	// find the first byte that can be incremented and drop the rest.
+	for i, c := range key {
+		if c != 0xff {
+			succ := append([]byte(nil), key[:i+1]...)
+			succ[i] += 1
+			return succ
+		}
+	}
+
+	return append([]byte(nil), key...)
+}
+
+type reverseBytewiseComparator struct{}
+
+func (reverseBytewiseComparator) Compare(a, b []byte) int {
+	return bytes.Compare(b, a)
+}
+
+func (reverseBytewiseComparator) Name() string {
+	return "lsm.ReverseBytewiseComparator"
+}
+
+func (reverseBytewiseComparator) FindShortestSeparator(start, limit []byte) []byte {
+	return append([]byte(nil), start...)
+}
+
+func (reverseBytewiseComparator) FindShortSuccessor(key []byte) []byte {
+	return append([]byte(nil), key...)
+}
@@ -0,0 +1,109 @@
+package comparator
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestComparator(t *testing.T) {
+	for scenario, fn := range map[string]func(
+		t *testing.T,
+	){
+		"Bytewise":        test_Bytewise,
+		"ReverseBytewise": test_ReverseBytewise,
+		"OrDefault":       test_OrDefault,
+		"FilterKey":       test_FilterKey,
+	} {
+		fn := fn // https://github.com/golang/go/wiki/CommonMistakes
+		t.Run(scenario, func(t *testing.T) {
+			fn(t)
+		})
+	}
+}
+
+func test_Bytewise(t *testing.T) {
+	cmp := Bytewise
+
+	require.Equal(t, -1, cmp.Compare([]byte("a"), []byte("b")))
+	require.Equal(t, 0, cmp.Compare([]byte("a"), []byte("a")))
+	require.Equal(t, 1, cmp.Compare([]byte("ab"), []byte("a")))
+
+	for _, c := range []struct {
+		start, limit, expected string
+	}{
+		{"abc", "abz", "abd"},
+		{"abc", "abd", "abc"},
+		{"ab", "abc", "ab"},
+		{"a\xff", "b", "a\xff"},
+		{"apple", "banana", "apple"},
+		{"apple", "cherry", "b"},
+	} {
+		sep := cmp.FindShortestSeparator([]byte(c.start), []byte(c.limit))
+		require.Equal(t, []byte(c.expected), sep, "%q..%q", c.start, c.limit)
+		require.LessOrEqual(t, cmp.Compare([]byte(c.start), sep), 0)
+	}
+
+	require.Equal(t, []byte("b"), cmp.FindShortSuccessor([]byte("abc")))
+	require.Equal(t, []byte("\xff\x01"), cmp.FindShortSuccessor([]byte("\xff\x00\x00")))
+	require.Equal(t, []byte("\xff\xff"), cmp.FindShortSuccessor([]byte("\xff\xff")))
+}
+
+func test_ReverseBytewise(t *testing.T) {
+	cmp := ReverseBytewise
+
+	require.Equal(t, 1, cmp.Compare([]byte("a"), []byte("b")))
+	require.Equal(t, -1, cmp.Compare([]byte("b"), []byte("ab")))
+	require.NotEqual(t, Bytewise.Name(), cmp.Name())
+	require.Equal(t, []byte("b"), cmp.FindShortestSeparator([]byte("b"), []byte("a")))
+	require.Equal(t, []byte("b"), cmp.FindShortSuccessor([]byte("b")))
+}
+
+func test_OrDefault(t *testing.T) {
+	require.Equal(t, Bytewise, OrDefault(nil))
+	require.Equal(t, ReverseBytewise, OrDefault(ReverseBytewise))
+}
+
+// foldComparator orders keys case-insensitively.
+type foldComparator struct{}
+
+func (foldComparator) Compare(a, b []byte) int {
+	return bytes.Compare(bytes.ToLower(a), bytes.ToLower(b))
+}
+
+func (foldComparator) Name() string {
+	return "test.FoldComparator"
+}
+
+func (foldComparator) FindShortestSeparator(start, limit []byte) []byte {
+	return append([]byte(nil), start...)
+}
+
+func (foldComparator) FindShortSuccessor(key []byte) []byte {
+	return append([]byte(nil), key...)
+}
+
+type normalizedFoldComparator struct {
+	foldComparator
+}
+
+func (normalizedFoldComparator) NormalizeKey(key []byte) []byte {
+	return bytes.ToLower(key)
+}
+
+func test_FilterKey(t *testing.T) {
+	for _, cmp := range []Comparator{nil, Bytewise, ReverseBytewise} {
+		key, ok := FilterKey(cmp, []byte("Apple"))
+		require.True(t, ok)
+		require.Equal(t, []byte("Apple"), key)
+	}
+
+	// equal keys of other comparators may differ in bytes.
+	_, ok := FilterKey(foldComparator{}, []byte("Apple"))
+	require.False(t, ok)
+
+	key, ok := FilterKey(normalizedFoldComparator{}, []byte("Apple"))
+	require.True(t, ok)
+	require.Equal(t, []byte("apple"), key)
+}
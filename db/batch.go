@@ -0,0 +1,115 @@
+package db
+
+import (
+	"time"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/statistics"
+	"github.com/sosomasox/LSM-Tree-based-Storage/wal"
+)
+
+// WriteBatch collects writes to one or more column families applied
+// atomically by DB.Write: after a crash either all of them are recovered
+// or none is. A nil column family stands for the default one.
+type WriteBatch struct {
+	ops []batchOp
+}
+
+type batchOp struct {
+	cf     *ColumnFamily
+	recode wal.Recode
+	// expiration of OPE_PUT_TTL records, from the time of Write.
+	ttl time.Duration
+}
+
+func NewWriteBatch() *WriteBatch {
+	return &WriteBatch{}
+}
+
+// add records a copy of key and value so that the caller may reuse them.
+func (b *WriteBatch) add(cf *ColumnFamily, ope wal.OpeType, key, value []byte, ttl time.Duration) {
+	b.ops = append(b.ops, batchOp{
+		cf: cf,
+		recode: wal.Recode{
+			Ope:   ope,
+			Key:   append([]byte(nil), key...),
+			Value: append([]byte(nil), value...),
+		},
+		ttl: ttl,
+	})
+}
+
+func (b *WriteBatch) Put(cf *ColumnFamily, key, value []byte) {
+	b.add(cf, wal.OPE_PUT, key, value, 0)
+}
+
+func (b *WriteBatch) PutWithTTL(cf *ColumnFamily, key, value []byte, ttl time.Duration) {
+	b.add(cf, wal.OPE_PUT_TTL, key, value, ttl)
+}
+
+func (b *WriteBatch) Del(cf *ColumnFamily, key []byte) {
+	b.add(cf, wal.OPE_DEL, key, nil, 0)
+}
+
+func (b *WriteBatch) SingleDelete(cf *ColumnFamily, key []byte) {
+	b.add(cf, wal.OPE_SINGLE_DEL, key, nil, 0)
+}
+
+func (b *WriteBatch) DeleteRange(cf *ColumnFamily, start, end []byte) {
+	b.add(cf, wal.OPE_DEL_RANGE, start, end, 0)
+}
+
+func (b *WriteBatch) Merge(cf *ColumnFamily, key, operand []byte) {
+	b.add(cf, wal.OPE_MERGE, key, operand, 0)
+}
+
+// Len returns the number of writes in the batch.
+func (b *WriteBatch) Len() int {
+	return len(b.ops)
+}
+
+// Clear empties the batch so that it can be reused.
+func (b *WriteBatch) Clear() {
+	b.ops = b.ops[:0]
+}
+
+// Write applies the writes of b atomically, in order. Nothing is written
+// if any of them is invalid.
+func (db *DB) Write(b *WriteBatch) error {
+	defer db.opts.Statistics.RecordSince(statistics.WRITE_MICROS, time.Now())
+
+	db.rwmu.Lock()
+	defer db.rwmu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+
+	if len(b.ops) == 0 {
+		return nil
+	}
+
+	recodes := make([]wal.Recode, 0, len(b.ops))
+	for _, op := range b.ops {
+		cf := op.cf
+		if cf == nil {
+			cf = db.def
+		}
+
+		if err := db.check(cf, op.recode); err != nil {
+			return err
+		}
+
+		recode := op.recode
+		recode.ColumnFamily = cf.id
+		if recode.Ope == wal.OPE_PUT_TTL {
+			recode.ExpireAt = db.opts.Clock.Now().Add(op.ttl)
+		}
+		recodes = append(recodes, recode)
+	}
+
+	return db.apply(recodes)
+}
@@ -0,0 +1,158 @@
+package db
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrCheckpointExists = errors.New("db: checkpoint directory already exists")
+)
+
+// CreateCheckpoint writes to dir a copy of the store that can be opened as
+// an independent store. Tables and value logs, which are never modified,
+// are hard-linked when dir is on the same file system and copied
+// otherwise. The WALs holding unflushed records are copied up to their
+// current end, so the checkpoint holds every write made before the call
+// without flushing the memtables. Writes are blocked while the files are
+// linked and copied.
+func (db *DB) CreateCheckpoint(dir string) error {
+	db.rwmu.Lock()
+	defer db.rwmu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+
+	if _, err := os.Stat(dir); err == nil {
+		return ErrCheckpointExists
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	// the checkpoint only appears once complete.
+	tmp := dir + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+
+	if err := db.checkpointTo(tmp); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, dir)
+}
+
+func (db *DB) checkpointTo(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	for _, cf := range db.families {
+		rel, err := filepath.Rel(db.dir, cf.dir)
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dir, rel)
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+
+		for _, tf := range cf.tables {
+			if err := linkOrCopy(tf.path, filepath.Join(target, filepath.Base(tf.path))); err != nil {
+				return err
+			}
+		}
+
+		cf.vlogmu.RLock()
+		var paths []string
+		for number := range cf.vlogs {
+			paths = append(paths, cf.valueLogPath(number))
+		}
+		cf.vlogmu.RUnlock()
+
+		for _, path := range paths {
+			if err := linkOrCopy(path, filepath.Join(target, filepath.Base(path))); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, number := range db.replayed {
+		path := db.logPath(number)
+		if err := copyFile(path, filepath.Join(dir, filepath.Base(path)), -1); err != nil {
+			return err
+		}
+	}
+
+	// the current WAL is still appended to; its records up to now are
+	// all complete as writes are blocked.
+	path := db.logPath(db.logNumber)
+	if err := copyFile(path, filepath.Join(dir, filepath.Base(path)), int64(db.log.Size())); err != nil {
+		return err
+	}
+
+	m := &manifest{nextID: db.nextFamilyID}
+	for _, cf := range db.families {
+		m.families = append(m.families, manifestFamily{id: cf.id, name: cf.name})
+	}
+
+	return m.write(dir)
+}
+
+// linkOrCopy hard-links src to dst, copying it if links are not possible.
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	return copyFile(src, dst, -1)
+}
+
+// syncDir syncs dir, so that files linked, renamed or created in it
+// survive a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// copyFile copies the first n bytes of src to dst, or all of it if n is
+// negative, and syncs dst.
+func copyFile(src, dst string, n int64) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = in
+	if n >= 0 {
+		r = io.LimitReader(in, n)
+	}
+
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
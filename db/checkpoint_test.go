@@ -0,0 +1,72 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpoint(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test_db_checkpoint_")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "db")
+	dst := filepath.Join(dir, "checkpoint")
+
+	db, err := Open(src, vlogOptions())
+	require.NoError(t, err)
+	defer db.Close()
+
+	users, err := db.CreateColumnFamily("users", nil)
+	require.NoError(t, err)
+
+	// flushed, with a value in a value log.
+	require.NoError(t, db.Put([]byte("a"), []byte(blob('a'))))
+	require.NoError(t, users.Put([]byte("u1"), []byte("alice")))
+	require.NoError(t, db.Flush())
+
+	// only in the WAL.
+	require.NoError(t, db.Put([]byte("b"), []byte("B")))
+	require.NoError(t, users.Put([]byte("u2"), []byte("bob")))
+
+	require.NoError(t, db.CreateCheckpoint(dst))
+	require.ErrorIs(t, db.CreateCheckpoint(dst), ErrCheckpointExists)
+
+	// tables are shared with the store.
+	srcInfo, err := os.Stat(db.def.tables[0].path)
+	require.NoError(t, err)
+	dstInfo, err := os.Stat(filepath.Join(dst, filepath.Base(db.def.tables[0].path)))
+	require.NoError(t, err)
+	require.True(t, os.SameFile(srcInfo, dstInfo))
+
+	// writes after the checkpoint are not in it.
+	require.NoError(t, db.Put([]byte("c"), []byte("C")))
+	require.NoError(t, db.Del([]byte("a")))
+	require.NoError(t, db.Compact())
+
+	cp, err := Open(dst, vlogOptions())
+	require.NoError(t, err)
+	defer cp.Close()
+
+	require.Equal(t, []string{DEFAULT_COLUMN_FAMILY, "users"}, cp.ListColumnFamilies())
+	cpUsers, ok := cp.ColumnFamily("users")
+	require.True(t, ok)
+
+	requireGet(t, cp, "a", blob('a'), true)
+	requireGet(t, cp, "b", "B", true)
+	requireGet(t, cp, "c", "", false)
+	requireGetCF(t, cpUsers, "u1", "alice", true)
+	requireGetCF(t, cpUsers, "u2", "bob", true)
+
+	// the checkpoint is independent of the store.
+	require.NoError(t, cp.Put([]byte("d"), []byte("D")))
+	require.NoError(t, cp.Compact())
+	require.NoError(t, cp.CollectValueLogs(0))
+
+	requireGet(t, cp, "a", blob('a'), true)
+	requireGet(t, db, "a", "", false)
+	requireGet(t, db, "d", "", false)
+}
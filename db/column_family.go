@@ -0,0 +1,907 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/logging"
+	"github.com/sosomasox/LSM-Tree-based-Storage/memtable"
+	"github.com/sosomasox/LSM-Tree-based-Storage/merge"
+	"github.com/sosomasox/LSM-Tree-based-Storage/sstable"
+	"github.com/sosomasox/LSM-Tree-based-Storage/statistics"
+	"github.com/sosomasox/LSM-Tree-based-Storage/status"
+	"github.com/sosomasox/LSM-Tree-based-Storage/vlog"
+	"github.com/sosomasox/LSM-Tree-based-Storage/wal"
+)
+
+const (
+	// suffix of the directories holding the tables of column families
+	// other than the default one, whose tables are in the store directory.
+	COLUMN_FAMILY_SUFFIX string = ".cf"
+)
+
+var (
+	ErrColumnFamilyExists      = errors.New("db: column family already exists")
+	ErrColumnFamilyDropped     = errors.New("db: column family dropped")
+	ErrUnknownColumnFamily     = errors.New("db: unknown column family")
+	ErrInvalidColumnFamilyName = errors.New("db: invalid column family name")
+	ErrDropDefaultColumnFamily = errors.New("db: the default column family cannot be dropped")
+)
+
+// ColumnFamily is a named keyspace of a store with its own memtable, tables
+// and options. Column families share the WAL of the store, so a WriteBatch
+// can update several of them atomically.
+type ColumnFamily struct {
+	db   *DB
+	id   uint32
+	name string
+	// directory holding the tables.
+	dir  string
+	opts *Options
+
+	mem memtable.MemTableRep
+	// tables, newest first.
+	tables  []*tableFile
+	tcache  *sstable.TableCache
+	dropped bool
+
+	// value logs by number, guarded by vlogmu as iterators read them
+	// without holding the lock of the store.
+	vlogmu sync.RWMutex
+	vlogs  map[uint64]*valueLog
+}
+
+// newColumnFamily returns an empty column family. Options left nil default
+// to those of the store; the clock, statistics and logger are always the
+// store's.
+func (db *DB) newColumnFamily(id uint32, name string, opts *Options) *ColumnFamily {
+	if opts == nil {
+		opts = db.opts
+	} else {
+		o := *opts
+		o.Clock = db.opts.Clock
+		o.Statistics = db.opts.Statistics
+		o.Logger = db.opts.Logger
+		opts = o.sanitize()
+	}
+
+	dir := db.dir
+	if id != 0 {
+		dir = filepath.Join(db.dir, fmt.Sprintf("%06d%s", id, COLUMN_FAMILY_SUFFIX))
+	}
+
+	return &ColumnFamily{
+		db:     db,
+		id:     id,
+		name:   name,
+		dir:    dir,
+		opts:   opts,
+		mem:    memtable.NewRepWithOptions(opts.MemTable),
+		tcache: sstable.NewTableCache(opts.Table),
+		vlogs:  make(map[uint64]*valueLog),
+	}
+}
+
+// load adds the tables and value logs in the directory of the column
+// family and returns the newest WAL the tables cover.
+func (cf *ColumnFamily) load() (uint64, error) {
+	if err := os.MkdirAll(cf.dir, 0755); err != nil {
+		return 0, err
+	}
+
+	_, tables, err := listFiles(cf.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var flushed uint64
+	for _, number := range tables {
+		t := &tableFile{number: number, path: cf.tablePath(number)}
+
+		h, err := cf.tcache.Acquire(t.path)
+		if err != nil {
+			return 0, err
+		}
+		if v, ok := h.Table().Property(PROP_LOG_NUMBER); ok {
+			t.logNumber, _ = strconv.ParseUint(string(v), 10, 64)
+		}
+		h.Release()
+
+		if t.logNumber > flushed {
+			flushed = t.logNumber
+		}
+
+		cf.tables = append([]*tableFile{t}, cf.tables...)
+		cf.db.bumpNumber(number)
+	}
+
+	if err := cf.loadValueLogs(); err != nil {
+		return 0, err
+	}
+
+	return flushed, nil
+}
+
+// listColumnFamilyDirs returns the ids of the column family directories in
+// dir.
+func listColumnFamilyDirs(dir string) ([]uint32, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint32
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() || filepath.Ext(name) != COLUMN_FAMILY_SUFFIX {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, COLUMN_FAMILY_SUFFIX), 10, 32)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, uint32(id))
+	}
+
+	return ids, nil
+}
+
+func (cf *ColumnFamily) tablePath(number uint64) string {
+	return filepath.Join(cf.dir, fmt.Sprintf("%06d%s", number, TABLE_SUFFIX))
+}
+
+// Name returns the name of the column family.
+func (cf *ColumnFamily) Name() string {
+	return cf.name
+}
+
+func (cf *ColumnFamily) Put(key, value []byte) error {
+	return cf.write(wal.Recode{Ope: wal.OPE_PUT, Key: key, Value: value})
+}
+
+// PutWithTTL stores value under key for ttl. Once expired the key reads
+// as deleted and is dropped by compaction.
+func (cf *ColumnFamily) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	expireAt := cf.opts.Clock.Now().Add(ttl)
+
+	return cf.write(wal.Recode{Ope: wal.OPE_PUT_TTL, Key: key, Value: value, ExpireAt: expireAt})
+}
+
+func (cf *ColumnFamily) Del(key []byte) error {
+	return cf.write(wal.Recode{Ope: wal.OPE_DEL, Key: key})
+}
+
+// SingleDelete deletes a key that was written exactly once with Put or
+// PutWithTTL. Unlike the tombstone of Del, which must be kept until the
+// bottommost compaction, it is dropped together with the value by the first
+// compaction seeing both. Reads are undefined if the key was written more
+// than once, merged, or also deleted with Del or DeleteRange.
+func (cf *ColumnFamily) SingleDelete(key []byte) error {
+	return cf.write(wal.Recode{Ope: wal.OPE_SINGLE_DEL, Key: key})
+}
+
+// DeleteRange deletes every key in [start, end) with a single WAL record
+// and range tombstone.
+func (cf *ColumnFamily) DeleteRange(start, end []byte) error {
+	return cf.write(wal.Recode{Ope: wal.OPE_DEL_RANGE, Key: start, Value: end})
+}
+
+// Merge records operand as an update of key, resolved by the merge
+// operator when the key is read or compacted. Unlike Get followed by Put
+// it never reads the current value and cannot race other writers.
+func (cf *ColumnFamily) Merge(key, operand []byte) error {
+	return cf.write(wal.Recode{Ope: wal.OPE_MERGE, Key: key, Value: operand})
+}
+
+func (cf *ColumnFamily) write(recode wal.Recode) error {
+	db := cf.db
+	defer db.opts.Statistics.RecordSince(statistics.WRITE_MICROS, time.Now())
+
+	db.rwmu.Lock()
+	defer db.rwmu.Unlock()
+
+	if err := db.check(cf, recode); err != nil {
+		return err
+	}
+
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+	recode.ColumnFamily = cf.id
+
+	return db.apply([]wal.Recode{recode})
+}
+
+// validate returns the error the record would cause in the column family.
+func (cf *ColumnFamily) validate(recode wal.Recode) error {
+	switch recode.Ope {
+	case wal.OPE_MERGE:
+		if cf.opts.MergeOperator == nil {
+			return ErrNoMergeOperator
+		}
+	case wal.OPE_DEL_RANGE:
+		if cf.opts.Comparator.Compare(recode.Key, recode.Value) >= 0 {
+			return sstable.ErrEmptyRange
+		}
+	}
+
+	return nil
+}
+
+// Get returns the value of key, applying pending merge operands found in
+// the memtable and tables. It returns ErrNotFound when the key has no
+// value, an error wrapping ErrCorruption when a table or value log it reads
+// does not decode, and wraps the errors of reading them otherwise.
+func (cf *ColumnFamily) Get(key []byte) (value []byte, err error) {
+	stats := cf.opts.Statistics
+	defer stats.RecordSince(statistics.GET_MICROS, time.Now())
+
+	cf.db.rwmu.RLock()
+	defer cf.db.rwmu.RUnlock()
+
+	if err := cf.db.check(cf, wal.Recode{}); err != nil {
+		return nil, err
+	}
+
+	var gs getStats
+	value, found, err := cf.get(key, &gs)
+	if err != nil {
+		return nil, err
+	}
+
+	stats.Add(statistics.KEYS_READ, 1)
+	stats.Add(statistics.TABLE_READS, gs.tables)
+	if gs.memHit {
+		stats.Add(statistics.MEMTABLE_HITS, 1)
+	} else {
+		stats.Add(statistics.MEMTABLE_MISSES, 1)
+	}
+	if found {
+		stats.Add(statistics.KEYS_FOUND, 1)
+		stats.Add(statistics.BYTES_READ, uint64(len(value)))
+		stats.Record(statistics.BYTES_PER_READ, uint64(len(value)))
+	}
+	stats.Add(statistics.TOP_BYTES_READ, gs.topBytes)
+	stats.Add(statistics.BOTTOM_BYTES_READ, gs.bottomBytes)
+
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	return value, nil
+}
+
+// getStats tells how a Get was served.
+type getStats struct {
+	// the memtable settled the key.
+	memHit bool
+	// tables the key was looked up in.
+	tables uint64
+	// bytes of the entries found in the tables above the bottommost one,
+	// and in the bottommost one.
+	topBytes    uint64
+	bottomBytes uint64
+}
+
+func (cf *ColumnFamily) get(key []byte, gs *getStats) (value []byte, found bool, err error) {
+	// operands of newer entries, oldest first.
+	var operands [][]byte
+
+	value, ops, t, found := cf.mem.Lookup(key)
+	operands = ops
+	if found && t != sstable.MERGE {
+		gs.memHit = true
+		return cf.resolve(key, value, t, operands)
+	}
+
+	for i, tf := range cf.tables {
+		h, err := cf.tcache.Acquire(tf.path)
+		if err != nil {
+			return nil, false, readError(err, tf.path)
+		}
+
+		gs.tables += 1
+		value, t, err := h.Table().GetEntry(key, nil)
+		// values may alias the table's mapping, which is unmapped once
+		// the table is evicted.
+		value = append([]byte(nil), value...)
+		found := err == nil
+		// the table's range tombstones only cover older tables.
+		covered := errors.Is(err, sstable.ErrNotFound) && sstable.Covers(cf.opts.Comparator, h.Table().RangeTombstones(), key)
+		h.Release()
+
+		if err != nil && !errors.Is(err, sstable.ErrNotFound) {
+			return nil, false, readError(err, tf.path)
+		}
+
+		if covered {
+			return cf.resolve(key, nil, sstable.TOMBSTONE, operands)
+		}
+
+		if !found {
+			continue
+		}
+
+		if t == sstable.VALUE_POINTER {
+			if value, err = cf.readValue(value); err != nil {
+				return nil, false, readError(err, tf.path)
+			}
+			t = sstable.NO_TOMBSTONE
+		}
+
+		if i == len(cf.tables)-1 {
+			gs.bottomBytes += uint64(len(value))
+		} else {
+			gs.topBytes += uint64(len(value))
+		}
+
+		if t != sstable.MERGE {
+			value, t, err := cf.unexpire(value, t)
+			if err != nil {
+				return nil, false, readError(err, tf.path)
+			}
+			return cf.resolve(key, value, t, operands)
+		}
+
+		ops, err := merge.DecodeOperands(value)
+		if err != nil {
+			return nil, false, readError(err, tf.path)
+		}
+		operands = append(ops, operands...)
+	}
+
+	return cf.resolve(key, nil, sstable.TOMBSTONE, operands)
+}
+
+// readError wraps an error of reading a key from the table at path, or
+// from a value log it points to. Data that does not decode is reported as
+// ErrCorruption.
+func readError(err error, path string) error {
+	if errors.Is(err, sstable.ErrCorruption) || errors.Is(err, vlog.ErrCorruptRecord) ||
+		errors.Is(err, vlog.ErrInvalidPointer) || errors.Is(err, merge.ErrTruncated) {
+		return fmt.Errorf("%w: %s: %w", ErrCorruption, path, err)
+	}
+
+	return fmt.Errorf("db: read %s: %w", path, status.IO(err))
+}
+
+// unexpire converts an EXPIRING entry into a value, or a tombstone once it
+// has expired. Other entries are returned as is.
+func (cf *ColumnFamily) unexpire(value []byte, t sstable.TombstoneType) ([]byte, sstable.TombstoneType, error) {
+	if t != sstable.EXPIRING {
+		return value, t, nil
+	}
+
+	value, expireAt, err := sstable.DecodeExpiring(value)
+	if err != nil {
+		return nil, t, err
+	}
+
+	if sstable.Expired(expireAt, cf.opts.Clock.Now()) {
+		return []byte(""), sstable.TOMBSTONE, nil
+	}
+
+	return value, sstable.NO_TOMBSTONE, nil
+}
+
+// resolve applies operands to the base entry of key.
+func (cf *ColumnFamily) resolve(key, value []byte, t sstable.TombstoneType, operands [][]byte) ([]byte, bool, error) {
+	deleted := t == sstable.TOMBSTONE || t == sstable.SINGLE_DELETE
+
+	if len(operands) == 0 {
+		if deleted {
+			return []byte(""), false, nil
+		}
+		return value, true, nil
+	}
+
+	if cf.opts.MergeOperator == nil {
+		return nil, false, ErrNoMergeOperator
+	}
+
+	if deleted {
+		value = nil
+	}
+
+	value, err := cf.opts.MergeOperator.FullMerge(key, value, operands)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return value, true, nil
+}
+
+// empty reports whether the memtable has nothing to flush.
+func (cf *ColumnFamily) empty() bool {
+	usage := cf.mem.MemoryUsage()
+
+	return usage.Entries == 0 && usage.RangeTombstones == 0
+}
+
+// flushMemTable writes the memtable to a new table recording the current
+// WAL number, then replaces it with an empty one.
+func (cf *ColumnFamily) flushMemTable(reason FlushReason) error {
+	db := cf.db
+	start := time.Now()
+
+	number := db.nextNumber
+	db.nextNumber += 1
+
+	t := &tableFile{number: number, path: cf.tablePath(number), logNumber: db.logNumber}
+
+	info := FlushInfo{ColumnFamily: cf.name, Path: t.path, TableNumber: number, Reason: reason}
+	db.notify(func(l EventListener) { l.OnFlushBegin(info) })
+
+	tw, err := cf.writeMemTable(t)
+	if err != nil {
+		cf.opts.Logger.Error("flush failed", logging.KEY_COLUMN_FAMILY, cf.name, logging.KEY_REASON, reason.String(), logging.KEY_ERROR, err)
+		db.setBackgroundError(cf, BACKGROUND_ERROR_FLUSH, err)
+		return err
+	}
+
+	info.Size = tw.size()
+	info.NumEntries = tw.b.NumEntries()
+	info.Duration = time.Since(start)
+
+	stats := cf.opts.Statistics
+	stats.Add(statistics.FLUSHES, 1)
+	stats.Add(statistics.FLUSH_BYTES_WRITTEN, info.Size)
+	// the first table of a column family is its bottommost one.
+	if len(cf.tables) == 0 {
+		stats.Add(statistics.BOTTOM_BYTES_WRITTEN, info.Size)
+	} else {
+		stats.Add(statistics.TOP_BYTES_WRITTEN, info.Size)
+	}
+	stats.RecordSince(statistics.FLUSH_MICROS, start)
+
+	cf.opts.Logger.Info("memtable flushed", logging.KEY_COLUMN_FAMILY, cf.name, logging.KEY_REASON, reason.String(),
+		logging.KEY_PATH, t.path, "entries", info.NumEntries, "bytes", info.Size, "duration", info.Duration)
+
+	cf.tables = append([]*tableFile{t}, cf.tables...)
+	cf.mem = memtable.NewRepWithOptions(cf.opts.MemTable)
+
+	created := TableFileInfo{ColumnFamily: cf.name, Path: t.path, Number: number, Size: tw.b.FileSize(), Reason: TABLE_FILE_REASON_FLUSH}
+	db.notify(func(l EventListener) {
+		l.OnTableFileCreated(created)
+		l.OnFlushCompleted(info)
+	})
+
+	return nil
+}
+
+// writeMemTable writes the memtable to t.
+func (cf *ColumnFamily) writeMemTable(t *tableFile) (*tableWriter, error) {
+	b, err := sstable.NewBuilder(t.path, cf.opts.Table)
+	if err != nil {
+		return nil, err
+	}
+	b.SetProperty(PROP_LOG_NUMBER, []byte(strconv.FormatUint(t.logNumber, 10)))
+
+	tw := cf.newTableWriter(b, nil)
+	if err := cf.flushTo(tw); err != nil {
+		tw.abandon()
+		return nil, err
+	}
+
+	if err := tw.finish(); err != nil {
+		return nil, err
+	}
+
+	return tw, nil
+}
+
+// Compact flushes the memtables and merges every table of the column
+// family into one. As the output holds the oldest data, merge operands are
+// fully resolved, and tombstones, single deletes, range tombstones, expired
+// entries and the entries they cover are dropped.
+func (cf *ColumnFamily) Compact() error {
+	db := cf.db
+
+	db.rwmu.Lock()
+	defer db.rwmu.Unlock()
+
+	if err := db.check(cf, wal.Recode{}); err != nil {
+		return err
+	}
+
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+
+	if err := db.flush(FLUSH_REASON_MANUAL); err != nil {
+		return err
+	}
+
+	return cf.compact(cf.tables, nil, COMPACTION_REASON_MANUAL)
+}
+
+// maybeCompact merges the tables flushed over the oldest one once there
+// are CompactionTrigger of them. The oldest table is only rewritten by
+// Compact.
+func (cf *ColumnFamily) maybeCompact() error {
+	if len(cf.tables)-1 < cf.opts.CompactionTrigger {
+		return nil
+	}
+
+	return cf.compact(cf.tables[:len(cf.tables)-1], nil, COMPACTION_REASON_TRIGGER)
+}
+
+// compact merges inputs, the newest tables, into one table. Merging every
+// table is a bottommost compaction; otherwise the output keeps what shadows
+// the older tables. Values in the value logs of relocate are moved to a new
+// value log.
+func (cf *ColumnFamily) compact(inputs []*tableFile, relocate map[uint64]bool, reason CompactionReason) error {
+	if len(inputs) == 0 {
+		return nil
+	}
+	db := cf.db
+	start := time.Now()
+
+	number := db.nextNumber
+	db.nextNumber += 1
+
+	out := &tableFile{number: number, path: cf.tablePath(number)}
+
+	info := CompactionInfo{
+		ColumnFamily: cf.name,
+		Output:       out.path,
+		Bottommost:   len(inputs) == len(cf.tables),
+		Reason:       reason,
+	}
+	sizes := make([]uint64, len(inputs))
+	for i, tf := range inputs {
+		if tf.logNumber > out.logNumber {
+			out.logNumber = tf.logNumber
+		}
+
+		if fi, err := os.Stat(tf.path); err == nil {
+			sizes[i] = uint64(fi.Size())
+		}
+		info.Inputs = append(info.Inputs, tf.path)
+		info.InputBytes += sizes[i]
+	}
+	db.notify(func(l EventListener) { l.OnCompactionBegin(info) })
+
+	tw, err := cf.writeCompaction(out, inputs, relocate, info.Bottommost)
+	if err != nil {
+		cf.opts.Logger.Error("compaction failed", logging.KEY_COLUMN_FAMILY, cf.name, logging.KEY_REASON, reason.String(), logging.KEY_ERROR, err)
+		db.setBackgroundError(cf, BACKGROUND_ERROR_COMPACTION, err)
+		return err
+	}
+
+	info.OutputBytes = tw.size()
+	info.NumEntries = tw.b.NumEntries()
+	info.Duration = time.Since(start)
+
+	stats := cf.opts.Statistics
+	stats.Add(statistics.COMPACTIONS, 1)
+	stats.Add(statistics.COMPACTION_BYTES_READ, info.InputBytes)
+	stats.Add(statistics.COMPACTION_BYTES_WRITTEN, info.OutputBytes)
+	// only a bottommost compaction reads and rewrites the bottommost table.
+	if info.Bottommost {
+		stats.Add(statistics.TOP_BYTES_READ, info.InputBytes-sizes[len(sizes)-1])
+		stats.Add(statistics.BOTTOM_BYTES_READ, sizes[len(sizes)-1])
+		stats.Add(statistics.BOTTOM_BYTES_WRITTEN, info.OutputBytes)
+	} else {
+		stats.Add(statistics.TOP_BYTES_READ, info.InputBytes)
+		stats.Add(statistics.TOP_BYTES_WRITTEN, info.OutputBytes)
+	}
+	stats.RecordSince(statistics.COMPACTION_MICROS, start)
+
+	cf.opts.Logger.Info("tables compacted", logging.KEY_COLUMN_FAMILY, cf.name, logging.KEY_REASON, reason.String(),
+		logging.KEY_PATH, out.path, "inputs", len(inputs), "bottommost", info.Bottommost,
+		"bytes_read", info.InputBytes, "bytes_written", info.OutputBytes, "entries", info.NumEntries, "duration", info.Duration)
+
+	cf.tables = append([]*tableFile{out}, cf.tables[len(inputs):]...)
+
+	created := TableFileInfo{ColumnFamily: cf.name, Path: out.path, Number: number, Size: tw.b.FileSize(), Reason: TABLE_FILE_REASON_COMPACTION}
+	db.notify(func(l EventListener) { l.OnTableFileCreated(created) })
+
+	for i, tf := range inputs {
+		cf.tcache.Evict(tf.path)
+		if err := os.Remove(tf.path); err != nil {
+			return err
+		}
+		cf.opts.Logger.Debug("table removed", logging.KEY_COLUMN_FAMILY, cf.name, logging.KEY_PATH, tf.path)
+
+		deleted := TableFileInfo{ColumnFamily: cf.name, Path: tf.path, Number: tf.number, Size: sizes[i], Reason: TABLE_FILE_REASON_COMPACTION}
+		db.notify(func(l EventListener) { l.OnTableFileDeleted(deleted) })
+	}
+
+	db.notify(func(l EventListener) { l.OnCompactionCompleted(info) })
+
+	return nil
+}
+
+// writeCompaction merges inputs into out.
+func (cf *ColumnFamily) writeCompaction(out *tableFile, inputs []*tableFile, relocate map[uint64]bool, bottommost bool) (*tableWriter, error) {
+	b, err := sstable.NewBuilder(out.path, cf.opts.Table)
+	if err != nil {
+		return nil, err
+	}
+	b.SetProperty(PROP_LOG_NUMBER, []byte(strconv.FormatUint(out.logNumber, 10)))
+
+	tw := cf.newTableWriter(b, relocate)
+	if err := cf.compactTo(tw, inputs, bottommost); err != nil {
+		tw.abandon()
+		return nil, err
+	}
+
+	if err := tw.finish(); err != nil {
+		return nil, err
+	}
+
+	return tw, nil
+}
+
+// compactTo adds the live entries of inputs to tw, along with their range
+// tombstones unless bottommost.
+func (cf *ColumnFamily) compactTo(tw *tableWriter, inputs []*tableFile, bottommost bool) error {
+	it, err := cf.newLiveIterator(false, inputs, bottommost)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	for it.HasNext() {
+		e, err := it.Next()
+		if err != nil {
+			return err
+		}
+
+		if err := tw.add(e.Key, e.Value, e.Type); err != nil {
+			return err
+		}
+	}
+
+	if bottommost {
+		return nil
+	}
+
+	for _, rangeDels := range it.rangeDels {
+		for _, rt := range rangeDels {
+			if err := tw.b.AddRangeTombstone(rt.Start, rt.End); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// flushTo adds the entries and range tombstones of the memtable to tw.
+func (cf *ColumnFamily) flushTo(tw *tableWriter) error {
+	for itr := cf.mem.NewIterator(); itr.HasNext(); {
+		key, value, t, err := itr.NextEntry()
+		if err != nil {
+			return err
+		}
+
+		if err := tw.add(key, value, t); err != nil {
+			return err
+		}
+	}
+
+	for _, rt := range cf.mem.RangeTombstones() {
+		if err := tw.b.AddRangeTombstone(rt.Start, rt.End); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NumTables returns the number of tables in the column family.
+func (cf *ColumnFamily) NumTables() int {
+	cf.db.rwmu.RLock()
+	defer cf.db.rwmu.RUnlock()
+
+	return len(cf.tables)
+}
+
+// ColumnFamilyMetrics describes the state of a column family.
+type ColumnFamilyMetrics struct {
+	// approximate bytes held by the memtable.
+	MemTableSize uint64
+	NumTables    int
+	// bytes of the tables and value logs.
+	TableBytes    uint64
+	ValueLogBytes uint64
+	// bytes of the tables flushed over the oldest one, which the next
+	// compaction merges.
+	PendingCompactionBytes uint64
+}
+
+// Metrics returns the state of the column family.
+func (cf *ColumnFamily) Metrics() (ColumnFamilyMetrics, error) {
+	db := cf.db
+
+	db.rwmu.RLock()
+	defer db.rwmu.RUnlock()
+
+	if err := db.check(cf, wal.Recode{}); err != nil {
+		return ColumnFamilyMetrics{}, err
+	}
+
+	m := ColumnFamilyMetrics{
+		MemTableSize: cf.mem.Size(),
+		NumTables:    len(cf.tables),
+	}
+
+	for i, tf := range cf.tables {
+		fi, err := os.Stat(tf.path)
+		if err != nil {
+			return ColumnFamilyMetrics{}, err
+		}
+
+		m.TableBytes += uint64(fi.Size())
+		if i < len(cf.tables)-1 {
+			m.PendingCompactionBytes += uint64(fi.Size())
+		}
+	}
+
+	cf.vlogmu.RLock()
+	for _, vl := range cf.vlogs {
+		m.ValueLogBytes += vl.r.Size()
+	}
+	cf.vlogmu.RUnlock()
+
+	return m, nil
+}
+
+// CreateColumnFamily creates a column family named name. Options left nil
+// default to those of the store; they are not persisted and must be given
+// again through Options.ColumnFamilies when the store is reopened.
+func (db *DB) CreateColumnFamily(name string, opts *Options) (*ColumnFamily, error) {
+	db.rwmu.Lock()
+	defer db.rwmu.Unlock()
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+
+	if err := db.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	if name == "" || strings.ContainsAny(name, "\r\n") {
+		return nil, ErrInvalidColumnFamilyName
+	}
+
+	if _, ok := db.family(name); ok {
+		return nil, ErrColumnFamilyExists
+	}
+
+	cf := db.newColumnFamily(db.nextFamilyID, name, opts)
+	if _, err := cf.load(); err != nil {
+		cf.tcache.Close()
+		return nil, err
+	}
+
+	db.families = append(db.families, cf)
+	db.nextFamilyID += 1
+
+	if err := db.writeManifest(); err != nil {
+		db.families = db.families[:len(db.families)-1]
+		cf.tcache.Close()
+		os.RemoveAll(cf.dir)
+		return nil, err
+	}
+	db.opts.Logger.Info("column family created", logging.KEY_COLUMN_FAMILY, name, logging.KEY_PATH, cf.dir)
+
+	return cf, nil
+}
+
+// DropColumnFamily removes the column family and its data. The handle can
+// no longer be used.
+func (db *DB) DropColumnFamily(cf *ColumnFamily) error {
+	db.rwmu.Lock()
+	defer db.rwmu.Unlock()
+
+	if err := db.check(cf, wal.Recode{}); err != nil {
+		return err
+	}
+
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+
+	if cf.id == 0 {
+		return ErrDropDefaultColumnFamily
+	}
+
+	families := db.families
+	db.families = nil
+	for _, f := range families {
+		if f != cf {
+			db.families = append(db.families, f)
+		}
+	}
+
+	if err := db.writeManifest(); err != nil {
+		db.families = families
+		return err
+	}
+
+	// records of the column family left in the WAL are skipped by replay
+	// as its id is never reused.
+	cf.dropped = true
+	cf.tcache.Close()
+	cf.closeValueLogs()
+
+	if err := os.RemoveAll(cf.dir); err != nil {
+		return err
+	}
+	db.opts.Logger.Info("column family dropped", logging.KEY_COLUMN_FAMILY, cf.name, "tables", len(cf.tables))
+
+	for _, tf := range cf.tables {
+		deleted := TableFileInfo{ColumnFamily: cf.name, Path: tf.path, Number: tf.number, Reason: TABLE_FILE_REASON_DROP_COLUMN_FAMILY}
+		db.notify(func(l EventListener) { l.OnTableFileDeleted(deleted) })
+	}
+
+	return nil
+}
+
+// ListColumnFamilies returns the names of the column families, in creation
+// order.
+func (db *DB) ListColumnFamilies() []string {
+	db.rwmu.RLock()
+	defer db.rwmu.RUnlock()
+
+	var names []string
+	for _, cf := range db.families {
+		names = append(names, cf.name)
+	}
+
+	return names
+}
+
+// ColumnFamily returns the column family named name.
+func (db *DB) ColumnFamily(name string) (*ColumnFamily, bool) {
+	db.rwmu.RLock()
+	defer db.rwmu.RUnlock()
+
+	return db.family(name)
+}
+
+// DefaultColumnFamily returns the column family of the keys written
+// through the methods of DB.
+func (db *DB) DefaultColumnFamily() *ColumnFamily {
+	return db.def
+}
+
+func (db *DB) family(name string) (*ColumnFamily, bool) {
+	for _, cf := range db.families {
+		if cf.name == name {
+			return cf, true
+		}
+	}
+
+	return nil, false
+}
+
+func (db *DB) familyByID(id uint32) (*ColumnFamily, bool) {
+	for _, cf := range db.families {
+		if cf.id == id {
+			return cf, true
+		}
+	}
+
+	return nil, false
+}
+
+func (db *DB) writeManifest() error {
+	m := &manifest{nextID: db.nextFamilyID}
+	for _, cf := range db.families {
+		m.families = append(m.families, manifestFamily{id: cf.id, name: cf.name})
+	}
+
+	return m.write(db.dir)
+}
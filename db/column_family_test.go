@@ -0,0 +1,223 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/sstable"
+)
+
+func TestColumnFamily(t *testing.T) {
+	for scenario, fn := range map[string]func(
+		t *testing.T, dir string,
+	){
+		"CreateDropList": test_cf_CreateDropList,
+		"WriteBatch":     test_cf_WriteBatch,
+		"Options":        test_cf_Options,
+	} {
+		fn := fn // https://github.com/golang/go/wiki/CommonMistakes
+		t.Run(scenario, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "test_cf_")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			fn(t, dir)
+		})
+	}
+}
+
+func requireGetCF(t *testing.T, cf *ColumnFamily, key string, expected string, expectedFound bool) {
+	t.Helper()
+
+	value, err := cf.Get([]byte(key))
+	if !expectedFound {
+		require.ErrorIs(t, err, ErrNotFound)
+		return
+	}
+
+	require.NoError(t, err)
+	require.Equal(t, []byte(expected), value)
+}
+
+func test_cf_CreateDropList(t *testing.T, dir string) {
+	db, err := Open(dir, nil)
+	require.NoError(t, err)
+
+	users, err := db.CreateColumnFamily("users", nil)
+	require.NoError(t, err)
+	sessions, err := db.CreateColumnFamily("sessions", nil)
+	require.NoError(t, err)
+
+	_, err = db.CreateColumnFamily("users", nil)
+	require.ErrorIs(t, err, ErrColumnFamilyExists)
+	_, err = db.CreateColumnFamily("", nil)
+	require.ErrorIs(t, err, ErrInvalidColumnFamilyName)
+
+	require.Equal(t, []string{DEFAULT_COLUMN_FAMILY, "users", "sessions"}, db.ListColumnFamilies())
+
+	// the same key in every column family.
+	require.NoError(t, db.Put([]byte("k"), []byte("default")))
+	require.NoError(t, users.Put([]byte("k"), []byte("users")))
+	require.NoError(t, sessions.Put([]byte("k"), []byte("sessions")))
+	require.NoError(t, db.Flush())
+	require.NoError(t, users.Put([]byte("l"), []byte("users")))
+
+	requireGet(t, db, "k", "default", true)
+	requireGetCF(t, users, "k", "users", true)
+	requireGetCF(t, sessions, "k", "sessions", true)
+	require.Equal(t, 1, sessions.NumTables())
+
+	require.ErrorIs(t, db.DropColumnFamily(db.DefaultColumnFamily()), ErrDropDefaultColumnFamily)
+	require.NoError(t, db.DropColumnFamily(sessions))
+	require.ErrorIs(t, sessions.Put([]byte("k"), []byte("v")), ErrColumnFamilyDropped)
+	_, err = sessions.Get([]byte("k"))
+	require.ErrorIs(t, err, ErrColumnFamilyDropped)
+
+	_, err = os.Stat(sessions.dir)
+	require.True(t, os.IsNotExist(err))
+
+	require.NoError(t, db.Close())
+
+	// the column families are persisted in the manifest, and the records
+	// of users are replayed from the shared WAL.
+	names, err := ListColumnFamilies(dir)
+	require.NoError(t, err)
+	require.Equal(t, []string{DEFAULT_COLUMN_FAMILY, "users"}, names)
+
+	db, err = Open(dir, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.Equal(t, []string{DEFAULT_COLUMN_FAMILY, "users"}, db.ListColumnFamilies())
+
+	users, ok := db.ColumnFamily("users")
+	require.True(t, ok)
+	_, ok = db.ColumnFamily("sessions")
+	require.False(t, ok)
+
+	requireGet(t, db, "k", "default", true)
+	requireGetCF(t, users, "k", "users", true)
+	requireGetCF(t, users, "l", "users", true)
+
+	// ids of dropped column families are not reused.
+	sessions, err = db.CreateColumnFamily("sessions", nil)
+	require.NoError(t, err)
+	requireGetCF(t, sessions, "k", "", false)
+}
+
+func test_cf_WriteBatch(t *testing.T, dir string) {
+	db, err := Open(dir, nil)
+	require.NoError(t, err)
+
+	users, err := db.CreateColumnFamily("users", nil)
+	require.NoError(t, err)
+	index, err := db.CreateColumnFamily("index", nil)
+	require.NoError(t, err)
+
+	require.NoError(t, users.Put([]byte("u2"), []byte("bob")))
+
+	b := NewWriteBatch()
+	b.Put(users, []byte("u1"), []byte("alice"))
+	b.Put(index, []byte("alice"), []byte("u1"))
+	b.Del(users, []byte("u2"))
+	b.Put(nil, []byte("count"), []byte("1"))
+	require.Equal(t, 4, b.Len())
+	require.NoError(t, db.Write(b))
+
+	check := func() {
+		requireGetCF(t, users, "u1", "alice", true)
+		requireGetCF(t, users, "u2", "", false)
+		requireGetCF(t, index, "alice", "u1", true)
+		requireGet(t, db, "count", "1", true)
+	}
+	check()
+
+	// an invalid write leaves the whole batch unapplied.
+	b.Clear()
+	b.Put(users, []byte("u3"), []byte("carol"))
+	b.Merge(index, []byte("carol"), []byte("u3"))
+	require.ErrorIs(t, db.Write(b), ErrNoMergeOperator)
+	requireGetCF(t, users, "u3", "", false)
+
+	require.NoError(t, db.Close())
+	require.ErrorIs(t, db.Write(b), ErrClosed)
+
+	db, err = Open(dir, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	users, _ = db.ColumnFamily("users")
+	index, _ = db.ColumnFamily("index")
+	check()
+
+	// a flush covers every column family, so the WAL can go.
+	require.NoError(t, db.Flush())
+	require.NoError(t, db.Close())
+
+	logs, _, err := listFiles(dir)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(logs))
+
+	db, err = Open(dir, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	users, _ = db.ColumnFamily("users")
+	index, _ = db.ColumnFamily("index")
+	check()
+
+	// column families of another store are rejected.
+	other, err := Open(filepath.Join(dir, "other"), nil)
+	require.NoError(t, err)
+	defer other.Close()
+
+	b.Clear()
+	b.Put(users, []byte("u4"), []byte("dave"))
+	require.ErrorIs(t, other.Write(b), ErrUnknownColumnFamily)
+}
+
+func test_cf_Options(t *testing.T, dir string) {
+	counters := mergeOptions()
+
+	opts := DefaultOptions()
+	opts.ColumnFamilies = map[string]*Options{"counters": counters}
+
+	db, err := Open(dir, opts)
+	require.NoError(t, err)
+
+	cf, err := db.CreateColumnFamily("counters", counters)
+	require.NoError(t, err)
+
+	require.NoError(t, cf.Merge([]byte("a"), []byte("1")))
+	require.NoError(t, db.Flush())
+	require.NoError(t, cf.Merge([]byte("a"), []byte("2")))
+	require.ErrorIs(t, db.Merge([]byte("a"), []byte("1")), ErrNoMergeOperator)
+
+	requireGetCF(t, cf, "a", "3", true)
+	require.NoError(t, db.Close())
+
+	db, err = Open(dir, opts)
+	require.NoError(t, err)
+	defer db.Close()
+
+	cf, ok := db.ColumnFamily("counters")
+	require.True(t, ok)
+	requireGetCF(t, cf, "a", "3", true)
+
+	// compaction resolves the operands with the column family's operator.
+	require.NoError(t, cf.Compact())
+	require.Equal(t, 1, cf.NumTables())
+	requireGetCF(t, cf, "a", "3", true)
+
+	h, err := cf.tcache.Acquire(cf.tables[0].path)
+	require.NoError(t, err)
+	defer h.Release()
+
+	value, ty, err := h.Table().GetEntry([]byte("a"), nil)
+	require.NoError(t, err)
+	require.Equal(t, sstable.NO_TOMBSTONE, ty)
+	require.Equal(t, []byte("3"), value)
+}
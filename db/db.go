@@ -0,0 +1,711 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/clock"
+	"github.com/sosomasox/LSM-Tree-based-Storage/comparator"
+	"github.com/sosomasox/LSM-Tree-based-Storage/logging"
+	"github.com/sosomasox/LSM-Tree-based-Storage/memtable"
+	"github.com/sosomasox/LSM-Tree-based-Storage/merge"
+	"github.com/sosomasox/LSM-Tree-based-Storage/sstable"
+	"github.com/sosomasox/LSM-Tree-based-Storage/statistics"
+	"github.com/sosomasox/LSM-Tree-based-Storage/status"
+	"github.com/sosomasox/LSM-Tree-based-Storage/wal"
+)
+
+const (
+	LOG_SUFFIX   string = ".log"
+	TABLE_SUFFIX string = ".sst"
+	// file locked by the handle that has the store open.
+	LOCK_FILE string = "LOCK"
+	// table property holding the number of the newest WAL whose records
+	// are all in the table or older tables.
+	PROP_LOG_NUMBER string = "lsm.log_number"
+
+	DEFAULT_MEMTABLE_SIZE      uint64 = 4 << 20 // Byte
+	DEFAULT_COMPACTION_TRIGGER int    = 4
+	DEFAULT_TARGET_FILE_SIZE   uint64 = 64 << 20 // Byte
+)
+
+var (
+	ErrClosed          = fmt.Errorf("db: %w", status.ErrClosed)
+	ErrNoMergeOperator = errors.New("db: merge without a merge operator")
+	// the key has no value.
+	ErrNotFound = fmt.Errorf("db: %w", status.ErrNotFound)
+	// a table or value log holds data that does not decode.
+	ErrCorruption = fmt.Errorf("db: %w", status.ErrCorruption)
+	// writes are refused since a flush or compaction failed, until Resume.
+	ErrReadOnly = fmt.Errorf("db: %w", status.ErrReadOnly)
+	// the store is open in another handle.
+	ErrBusy = fmt.Errorf("db: %w", status.ErrBusy)
+)
+
+type Options struct {
+	MemTable *memtable.Options
+	Table    *sstable.Options
+	// the memtable is flushed to a table once it holds this many bytes.
+	MemTableSize uint64
+	// once this many tables are flushed over the oldest one, they are
+	// merged into a single table.
+	CompactionTrigger int
+	// resolves Merge operands on reads, flushes and compactions.
+	MergeOperator merge.Operator
+	// order of keys in memtables and tables; defaults to
+	// comparator.Bytewise.
+	Comparator comparator.Comparator
+	// values of at least this many bytes are moved out of tables into
+	// value logs on flush and compaction, so that compactions only
+	// rewrite pointers to them; 0 keeps every value in the tables.
+	// Expiring values always stay in the tables.
+	ValueThreshold int
+	// tables written by ExportRange are split once they reach this many
+	// bytes.
+	TargetFileSize uint64
+	// expires keys written with PutWithTTL; defaults to clock.System.
+	Clock clock.Clock
+	// counts the events of the store, read with GetStatistics; nil counts
+	// nothing.
+	Statistics *statistics.Statistics
+	// notified of flushes, compactions and file changes of every column
+	// family; those of the options of other column families are ignored.
+	EventListeners []EventListener
+	// receives records of recovery, flushes, compactions, file changes and
+	// corruption, and is handed to the WALs and tables; defaults to
+	// logging.Discard.
+	Logger *slog.Logger
+	// options of the column families other than the default one, by
+	// name, when the store is opened. Missing ones default to these
+	// options.
+	ColumnFamilies map[string]*Options
+}
+
+func DefaultOptions() *Options {
+	return &Options{
+		MemTable:          memtable.DefaultOptions(),
+		Table:             sstable.DefaultOptions(),
+		MemTableSize:      DEFAULT_MEMTABLE_SIZE,
+		CompactionTrigger: DEFAULT_COMPACTION_TRIGGER,
+		TargetFileSize:    DEFAULT_TARGET_FILE_SIZE,
+		Statistics:        statistics.New(),
+	}
+}
+
+// tableFile is a table owned by the store.
+type tableFile struct {
+	number uint64
+	path   string
+	// newest WAL covered by the table.
+	logNumber uint64
+	// span of the keys, read on demand by IngestExternalFiles.
+	keyRange *keyRange
+}
+
+// DB is a store made of a WAL shared by column families, each with a
+// memtable and tables flushed from it. The methods of DB operate on the
+// default column family.
+type DB struct {
+	rwmu sync.RWMutex
+	dir  string
+	opts *Options
+
+	log       *wal.WAL
+	logNumber uint64
+	// WALs replayed by Open, removed once their records are flushed.
+	replayed []uint64
+
+	// column families in creation order, the default one first.
+	families     []*ColumnFamily
+	def          *ColumnFamily
+	nextFamilyID uint32
+	// next file number for WALs and tables.
+	nextNumber uint64
+	closed     bool
+	lock       *os.File
+	// error of the flush or compaction that made the store read-only.
+	bgErr error
+}
+
+// Open opens the store in dir, creating it if needed, and replays the WALs
+// that were not flushed yet. It fails with ErrBusy while another handle
+// has the store open.
+func Open(dir string, opts *Options) (_ *DB, err error) {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+	opts = opts.sanitize()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, status.IO(err)
+	}
+	opts.Logger.Info("opening store", logging.KEY_PATH, dir)
+
+	lock, err := lockFile(filepath.Join(dir, LOCK_FILE))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			unlockFile(lock)
+		}
+	}()
+
+	db := &DB{
+		dir:        dir,
+		opts:       opts,
+		nextNumber: 1,
+		lock:       lock,
+	}
+
+	m, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	db.nextFamilyID = m.nextID
+
+	// newest WAL covered by the tables of each column family.
+	flushed := map[uint32]uint64{}
+	for _, family := range m.families {
+		cf := db.newColumnFamily(family.id, family.name, opts.ColumnFamilies[family.name])
+		db.families = append(db.families, cf)
+
+		if flushed[cf.id], err = cf.load(); err != nil {
+			db.closeTables()
+			return nil, err
+		}
+	}
+
+	def, ok := db.familyByID(0)
+	if !ok {
+		db.closeTables()
+		return nil, ErrCorruptManifest
+	}
+	db.def = def
+
+	// the directories of column families dropped before their data was
+	// removed.
+	ids, err := listColumnFamilyDirs(dir)
+	if err != nil {
+		db.closeTables()
+		return nil, err
+	}
+	for _, id := range ids {
+		if _, ok := db.familyByID(id); !ok {
+			path := filepath.Join(dir, fmt.Sprintf("%06d%s", id, COLUMN_FAMILY_SUFFIX))
+			opts.Logger.Info("removing dropped column family", logging.KEY_PATH, path)
+			os.RemoveAll(path)
+		}
+	}
+
+	logs, _, err := listFiles(dir)
+	if err != nil {
+		db.closeTables()
+		return nil, err
+	}
+
+	// WALs whose records are flushed in every column family.
+	minFlushed := flushed[0]
+	for _, number := range flushed {
+		if number < minFlushed {
+			minFlushed = number
+		}
+	}
+
+	for i, number := range logs {
+		db.bumpNumber(number)
+
+		if number <= minFlushed {
+			opts.Logger.Info("removing flushed wal", logging.KEY_NUMBER, number)
+			os.Remove(db.logPath(number))
+			continue
+		}
+
+		opts.Logger.Info("replaying wal", logging.KEY_NUMBER, number)
+		// only the newest WAL can end in a record cut short by a crash.
+		if err := db.replay(number, flushed, i == len(logs)-1); err != nil {
+			opts.Logger.Error("recovery failed", logging.KEY_NUMBER, number, logging.KEY_ERROR, err)
+			db.closeTables()
+			return nil, err
+		}
+	}
+
+	if err := db.newLog(); err != nil {
+		db.closeTables()
+		return nil, err
+	}
+
+	if err := db.writeManifest(); err != nil {
+		db.closeTables()
+		return nil, err
+	}
+
+	numTables := 0
+	for _, cf := range db.families {
+		numTables += len(cf.tables)
+	}
+	opts.Logger.Info("store opened", logging.KEY_PATH, dir, "column_families", len(db.families), "tables", numTables, "replayed_wals", len(db.replayed))
+
+	return db, nil
+}
+
+// sanitize returns a copy of opts with defaults filled in and the
+// comparator and merge operator shared by memtables and tables.
+func (opts *Options) sanitize() *Options {
+	o := *opts
+
+	if o.MemTable == nil {
+		o.MemTable = memtable.DefaultOptions()
+	} else {
+		mo := *o.MemTable
+		o.MemTable = &mo
+	}
+
+	if o.Table == nil {
+		o.Table = sstable.DefaultOptions()
+	} else {
+		to := *o.Table
+		o.Table = &to
+	}
+
+	if o.MemTableSize == 0 {
+		o.MemTableSize = DEFAULT_MEMTABLE_SIZE
+	}
+
+	if o.CompactionTrigger <= 0 {
+		o.CompactionTrigger = DEFAULT_COMPACTION_TRIGGER
+	}
+
+	if o.TargetFileSize == 0 {
+		o.TargetFileSize = DEFAULT_TARGET_FILE_SIZE
+	}
+
+	o.Comparator = comparator.OrDefault(o.Comparator)
+	o.MemTable.Comparator = o.Comparator
+	o.MemTable.MergeOperator = o.MergeOperator
+	o.Table.Comparator = o.Comparator
+
+	o.Clock = clock.OrDefault(o.Clock)
+	o.MemTable.Clock = o.Clock
+	o.Table.Clock = o.Clock
+
+	o.Table.Statistics = o.Statistics
+
+	o.Logger = logging.OrDefault(o.Logger)
+	o.Table.Logger = o.Logger
+
+	return &o
+}
+
+// listFiles returns the numbers of the WALs and tables in dir, in
+// ascending order.
+func listFiles(dir string) (logs, tables []uint64, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		ext := filepath.Ext(name)
+
+		number, err := strconv.ParseUint(strings.TrimSuffix(name, ext), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		switch ext {
+		case LOG_SUFFIX:
+			logs = append(logs, number)
+		case TABLE_SUFFIX:
+			tables = append(tables, number)
+		}
+	}
+
+	sort.Slice(logs, func(i, j int) bool { return logs[i] < logs[j] })
+	sort.Slice(tables, func(i, j int) bool { return tables[i] < tables[j] })
+
+	return logs, tables, nil
+}
+
+func (db *DB) bumpNumber(number uint64) {
+	if number >= db.nextNumber {
+		db.nextNumber = number + 1
+	}
+}
+
+func (db *DB) logPath(number uint64) string {
+	return filepath.Join(db.dir, fmt.Sprintf("%06d%s", number, LOG_SUFFIX))
+}
+
+// replay applies the records of a WAL left by a previous run to the
+// memtables of the column families that have not flushed them yet. A
+// record cut short at the end of the newest WAL is truncated away.
+func (db *DB) replay(number uint64, flushed map[uint32]uint64, newest bool) error {
+	flag, replay := os.O_RDONLY, wal.Replay
+	if newest {
+		flag, replay = os.O_RDWR, wal.ReplayTruncate
+	}
+
+	f, err := os.OpenFile(db.logPath(number), flag, 0600)
+	if err != nil {
+		return status.IO(err)
+	}
+
+	l, err := wal.NewWithOptions(f, db.walOptions())
+	if err != nil {
+		f.Close()
+		return err
+	}
+	defer l.Close()
+
+	err = replay(l, func(recode wal.Recode) {
+		// records of dropped column families are skipped.
+		cf, ok := db.familyByID(recode.ColumnFamily)
+		if ok && number > flushed[cf.id] {
+			recode.Apply(cf.mem)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	db.logNumber = number
+	db.replayed = append(db.replayed, number)
+
+	return nil
+}
+
+func (db *DB) walOptions() *wal.Options {
+	return &wal.Options{Logger: db.opts.Logger}
+}
+
+// newLog starts a new WAL. Older WALs stay until the memtable is flushed.
+func (db *DB) newLog() error {
+	number := db.nextNumber
+	db.nextNumber += 1
+
+	f, err := os.OpenFile(db.logPath(number), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return status.IO(err)
+	}
+
+	l, err := wal.NewWithOptions(f, db.walOptions())
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	db.log = l
+	db.logNumber = number
+	db.opts.Logger.Debug("wal created", logging.KEY_NUMBER, number)
+
+	info := WALInfo{Path: db.logPath(number), Number: number}
+	db.notify(func(l EventListener) { l.OnWALCreated(info) })
+
+	return nil
+}
+
+func (db *DB) Put(key, value []byte) error {
+	return db.def.Put(key, value)
+}
+
+// PutWithTTL stores value under key for ttl. Once expired the key reads
+// as deleted and is dropped by compaction.
+func (db *DB) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	return db.def.PutWithTTL(key, value, ttl)
+}
+
+func (db *DB) Del(key []byte) error {
+	return db.def.Del(key)
+}
+
+// SingleDelete deletes a key that was written exactly once. See
+// ColumnFamily.SingleDelete.
+func (db *DB) SingleDelete(key []byte) error {
+	return db.def.SingleDelete(key)
+}
+
+// DeleteRange deletes every key in [start, end) with a single WAL record
+// and range tombstone.
+func (db *DB) DeleteRange(start, end []byte) error {
+	return db.def.DeleteRange(start, end)
+}
+
+// Merge records operand as an update of key. See ColumnFamily.Merge.
+func (db *DB) Merge(key, operand []byte) error {
+	return db.def.Merge(key, operand)
+}
+
+// Get returns the value of key, applying pending merge operands found in
+// the memtable and tables. See ColumnFamily.Get.
+func (db *DB) Get(key []byte) (value []byte, err error) {
+	return db.def.Get(key)
+}
+
+// check returns the error of applying recode to cf. It must be called with
+// the lock held.
+func (db *DB) check(cf *ColumnFamily, recode wal.Recode) error {
+	if db.closed {
+		return ErrClosed
+	}
+
+	if cf.db != db {
+		return ErrUnknownColumnFamily
+	}
+
+	if cf.dropped {
+		return ErrColumnFamilyDropped
+	}
+
+	return cf.validate(recode)
+}
+
+// checkWritable returns ErrReadOnly, wrapping the background error, once
+// a flush or compaction has failed. It must be called with the lock held.
+func (db *DB) checkWritable() error {
+	if db.bgErr != nil {
+		return fmt.Errorf("%w: %w", ErrReadOnly, db.bgErr)
+	}
+
+	return nil
+}
+
+// setBackgroundError makes the store read-only after a flush or
+// compaction of cf failed, and notifies the event listeners. It must be
+// called with the write lock held.
+func (db *DB) setBackgroundError(cf *ColumnFamily, reason BackgroundErrorReason, err error) {
+	if db.bgErr == nil {
+		db.bgErr = err
+		db.opts.Logger.Error("store read-only", logging.KEY_COLUMN_FAMILY, cf.name, logging.KEY_REASON, reason.String(), logging.KEY_ERROR, err)
+	}
+
+	info := BackgroundErrorInfo{ColumnFamily: cf.name, Reason: reason, Err: err}
+	db.notify(func(l EventListener) { l.OnBackgroundError(info) })
+}
+
+// BackgroundError returns the error of the flush or compaction that made
+// the store read-only, or nil.
+func (db *DB) BackgroundError() error {
+	db.rwmu.RLock()
+	defer db.rwmu.RUnlock()
+
+	return db.bgErr
+}
+
+// Resume clears the background error and flushes the memtables and
+// compacts the column families again. The store stays read-only if they
+// fail; it is a no-op when there is no background error.
+func (db *DB) Resume() error {
+	db.rwmu.Lock()
+	defer db.rwmu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+
+	if db.bgErr == nil {
+		return nil
+	}
+	db.opts.Logger.Info("resuming store", logging.KEY_ERROR, db.bgErr)
+	db.bgErr = nil
+
+	if err := db.flush(FLUSH_REASON_ERROR_RECOVERY); err != nil {
+		return err
+	}
+
+	// the flush only compacts when a memtable was flushed.
+	for _, cf := range db.families {
+		if err := cf.maybeCompact(); err != nil {
+			return err
+		}
+	}
+	db.opts.Logger.Info("store resumed", logging.KEY_PATH, db.dir)
+
+	return nil
+}
+
+// apply logs recodes and applies them to the memtables of their column
+// families, flushing once a memtable is full. A single record of the
+// default column family is logged on its own, others in a batch.
+func (db *DB) apply(recodes []wal.Recode) error {
+	recode := recodes[0]
+	if len(recodes) > 1 || recode.ColumnFamily != 0 {
+		recode = wal.Recode{Ope: wal.OPE_BATCH, Batch: recodes}
+	}
+
+	size := db.log.Size()
+	if err := db.log.Append(recode); err != nil {
+		return err
+	}
+
+	stats := db.opts.Statistics
+	stats.Add(statistics.WAL_SYNCS, 1)
+	stats.Add(statistics.WAL_BYTES_WRITTEN, db.log.Size()-size)
+
+	var bytes uint64
+	for _, recode := range recodes {
+		bytes += uint64(len(recode.Key) + len(recode.Value))
+	}
+	stats.Add(statistics.KEYS_WRITTEN, uint64(len(recodes)))
+	stats.Add(statistics.BYTES_WRITTEN, bytes)
+	stats.Record(statistics.BYTES_PER_WRITE, bytes)
+
+	full := false
+	for _, recode := range recodes {
+		cf, _ := db.familyByID(recode.ColumnFamily)
+		recode.Apply(cf.mem)
+
+		full = full || cf.mem.Size() >= cf.opts.MemTableSize
+	}
+
+	if full {
+		// the write waits for the flush.
+		db.notify(func(l EventListener) {
+			l.OnStallConditionsChanged(StallInfo{Previous: STALL_CONDITION_NORMAL, Current: STALL_CONDITION_STOPPED})
+		})
+		defer db.notify(func(l EventListener) {
+			l.OnStallConditionsChanged(StallInfo{Previous: STALL_CONDITION_STOPPED, Current: STALL_CONDITION_NORMAL})
+		})
+
+		return db.flush(FLUSH_REASON_MEMTABLE_FULL)
+	}
+
+	return nil
+}
+
+// Flush writes the memtables to new tables and starts a new WAL.
+func (db *DB) Flush() error {
+	db.rwmu.Lock()
+	defer db.rwmu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+
+	return db.flush(FLUSH_REASON_MANUAL)
+}
+
+// flush must be called with the write lock held. As the column families
+// share the WAL, all of them are flushed before it is removed.
+func (db *DB) flush(reason FlushReason) error {
+	empty := true
+	for _, cf := range db.families {
+		empty = empty && cf.empty()
+	}
+
+	if empty {
+		return nil
+	}
+
+	old := db.log
+	for _, cf := range db.families {
+		if cf.empty() {
+			continue
+		}
+
+		if err := cf.flushMemTable(reason); err != nil {
+			return err
+		}
+	}
+
+	if err := db.newLog(); err != nil {
+		return err
+	}
+
+	for _, number := range db.replayed {
+		if err := os.Remove(db.logPath(number)); err != nil && !os.IsNotExist(err) {
+			return status.IO(err)
+		}
+		db.opts.Logger.Debug("wal removed", logging.KEY_NUMBER, number)
+	}
+	db.replayed = nil
+
+	if err := wal.Destroy(old); err != nil {
+		return err
+	}
+
+	for _, cf := range db.families {
+		if err := cf.maybeCompact(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Compact flushes the memtables and compacts every column family. See
+// ColumnFamily.Compact.
+func (db *DB) Compact() error {
+	db.rwmu.Lock()
+	defer db.rwmu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+
+	if err := db.flush(FLUSH_REASON_MANUAL); err != nil {
+		return err
+	}
+
+	for _, cf := range db.families {
+		if err := cf.compact(cf.tables, nil, COMPACTION_REASON_MANUAL); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NumTables returns the number of tables in the default column family.
+func (db *DB) NumTables() int {
+	return db.def.NumTables()
+}
+
+// GetStatistics returns a snapshot of the statistics of the store, all
+// zero when Options.Statistics is nil.
+func (db *DB) GetStatistics() statistics.Snapshot {
+	return db.opts.Statistics.Snapshot()
+}
+
+// Close closes the WAL and the tables, and releases the store to other
+// handles. Unflushed records are replayed by the next Open.
+func (db *DB) Close() error {
+	db.rwmu.Lock()
+	defer db.rwmu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	db.closed = true
+
+	db.closeTables()
+	err := db.log.Close()
+	unlockFile(db.lock)
+	db.opts.Logger.Info("store closed", logging.KEY_PATH, db.dir)
+
+	return err
+}
+
+func (db *DB) closeTables() {
+	for _, cf := range db.families {
+		cf.tcache.Close()
+		cf.closeValueLogs()
+	}
+}
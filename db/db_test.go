@@ -0,0 +1,824 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/clock"
+	"github.com/sosomasox/LSM-Tree-based-Storage/comparator"
+	"github.com/sosomasox/LSM-Tree-based-Storage/merge"
+	"github.com/sosomasox/LSM-Tree-based-Storage/sstable"
+	"github.com/sosomasox/LSM-Tree-based-Storage/statistics"
+	"github.com/sosomasox/LSM-Tree-based-Storage/status"
+)
+
+func TestDB(t *testing.T) {
+	for scenario, fn := range map[string]func(
+		t *testing.T, dir string,
+	){
+		"PutGetDel":    test_db_PutGetDel,
+		"Reopen":       test_db_Reopen,
+		"TornWAL":      test_db_TornWAL,
+		"Merge":        test_db_Merge,
+		"Compact":      test_db_Compact,
+		"TTL":          test_db_TTL,
+		"DeleteRange":  test_db_DeleteRange,
+		"SingleDelete": test_db_SingleDelete,
+		"Statistics":   test_db_Statistics,
+		"Tiers":        test_db_Tiers,
+		"Logger":       test_db_Logger,
+		"ReadErrors":   test_db_ReadErrors,
+		"ReadOnly":     test_db_ReadOnly,
+		"Busy":         test_db_Busy,
+		"Comparator":   test_db_Comparator,
+	} {
+		fn := fn // https://github.com/golang/go/wiki/CommonMistakes
+		t.Run(scenario, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "test_db_")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			fn(t, dir)
+		})
+	}
+}
+
+func mergeOptions() *Options {
+	opts := DefaultOptions()
+	opts.MergeOperator = merge.Uint64Add{}
+
+	return opts
+}
+
+func requireGet(t *testing.T, db *DB, key string, expected string, expectedFound bool) {
+	t.Helper()
+
+	value, err := db.Get([]byte(key))
+	if !expectedFound {
+		require.ErrorIs(t, err, ErrNotFound)
+		return
+	}
+
+	require.NoError(t, err)
+	require.Equal(t, []byte(expected), value)
+}
+
+func test_db_PutGetDel(t *testing.T, dir string) {
+	db, err := Open(dir, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.Put([]byte("a"), []byte("A")))
+	require.NoError(t, db.Put([]byte("b"), []byte("B")))
+	require.NoError(t, db.Flush())
+
+	require.NoError(t, db.Del([]byte("a")))
+	require.NoError(t, db.Put([]byte("c"), []byte("C")))
+
+	requireGet(t, db, "a", "", false)
+	requireGet(t, db, "b", "B", true)
+	requireGet(t, db, "c", "C", true)
+	requireGet(t, db, "d", "", false)
+
+	require.ErrorIs(t, db.Merge([]byte("a"), []byte("1")), ErrNoMergeOperator)
+}
+
+func test_db_Reopen(t *testing.T, dir string) {
+	db, err := Open(dir, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Put([]byte("a"), []byte("A")))
+	require.NoError(t, db.Flush())
+	require.NoError(t, db.Put([]byte("a"), []byte("AA")))
+	require.NoError(t, db.Put([]byte("b"), []byte("B")))
+	require.NoError(t, db.Close())
+
+	require.ErrorIs(t, db.Put([]byte("c"), []byte("C")), ErrClosed)
+
+	// the unflushed records are replayed from the WAL.
+	db, err = Open(dir, nil)
+	require.NoError(t, err)
+
+	requireGet(t, db, "a", "AA", true)
+	requireGet(t, db, "b", "B", true)
+
+	// flushing removes the replayed WAL.
+	require.NoError(t, db.Flush())
+	require.NoError(t, db.Close())
+
+	logs, tables, err := listFiles(dir)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(logs))
+	require.Equal(t, 2, len(tables))
+
+	db, err = Open(dir, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	requireGet(t, db, "a", "AA", true)
+	requireGet(t, db, "b", "B", true)
+}
+
+func test_db_TornWAL(t *testing.T, dir string) {
+	db, err := Open(dir, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Put([]byte("a"), []byte("A")))
+	size := db.log.Size()
+	require.NoError(t, db.Put([]byte("b"), []byte("B")))
+	number := db.logNumber
+	require.NoError(t, db.Close())
+
+	// a crash during the append of b cut its record short.
+	require.NoError(t, os.Truncate(db.logPath(number), int64(size)+3))
+
+	db, err = Open(dir, nil)
+	require.NoError(t, err)
+
+	requireGet(t, db, "a", "A", true)
+	requireGet(t, db, "b", "", false)
+
+	fi, err := os.Stat(db.logPath(number))
+	require.NoError(t, err)
+	require.Equal(t, int64(size), fi.Size())
+
+	require.NoError(t, db.Put([]byte("c"), []byte("C")))
+	require.NoError(t, db.Close())
+
+	db, err = Open(dir, nil)
+	require.NoError(t, err)
+
+	requireGet(t, db, "a", "A", true)
+	requireGet(t, db, "c", "C", true)
+	require.NoError(t, db.Close())
+
+	// a WAL older than the newest cannot have been cut short by a crash.
+	require.NoError(t, os.Truncate(db.logPath(number), int64(size)-1))
+
+	_, err = Open(dir, nil)
+	require.ErrorIs(t, err, status.ErrCorruption)
+}
+
+func test_db_Merge(t *testing.T, dir string) {
+	db, err := Open(dir, mergeOptions())
+	require.NoError(t, err)
+
+	// base and operands spread over tables and the memtable.
+	require.NoError(t, db.Put([]byte("a"), []byte("10")))
+	require.NoError(t, db.Flush())
+	require.NoError(t, db.Merge([]byte("a"), []byte("1")))
+	require.NoError(t, db.Merge([]byte("a"), []byte("2")))
+	require.NoError(t, db.Flush())
+	require.NoError(t, db.Merge([]byte("a"), []byte("3")))
+
+	// no base.
+	require.NoError(t, db.Merge([]byte("b"), []byte("5")))
+
+	// base deleted.
+	require.NoError(t, db.Put([]byte("c"), []byte("100")))
+	require.NoError(t, db.Flush())
+	require.NoError(t, db.Del([]byte("c")))
+	require.NoError(t, db.Merge([]byte("c"), []byte("7")))
+
+	requireGet(t, db, "a", "16", true)
+	requireGet(t, db, "b", "5", true)
+	requireGet(t, db, "c", "7", true)
+
+	// operands survive a restart through the WAL.
+	require.NoError(t, db.Close())
+
+	db, err = Open(dir, mergeOptions())
+	require.NoError(t, err)
+	defer db.Close()
+
+	requireGet(t, db, "a", "16", true)
+	requireGet(t, db, "b", "5", true)
+	requireGet(t, db, "c", "7", true)
+}
+
+func test_db_Compact(t *testing.T, dir string) {
+	db, err := Open(dir, mergeOptions())
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.Put([]byte("a"), []byte("10")))
+	require.NoError(t, db.Put([]byte("d"), []byte("1")))
+	require.NoError(t, db.Flush())
+	require.NoError(t, db.Merge([]byte("a"), []byte("1")))
+	require.NoError(t, db.Merge([]byte("b"), []byte("2")))
+	require.NoError(t, db.Del([]byte("d")))
+	require.NoError(t, db.Flush())
+	require.NoError(t, db.Merge([]byte("a"), []byte("5")))
+	require.NoError(t, db.Merge([]byte("b"), []byte("3")))
+
+	require.NoError(t, db.Compact())
+	require.Equal(t, 1, db.NumTables())
+
+	requireGet(t, db, "a", "16", true)
+	requireGet(t, db, "b", "5", true)
+	requireGet(t, db, "d", "", false)
+
+	// merges are collapsed into values and tombstones dropped.
+	h, err := db.def.tcache.Acquire(db.def.tables[0].path)
+	require.NoError(t, err)
+	defer h.Release()
+
+	var keys []string
+	for itr := h.Table().NewIterator(nil); itr.HasNext(); {
+		key, _, ty, err := itr.NextEntry()
+		require.NoError(t, err)
+		require.Equal(t, sstable.NO_TOMBSTONE, ty)
+		keys = append(keys, string(key))
+	}
+	require.Equal(t, []string{"a", "b"}, keys)
+}
+
+func test_db_TTL(t *testing.T, dir string) {
+	c := clock.NewManual(time.Unix(1000, 0))
+
+	opts := DefaultOptions()
+	opts.Clock = c
+
+	db, err := Open(dir, opts)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Put([]byte("a"), []byte("old")))
+	require.NoError(t, db.Flush())
+
+	// an expiring value in a table shadows the older value.
+	require.NoError(t, db.PutWithTTL([]byte("a"), []byte("A"), time.Minute))
+	require.NoError(t, db.Flush())
+	require.NoError(t, db.PutWithTTL([]byte("b"), []byte("B"), time.Hour))
+	require.NoError(t, db.PutWithTTL([]byte("c"), []byte("C"), time.Minute))
+
+	requireGet(t, db, "a", "A", true)
+	requireGet(t, db, "b", "B", true)
+	requireGet(t, db, "c", "C", true)
+
+	c.Advance(time.Minute)
+
+	requireGet(t, db, "a", "", false)
+	requireGet(t, db, "b", "B", true)
+	requireGet(t, db, "c", "", false)
+
+	// expiration times survive a restart.
+	require.NoError(t, db.Close())
+
+	db, err = Open(dir, opts)
+	require.NoError(t, err)
+	defer db.Close()
+
+	requireGet(t, db, "b", "B", true)
+	requireGet(t, db, "c", "", false)
+
+	// compaction removes the expired entries along with what they
+	// shadow, and keeps the expiration time of the others.
+	require.NoError(t, db.Compact())
+
+	h, err := db.def.tcache.Acquire(db.def.tables[0].path)
+	require.NoError(t, err)
+	defer h.Release()
+
+	var keys []string
+	for itr := h.Table().NewIterator(nil); itr.HasNext(); {
+		key, _, ty, err := itr.NextEntry()
+		require.NoError(t, err)
+		require.Equal(t, sstable.EXPIRING, ty)
+		keys = append(keys, string(key))
+	}
+	require.Equal(t, []string{"b"}, keys)
+
+	c.Advance(time.Hour)
+	requireGet(t, db, "b", "", false)
+}
+
+func test_db_DeleteRange(t *testing.T, dir string) {
+	db, err := Open(dir, mergeOptions())
+	require.NoError(t, err)
+
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		require.NoError(t, db.Put([]byte(key), []byte(key)))
+	}
+	require.NoError(t, db.Flush())
+	require.NoError(t, db.Put([]byte("c"), []byte("cc")))
+	require.NoError(t, db.Merge([]byte("d"), []byte("1")))
+
+	require.ErrorIs(t, db.DeleteRange([]byte("d"), []byte("b")), sstable.ErrEmptyRange)
+	require.NoError(t, db.DeleteRange([]byte("b"), []byte("e")))
+
+	// written after the range tombstone.
+	require.NoError(t, db.Merge([]byte("c"), []byte("5")))
+
+	check := func() {
+		requireGet(t, db, "a", "a", true)
+		requireGet(t, db, "b", "", false)
+		requireGet(t, db, "c", "5", true)
+		requireGet(t, db, "d", "", false)
+		requireGet(t, db, "e", "e", true)
+	}
+	check()
+
+	// the range tombstone survives a restart and a flush.
+	require.NoError(t, db.Close())
+
+	db, err = Open(dir, mergeOptions())
+	require.NoError(t, err)
+	defer db.Close()
+
+	check()
+	require.NoError(t, db.Flush())
+	check()
+
+	// compaction drops the range tombstone with the data it covers.
+	require.NoError(t, db.Compact())
+	check()
+
+	h, err := db.def.tcache.Acquire(db.def.tables[0].path)
+	require.NoError(t, err)
+	defer h.Release()
+
+	require.Equal(t, 0, len(h.Table().RangeTombstones()))
+	require.Equal(t, uint64(3), countEntries(t, h.Table()))
+}
+
+func test_db_SingleDelete(t *testing.T, dir string) {
+	opts := DefaultOptions()
+	opts.CompactionTrigger = 2
+
+	db, err := Open(dir, opts)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// the oldest table.
+	require.NoError(t, db.Put([]byte("x"), []byte("X")))
+	require.NoError(t, db.Flush())
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, db.Put([]byte(key), []byte(key)))
+	}
+	require.NoError(t, db.Flush())
+
+	require.NoError(t, db.SingleDelete([]byte("a")))
+	require.NoError(t, db.SingleDelete([]byte("b")))
+	require.NoError(t, db.Del([]byte("c")))
+	require.NoError(t, db.Del([]byte("d")))
+	require.NoError(t, db.SingleDelete([]byte("x")))
+	// cancelled in the memtable.
+	require.NoError(t, db.Put([]byte("e"), []byte("e")))
+	require.NoError(t, db.SingleDelete([]byte("e")))
+
+	check := func() {
+		for _, key := range []string{"a", "b", "c", "d", "e", "x"} {
+			requireGet(t, db, key, "", false)
+		}
+	}
+	check()
+
+	// the flush merges the two newest tables above the oldest one.
+	require.NoError(t, db.Flush())
+	require.Equal(t, 2, db.NumTables())
+	check()
+
+	// single deletes vanish with their values while tombstones of Del
+	// stay, and the single delete of x waits for its value.
+	h, err := db.def.tcache.Acquire(db.def.tables[0].path)
+	require.NoError(t, err)
+
+	types := map[string]sstable.TombstoneType{}
+	for itr := h.Table().NewIterator(nil); itr.HasNext(); {
+		key, _, ty, err := itr.NextEntry()
+		require.NoError(t, err)
+		types[string(key)] = ty
+	}
+	h.Release()
+
+	require.Equal(t, map[string]sstable.TombstoneType{
+		"c": sstable.TOMBSTONE,
+		"d": sstable.TOMBSTONE,
+		"x": sstable.SINGLE_DELETE,
+	}, types)
+
+	require.NoError(t, db.Compact())
+	require.Equal(t, 1, db.NumTables())
+	check()
+
+	h, err = db.def.tcache.Acquire(db.def.tables[0].path)
+	require.NoError(t, err)
+	defer h.Release()
+
+	require.Equal(t, uint64(0), countEntries(t, h.Table()))
+}
+
+func countEntries(t *testing.T, tbl *sstable.Table) uint64 {
+	var n uint64
+	for itr := tbl.NewIterator(nil); itr.HasNext(); {
+		_, _, _, err := itr.NextEntry()
+		require.NoError(t, err)
+		n += 1
+	}
+
+	return n
+}
+
+func test_db_Statistics(t *testing.T, dir string) {
+	opts := DefaultOptions()
+	opts.Table = sstable.DefaultOptions()
+	opts.Table.BlockCache = sstable.NewCache(1 << 20)
+
+	db, err := Open(dir, opts)
+	require.NoError(t, err)
+	defer db.Close()
+
+	users, err := db.CreateColumnFamily("users", &Options{})
+	require.NoError(t, err)
+
+	require.NoError(t, db.Put([]byte("a"), []byte("AA")))
+	require.NoError(t, db.Put([]byte("b"), []byte("BBB")))
+	require.NoError(t, users.Put([]byte("u"), []byte("U")))
+
+	snap := db.GetStatistics()
+	require.Equal(t, uint64(3), snap.Tickers[statistics.KEYS_WRITTEN])
+	require.Equal(t, uint64(9), snap.Tickers[statistics.BYTES_WRITTEN])
+	require.Equal(t, uint64(3), snap.Tickers[statistics.WAL_SYNCS])
+	require.Equal(t, db.log.Size(), snap.Tickers[statistics.WAL_BYTES_WRITTEN])
+	require.Equal(t, uint64(3), snap.Histograms[statistics.WRITE_MICROS].Count)
+	require.Equal(t, uint64(4), snap.Histograms[statistics.BYTES_PER_WRITE].Max)
+
+	// served by the memtable.
+	requireGet(t, db, "a", "AA", true)
+
+	require.NoError(t, db.Flush())
+
+	// served by the table, then by the block cache.
+	requireGet(t, db, "b", "BBB", true)
+	requireGet(t, db, "b", "BBB", true)
+	requireGet(t, db, "c", "", false)
+
+	snap = db.GetStatistics()
+	require.Equal(t, uint64(4), snap.Tickers[statistics.KEYS_READ])
+	require.Equal(t, uint64(3), snap.Tickers[statistics.KEYS_FOUND])
+	require.Equal(t, uint64(8), snap.Tickers[statistics.BYTES_READ])
+	require.Equal(t, uint64(1), snap.Tickers[statistics.MEMTABLE_HITS])
+	require.Equal(t, uint64(3), snap.Tickers[statistics.MEMTABLE_MISSES])
+	require.Equal(t, uint64(3), snap.Tickers[statistics.TABLE_READS])
+	require.Equal(t, uint64(1), snap.Tickers[statistics.BLOCK_READS])
+	require.Equal(t, uint64(1), snap.Tickers[statistics.BLOCK_CACHE_MISSES])
+	// the bloom filter rules out "c".
+	require.Equal(t, uint64(1), snap.Tickers[statistics.BLOCK_CACHE_HITS])
+	require.Equal(t, uint64(4), snap.Histograms[statistics.GET_MICROS].Count)
+
+	// both column families are flushed.
+	require.Equal(t, uint64(2), snap.Tickers[statistics.FLUSHES])
+	require.Equal(t, uint64(2), snap.Histograms[statistics.FLUSH_MICROS].Count)
+	require.Greater(t, snap.Tickers[statistics.FLUSH_BYTES_WRITTEN], uint64(0))
+
+	require.NoError(t, db.Put([]byte("c"), []byte("C")))
+	require.NoError(t, db.Flush())
+	require.NoError(t, db.Compact())
+
+	snap = db.GetStatistics()
+	require.Equal(t, uint64(2), snap.Tickers[statistics.COMPACTIONS])
+	require.Greater(t, snap.Tickers[statistics.COMPACTION_BYTES_READ], snap.Tickers[statistics.COMPACTION_BYTES_WRITTEN])
+	require.Equal(t, uint64(2), snap.Histograms[statistics.COMPACTION_MICROS].Count)
+
+	itr, err := db.NewIterator()
+	require.NoError(t, err)
+	for itr.HasNext() {
+		_, _, err := itr.Next()
+		require.NoError(t, err)
+	}
+	itr.Close()
+	require.Equal(t, uint64(6), db.GetStatistics().Tickers[statistics.ITER_BYTES_READ])
+
+	// statistics can be turned off.
+	opts.Statistics = nil
+	off, err := Open(dir+".off", opts)
+	require.NoError(t, err)
+	defer os.RemoveAll(dir + ".off")
+	defer off.Close()
+
+	require.NoError(t, off.Put([]byte("a"), []byte("A")))
+	require.Equal(t, statistics.Snapshot{}, off.GetStatistics())
+}
+
+func test_db_Tiers(t *testing.T, dir string) {
+	opts := DefaultOptions()
+	opts.CompactionTrigger = 2
+
+	db, err := Open(dir, opts)
+	require.NoError(t, err)
+	defer db.Close()
+
+	tickers := func() [statistics.TICKER_MAX]uint64 {
+		return db.GetStatistics().Tickers
+	}
+
+	// the first table is the bottommost one, the next ones go above it.
+	require.NoError(t, db.Put([]byte("a"), []byte("AA")))
+	require.NoError(t, db.Flush())
+	snap := tickers()
+	require.Greater(t, snap[statistics.BOTTOM_BYTES_WRITTEN], uint64(0))
+	require.Equal(t, snap[statistics.FLUSH_BYTES_WRITTEN], snap[statistics.BOTTOM_BYTES_WRITTEN])
+	require.Equal(t, uint64(0), snap[statistics.TOP_BYTES_WRITTEN])
+
+	require.NoError(t, db.Put([]byte("b"), []byte("BBB")))
+	require.NoError(t, db.Flush())
+	prev, snap := snap, tickers()
+	require.Equal(t, snap[statistics.FLUSH_BYTES_WRITTEN]-prev[statistics.FLUSH_BYTES_WRITTEN], snap[statistics.TOP_BYTES_WRITTEN])
+	require.Equal(t, prev[statistics.BOTTOM_BYTES_WRITTEN], snap[statistics.BOTTOM_BYTES_WRITTEN])
+
+	requireGet(t, db, "a", "AA", true)
+	requireGet(t, db, "b", "BBB", true)
+	snap = tickers()
+	require.Equal(t, uint64(2), snap[statistics.BOTTOM_BYTES_READ])
+	require.Equal(t, uint64(3), snap[statistics.TOP_BYTES_READ])
+
+	// the tables above the bottommost one are merged.
+	require.NoError(t, db.Put([]byte("c"), []byte("C")))
+	require.NoError(t, db.Flush())
+	prev, snap = snap, tickers()
+	require.Equal(t, uint64(1), snap[statistics.COMPACTIONS])
+	require.Equal(t, 2, len(db.def.tables))
+	require.Equal(t, prev[statistics.TOP_BYTES_READ]+snap[statistics.COMPACTION_BYTES_READ], snap[statistics.TOP_BYTES_READ])
+	require.Equal(t, prev[statistics.TOP_BYTES_WRITTEN]+snap[statistics.FLUSH_BYTES_WRITTEN]-prev[statistics.FLUSH_BYTES_WRITTEN]+snap[statistics.COMPACTION_BYTES_WRITTEN],
+		snap[statistics.TOP_BYTES_WRITTEN])
+	require.Equal(t, prev[statistics.BOTTOM_BYTES_READ], snap[statistics.BOTTOM_BYTES_READ])
+	require.Equal(t, prev[statistics.BOTTOM_BYTES_WRITTEN], snap[statistics.BOTTOM_BYTES_WRITTEN])
+
+	// a bottommost compaction reads both tiers and rewrites the bottommost
+	// table.
+	fi, err := os.Stat(db.def.tables[1].path)
+	require.NoError(t, err)
+	bottom := uint64(fi.Size())
+
+	require.NoError(t, db.Compact())
+	prev, snap = snap, tickers()
+	read := snap[statistics.COMPACTION_BYTES_READ] - prev[statistics.COMPACTION_BYTES_READ]
+	written := snap[statistics.COMPACTION_BYTES_WRITTEN] - prev[statistics.COMPACTION_BYTES_WRITTEN]
+	require.Equal(t, prev[statistics.BOTTOM_BYTES_READ]+bottom, snap[statistics.BOTTOM_BYTES_READ])
+	require.Equal(t, prev[statistics.TOP_BYTES_READ]+read-bottom, snap[statistics.TOP_BYTES_READ])
+	require.Equal(t, prev[statistics.BOTTOM_BYTES_WRITTEN]+written, snap[statistics.BOTTOM_BYTES_WRITTEN])
+	require.Equal(t, prev[statistics.TOP_BYTES_WRITTEN], snap[statistics.TOP_BYTES_WRITTEN])
+}
+
+// logRecords decodes the records of a JSON log.
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+
+	var records []map[string]interface{}
+	sc := bufio.NewScanner(buf)
+	for sc.Scan() {
+		var r map[string]interface{}
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &r))
+		records = append(records, r)
+	}
+	require.NoError(t, sc.Err())
+
+	return records
+}
+
+// requireLogRecord checks that a record with the message holds attrs.
+func requireLogRecord(t *testing.T, records []map[string]interface{}, msg string, attrs map[string]interface{}) {
+	t.Helper()
+
+	for _, r := range records {
+		matches := r["msg"] == msg
+		for k, v := range attrs {
+			matches = matches && r[k] == v
+		}
+
+		if matches {
+			return
+		}
+	}
+
+	require.Fail(t, "missing log record", "%q with %v in %v", msg, attrs, records)
+}
+
+func test_db_Logger(t *testing.T, dir string) {
+	var buf bytes.Buffer
+	opts := DefaultOptions()
+	opts.Logger = slog.New(slog.NewJSONHandler(&buf, nil))
+
+	db, err := Open(dir, opts)
+	require.NoError(t, err)
+
+	// column families log to the store's logger.
+	users, err := db.CreateColumnFamily("users", &Options{})
+	require.NoError(t, err)
+
+	require.NoError(t, db.Put([]byte("a"), []byte("A")))
+	require.NoError(t, users.Put([]byte("u"), []byte("U")))
+	require.NoError(t, db.Compact())
+	require.NoError(t, db.Put([]byte("b"), []byte("B")))
+	require.NoError(t, db.Close())
+
+	records := logRecords(t, &buf)
+	requireLogRecord(t, records, "opening store", map[string]interface{}{"level": "INFO", "path": dir})
+	requireLogRecord(t, records, "store opened", map[string]interface{}{"column_families": float64(1), "tables": float64(0)})
+	requireLogRecord(t, records, "column family created", map[string]interface{}{"column_family": "users"})
+	requireLogRecord(t, records, "memtable flushed", map[string]interface{}{
+		"column_family": "default",
+		"reason":        "manual",
+		"path":          db.def.tablePath(2),
+		"entries":       float64(1),
+	})
+	requireLogRecord(t, records, "memtable flushed", map[string]interface{}{"column_family": "users"})
+	requireLogRecord(t, records, "tables compacted", map[string]interface{}{
+		"column_family": "users",
+		"reason":        "manual",
+		"inputs":        float64(1),
+		"bottommost":    true,
+	})
+	requireLogRecord(t, records, "store closed", map[string]interface{}{"path": dir})
+
+	// the unflushed write is replayed.
+	buf.Reset()
+	db, err = Open(dir, opts)
+	require.NoError(t, err)
+	defer db.Close()
+
+	records = logRecords(t, &buf)
+	requireLogRecord(t, records, "replaying wal", map[string]interface{}{"level": "INFO"})
+	requireLogRecord(t, records, "wal replayed", map[string]interface{}{"records": float64(1)})
+	requireLogRecord(t, records, "store opened", map[string]interface{}{
+		"column_families": float64(2),
+		"tables":          float64(2),
+		"replayed_wals":   float64(1),
+	})
+}
+
+func test_db_ReadErrors(t *testing.T, dir string) {
+	db, err := Open(dir, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.Put([]byte("a"), []byte("A")))
+	require.NoError(t, db.Flush())
+
+	_, err = db.Get([]byte("b"))
+	require.ErrorIs(t, err, ErrNotFound)
+
+	// the data block of the open table is gone.
+	require.NoError(t, os.Truncate(db.def.tables[0].path, 0))
+
+	_, err = db.Get([]byte("a"))
+	require.ErrorIs(t, err, ErrCorruption)
+	require.ErrorIs(t, err, sstable.ErrCorruption)
+	require.NotErrorIs(t, err, ErrNotFound)
+	require.Contains(t, err.Error(), db.def.tables[0].path)
+
+	// values in the memtable are still served.
+	require.NoError(t, db.Put([]byte("a"), []byte("A2")))
+	requireGet(t, db, "a", "A2", true)
+}
+
+func test_db_ReadOnly(t *testing.T, dir string) {
+	db, err := Open(dir, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	users, err := db.CreateColumnFamily("users", nil)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Put([]byte("a"), []byte("A")))
+	require.NoError(t, users.Put([]byte("u"), []byte("U")))
+	require.NoError(t, db.Resume())
+
+	// the table of the flush cannot be created.
+	require.NoError(t, os.RemoveAll(users.dir))
+
+	flushErr := db.Flush()
+	require.ErrorIs(t, flushErr, status.ErrIO)
+	require.Equal(t, flushErr, db.BackgroundError())
+
+	// writes are refused with the background error.
+	err = db.Put([]byte("b"), []byte("B"))
+	require.ErrorIs(t, err, ErrReadOnly)
+	require.ErrorIs(t, err, status.ErrReadOnly)
+	require.ErrorIs(t, err, flushErr)
+	require.Equal(t, status.ErrReadOnly, status.Kind(err))
+
+	require.ErrorIs(t, users.Put([]byte("v"), []byte("V")), ErrReadOnly)
+	b := NewWriteBatch()
+	b.Put(users, []byte("v"), []byte("V"))
+	require.ErrorIs(t, db.Write(b), ErrReadOnly)
+	require.ErrorIs(t, db.Flush(), ErrReadOnly)
+	require.ErrorIs(t, db.Compact(), ErrReadOnly)
+	_, err = db.CreateColumnFamily("items", nil)
+	require.ErrorIs(t, err, ErrReadOnly)
+	require.ErrorIs(t, db.DropColumnFamily(users), ErrReadOnly)
+
+	// reads are still served.
+	requireGet(t, db, "a", "A", true)
+	requireGet(t, db, "b", "", false)
+	value, err := users.Get([]byte("u"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("U"), value)
+
+	// resuming fails again until the cause is fixed.
+	require.ErrorIs(t, db.Resume(), status.ErrIO)
+	require.ErrorIs(t, db.Put([]byte("b"), []byte("B")), ErrReadOnly)
+
+	require.NoError(t, os.MkdirAll(users.dir, 0755))
+	require.NoError(t, db.Resume())
+	require.NoError(t, db.BackgroundError())
+	require.Equal(t, 1, users.NumTables())
+
+	require.NoError(t, db.Put([]byte("b"), []byte("B")))
+	require.NoError(t, db.Close())
+
+	db, err = Open(dir, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	requireGet(t, db, "a", "A", true)
+	requireGet(t, db, "b", "B", true)
+	users, ok := db.ColumnFamily("users")
+	require.True(t, ok)
+	value, err = users.Get([]byte("u"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("U"), value)
+}
+
+func test_db_Busy(t *testing.T, dir string) {
+	db, err := Open(dir, nil)
+	require.NoError(t, err)
+
+	_, err = Open(dir, nil)
+	require.ErrorIs(t, err, ErrBusy)
+	require.ErrorIs(t, err, status.ErrBusy)
+
+	require.NoError(t, db.Close())
+	require.ErrorIs(t, db.Close(), status.ErrClosed)
+
+	// a failed Open releases the store.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, MANIFEST_FILE), nil, 0644))
+	_, err = Open(dir, nil)
+	require.ErrorIs(t, err, ErrCorruptManifest)
+	require.ErrorIs(t, err, status.ErrCorruption)
+
+	require.NoError(t, os.Remove(filepath.Join(dir, MANIFEST_FILE)))
+	db, err = Open(dir, nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
+
+// foldComparator orders keys case-insensitively, so that distinct byte
+// strings compare equal.
+type foldComparator struct{}
+
+func (foldComparator) Compare(a, b []byte) int {
+	return bytes.Compare(bytes.ToLower(a), bytes.ToLower(b))
+}
+
+func (foldComparator) Name() string {
+	return "test.FoldComparator"
+}
+
+func (foldComparator) FindShortestSeparator(start, limit []byte) []byte {
+	return append([]byte(nil), start...)
+}
+
+func (foldComparator) FindShortSuccessor(key []byte) []byte {
+	return append([]byte(nil), key...)
+}
+
+// normalizedFoldComparator lets bloom filters hash lower-cased keys.
+type normalizedFoldComparator struct {
+	foldComparator
+}
+
+func (normalizedFoldComparator) NormalizeKey(key []byte) []byte {
+	return bytes.ToLower(key)
+}
+
+func test_db_Comparator(t *testing.T, dir string) {
+	for i, cmp := range []comparator.Comparator{foldComparator{}, normalizedFoldComparator{}} {
+		opts := DefaultOptions()
+		opts.Comparator = cmp
+
+		db, err := Open(filepath.Join(dir, strconv.Itoa(i)), opts)
+		require.NoError(t, err)
+
+		require.NoError(t, db.Put([]byte("Apple"), []byte("A")))
+		requireGet(t, db, "apple", "A", true)
+
+		// the key is still found once flushed.
+		require.NoError(t, db.Flush())
+		requireGet(t, db, "apple", "A", true)
+		requireGet(t, db, "APPLE", "A", true)
+		requireGet(t, db, "banana", "", false)
+
+		require.NoError(t, db.Close())
+	}
+}
@@ -0,0 +1,219 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// EventListener is notified of flushes, compactions and the files they
+// create and delete. Callbacks run synchronously with the lock of the store
+// held: they must return quickly and must not call the store.
+type EventListener interface {
+	OnFlushBegin(info FlushInfo)
+	OnFlushCompleted(info FlushInfo)
+	OnCompactionBegin(info CompactionInfo)
+	OnCompactionCompleted(info CompactionInfo)
+	OnTableFileCreated(info TableFileInfo)
+	OnTableFileDeleted(info TableFileInfo)
+	OnWALCreated(info WALInfo)
+	// a flush or compaction failed; the error is also returned to the
+	// caller whose write or call started it, and the store is read-only
+	// until Resume.
+	OnBackgroundError(info BackgroundErrorInfo)
+	OnStallConditionsChanged(info StallInfo)
+}
+
+// NoopEventListener ignores every event. Embed it to implement only some
+// of the callbacks.
+type NoopEventListener struct{}
+
+func (NoopEventListener) OnFlushBegin(FlushInfo)                {}
+func (NoopEventListener) OnFlushCompleted(FlushInfo)            {}
+func (NoopEventListener) OnCompactionBegin(CompactionInfo)      {}
+func (NoopEventListener) OnCompactionCompleted(CompactionInfo)  {}
+func (NoopEventListener) OnTableFileCreated(TableFileInfo)      {}
+func (NoopEventListener) OnTableFileDeleted(TableFileInfo)      {}
+func (NoopEventListener) OnWALCreated(WALInfo)                  {}
+func (NoopEventListener) OnBackgroundError(BackgroundErrorInfo) {}
+func (NoopEventListener) OnStallConditionsChanged(StallInfo)    {}
+
+// FlushReason tells what started a flush.
+type FlushReason int
+
+const (
+	// Flush or Compact.
+	FLUSH_REASON_MANUAL FlushReason = iota
+	// a write filled a memtable.
+	FLUSH_REASON_MEMTABLE_FULL
+	// IngestExternalFiles, as the memtable overlaps the ingested tables.
+	FLUSH_REASON_INGESTION
+	// CollectValueLogs.
+	FLUSH_REASON_VALUE_LOG_GC
+	// Resume, after a flush or compaction failed.
+	FLUSH_REASON_ERROR_RECOVERY
+)
+
+func (r FlushReason) String() string {
+	switch r {
+	case FLUSH_REASON_MANUAL:
+		return "manual"
+	case FLUSH_REASON_MEMTABLE_FULL:
+		return "memtable full"
+	case FLUSH_REASON_INGESTION:
+		return "ingestion"
+	case FLUSH_REASON_VALUE_LOG_GC:
+		return "value log gc"
+	case FLUSH_REASON_ERROR_RECOVERY:
+		return "error recovery"
+	}
+
+	return fmt.Sprintf("FlushReason(%d)", int(r))
+}
+
+// CompactionReason tells what started a compaction.
+type CompactionReason int
+
+const (
+	// CompactionTrigger tables were flushed over the oldest one.
+	COMPACTION_REASON_TRIGGER CompactionReason = iota
+	// Compact.
+	COMPACTION_REASON_MANUAL
+	// CollectValueLogs.
+	COMPACTION_REASON_VALUE_LOG_GC
+)
+
+func (r CompactionReason) String() string {
+	switch r {
+	case COMPACTION_REASON_TRIGGER:
+		return "trigger"
+	case COMPACTION_REASON_MANUAL:
+		return "manual"
+	case COMPACTION_REASON_VALUE_LOG_GC:
+		return "value log gc"
+	}
+
+	return fmt.Sprintf("CompactionReason(%d)", int(r))
+}
+
+// TableFileReason tells why a table was created or deleted.
+type TableFileReason int
+
+const (
+	TABLE_FILE_REASON_FLUSH TableFileReason = iota
+	TABLE_FILE_REASON_COMPACTION
+	TABLE_FILE_REASON_INGESTION
+	TABLE_FILE_REASON_DROP_COLUMN_FAMILY
+)
+
+func (r TableFileReason) String() string {
+	switch r {
+	case TABLE_FILE_REASON_FLUSH:
+		return "flush"
+	case TABLE_FILE_REASON_COMPACTION:
+		return "compaction"
+	case TABLE_FILE_REASON_INGESTION:
+		return "ingestion"
+	case TABLE_FILE_REASON_DROP_COLUMN_FAMILY:
+		return "drop column family"
+	}
+
+	return fmt.Sprintf("TableFileReason(%d)", int(r))
+}
+
+// BackgroundErrorReason tells what failed.
+type BackgroundErrorReason int
+
+const (
+	BACKGROUND_ERROR_FLUSH BackgroundErrorReason = iota
+	BACKGROUND_ERROR_COMPACTION
+)
+
+func (r BackgroundErrorReason) String() string {
+	switch r {
+	case BACKGROUND_ERROR_FLUSH:
+		return "flush"
+	case BACKGROUND_ERROR_COMPACTION:
+		return "compaction"
+	}
+
+	return fmt.Sprintf("BackgroundErrorReason(%d)", int(r))
+}
+
+// StallCondition tells whether writes are accepted.
+type StallCondition int
+
+const (
+	STALL_CONDITION_NORMAL StallCondition = iota
+	// writes wait for the flush of a full memtable, and for the
+	// compaction it may trigger.
+	STALL_CONDITION_STOPPED
+)
+
+func (c StallCondition) String() string {
+	switch c {
+	case STALL_CONDITION_NORMAL:
+		return "normal"
+	case STALL_CONDITION_STOPPED:
+		return "stopped"
+	}
+
+	return fmt.Sprintf("StallCondition(%d)", int(c))
+}
+
+type FlushInfo struct {
+	ColumnFamily string
+	// table the memtable is written to.
+	Path        string
+	TableNumber uint64
+	Reason      FlushReason
+	// set once completed: bytes written to the table and value log, and
+	// entries in the table.
+	Size       uint64
+	NumEntries uint64
+	Duration   time.Duration
+}
+
+type CompactionInfo struct {
+	ColumnFamily string
+	// tables merged, newest first, and the table they are merged into.
+	Inputs     []string
+	Output     string
+	Bottommost bool
+	Reason     CompactionReason
+	InputBytes uint64
+	// set once completed.
+	OutputBytes uint64
+	NumEntries  uint64
+	Duration    time.Duration
+}
+
+type TableFileInfo struct {
+	ColumnFamily string
+	Path         string
+	Number       uint64
+	Size         uint64
+	Reason       TableFileReason
+}
+
+type WALInfo struct {
+	Path   string
+	Number uint64
+}
+
+type BackgroundErrorInfo struct {
+	ColumnFamily string
+	Reason       BackgroundErrorReason
+	Err          error
+}
+
+type StallInfo struct {
+	Previous StallCondition
+	Current  StallCondition
+}
+
+// notify calls fn with every event listener of the store.
+func (db *DB) notify(fn func(l EventListener)) {
+	for _, l := range db.opts.EventListeners {
+		fn(l)
+	}
+}
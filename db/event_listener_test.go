@@ -0,0 +1,263 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventListener(t *testing.T) {
+	for scenario, fn := range map[string]func(
+		t *testing.T, dir string,
+	){
+		"FlushCompaction": test_events_FlushCompaction,
+		"Files":           test_events_Files,
+		"BackgroundError": test_events_BackgroundError,
+		"Noop":            test_events_Noop,
+	} {
+		fn := fn // https://github.com/golang/go/wiki/CommonMistakes
+		t.Run(scenario, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "test_db_events_")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			fn(t, dir)
+		})
+	}
+}
+
+// recorder records every event as a line, with file paths relative to
+// the store directory.
+type recorder struct {
+	dir         string
+	events      []string
+	flushes     []FlushInfo
+	compactions []CompactionInfo
+	errs        []BackgroundErrorInfo
+}
+
+func (r *recorder) rel(path string) string {
+	rel, err := filepath.Rel(r.dir, path)
+	if err != nil {
+		return path
+	}
+
+	return rel
+}
+
+func (r *recorder) record(format string, args ...interface{}) {
+	r.events = append(r.events, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) OnFlushBegin(info FlushInfo) {
+	r.record("flush begin %s %s (%s)", info.ColumnFamily, r.rel(info.Path), info.Reason)
+}
+
+func (r *recorder) OnFlushCompleted(info FlushInfo) {
+	r.record("flush completed %s %s", info.ColumnFamily, r.rel(info.Path))
+	r.flushes = append(r.flushes, info)
+}
+
+func (r *recorder) OnCompactionBegin(info CompactionInfo) {
+	inputs := make([]string, 0, len(info.Inputs))
+	for _, path := range info.Inputs {
+		inputs = append(inputs, r.rel(path))
+	}
+	r.record("compaction begin %s %v -> %s (%s)", info.ColumnFamily, inputs, r.rel(info.Output), info.Reason)
+}
+
+func (r *recorder) OnCompactionCompleted(info CompactionInfo) {
+	r.record("compaction completed %s %s", info.ColumnFamily, r.rel(info.Output))
+	r.compactions = append(r.compactions, info)
+}
+
+func (r *recorder) OnTableFileCreated(info TableFileInfo) {
+	r.record("table created %s %s (%s)", info.ColumnFamily, r.rel(info.Path), info.Reason)
+}
+
+func (r *recorder) OnTableFileDeleted(info TableFileInfo) {
+	r.record("table deleted %s %s (%s)", info.ColumnFamily, r.rel(info.Path), info.Reason)
+}
+
+func (r *recorder) OnWALCreated(info WALInfo) {
+	r.record("wal created %s", r.rel(info.Path))
+}
+
+func (r *recorder) OnBackgroundError(info BackgroundErrorInfo) {
+	r.record("background error %s (%s)", info.ColumnFamily, info.Reason)
+	r.errs = append(r.errs, info)
+}
+
+func (r *recorder) OnStallConditionsChanged(info StallInfo) {
+	r.record("stall %s -> %s", info.Previous, info.Current)
+}
+
+func test_events_FlushCompaction(t *testing.T, dir string) {
+	r := &recorder{dir: dir}
+
+	opts := DefaultOptions()
+	opts.MemTableSize = 1
+	opts.CompactionTrigger = 2
+	opts.EventListeners = []EventListener{r}
+
+	db, err := Open(dir, opts)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.Put([]byte("a"), []byte("A")))
+	require.NoError(t, db.Put([]byte("b"), []byte("B")))
+	require.NoError(t, db.Put([]byte("c"), []byte("C")))
+
+	require.Equal(t, []string{
+		"wal created 000001.log",
+		"stall normal -> stopped",
+		"flush begin default 000002.sst (memtable full)",
+		"table created default 000002.sst (flush)",
+		"flush completed default 000002.sst",
+		"wal created 000003.log",
+		"stall stopped -> normal",
+		"stall normal -> stopped",
+		"flush begin default 000004.sst (memtable full)",
+		"table created default 000004.sst (flush)",
+		"flush completed default 000004.sst",
+		"wal created 000005.log",
+		"stall stopped -> normal",
+		"stall normal -> stopped",
+		"flush begin default 000006.sst (memtable full)",
+		"table created default 000006.sst (flush)",
+		"flush completed default 000006.sst",
+		"wal created 000007.log",
+		"compaction begin default [000006.sst 000004.sst] -> 000008.sst (trigger)",
+		"table created default 000008.sst (compaction)",
+		"table deleted default 000006.sst (compaction)",
+		"table deleted default 000004.sst (compaction)",
+		"compaction completed default 000008.sst",
+		"stall stopped -> normal",
+	}, r.events)
+
+	for _, info := range r.flushes {
+		require.Equal(t, uint64(1), info.NumEntries)
+		require.Greater(t, info.Size, uint64(0))
+		require.Greater(t, info.Duration, time.Duration(0))
+	}
+
+	require.Equal(t, 1, len(r.compactions))
+	info := r.compactions[0]
+	require.False(t, info.Bottommost)
+	require.Equal(t, uint64(2), info.NumEntries)
+	require.Greater(t, info.InputBytes, info.OutputBytes)
+	require.Greater(t, info.OutputBytes, uint64(0))
+
+	r.events = nil
+	require.NoError(t, db.Compact())
+	require.Equal(t, []string{
+		"compaction begin default [000008.sst 000002.sst] -> 000009.sst (manual)",
+		"table created default 000009.sst (compaction)",
+		"table deleted default 000008.sst (compaction)",
+		"table deleted default 000002.sst (compaction)",
+		"compaction completed default 000009.sst",
+	}, r.events)
+	require.True(t, r.compactions[1].Bottommost)
+}
+
+func test_events_Files(t *testing.T, dir string) {
+	r := &recorder{dir: dir}
+
+	opts := DefaultOptions()
+	opts.EventListeners = []EventListener{r}
+
+	db, err := Open(filepath.Join(dir, "db"), opts)
+	require.NoError(t, err)
+	defer db.Close()
+	r.dir = filepath.Join(dir, "db")
+
+	users, err := db.CreateColumnFamily("users", nil)
+	require.NoError(t, err)
+	require.NoError(t, users.Put([]byte("u"), []byte("U")))
+
+	path := filepath.Join(dir, "ext.sst")
+	writeExternalFile(t, path, nil, "a")
+
+	r.events = nil
+	require.NoError(t, db.IngestExternalFiles([]string{path}))
+	require.NoError(t, db.Flush())
+	require.NoError(t, db.DropColumnFamily(users))
+
+	require.Equal(t, []string{
+		"table created default 000002.sst (ingestion)",
+		"flush begin users 000001.cf/000003.sst (manual)",
+		"table created users 000001.cf/000003.sst (flush)",
+		"flush completed users 000001.cf/000003.sst",
+		"wal created 000004.log",
+		"table deleted users 000001.cf/000003.sst (drop column family)",
+	}, r.events)
+}
+
+func test_events_BackgroundError(t *testing.T, dir string) {
+	r := &recorder{dir: dir}
+
+	opts := DefaultOptions()
+	opts.EventListeners = []EventListener{r}
+
+	db, err := Open(dir, opts)
+	require.NoError(t, err)
+	defer db.Close()
+
+	users, err := db.CreateColumnFamily("users", nil)
+	require.NoError(t, err)
+	require.NoError(t, users.Put([]byte("u"), []byte("U")))
+
+	// the table of the flush cannot be created.
+	require.NoError(t, os.RemoveAll(users.dir))
+
+	err = db.Flush()
+	require.Error(t, err)
+
+	require.Equal(t, 1, len(r.errs))
+	require.Equal(t, "users", r.errs[0].ColumnFamily)
+	require.Equal(t, BACKGROUND_ERROR_FLUSH, r.errs[0].Reason)
+	require.ErrorIs(t, err, r.errs[0].Err)
+	require.Equal(t, "background error users (flush)", r.events[len(r.events)-1])
+
+	require.NoError(t, os.MkdirAll(users.dir, 0755))
+
+	r.events = nil
+	require.NoError(t, db.Resume())
+	require.Equal(t, []string{
+		"flush begin users 000001.cf/000003.sst (error recovery)",
+		"table created users 000001.cf/000003.sst (flush)",
+		"flush completed users 000001.cf/000003.sst",
+		"wal created 000004.log",
+	}, r.events)
+}
+
+// flushCounter only counts completed flushes.
+type flushCounter struct {
+	NoopEventListener
+	n int
+}
+
+func (c *flushCounter) OnFlushCompleted(FlushInfo) {
+	c.n += 1
+}
+
+func test_events_Noop(t *testing.T, dir string) {
+	c := &flushCounter{}
+
+	opts := DefaultOptions()
+	opts.EventListeners = []EventListener{c, NoopEventListener{}}
+
+	db, err := Open(dir, opts)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.Put([]byte("a"), []byte("A")))
+	require.NoError(t, db.Flush())
+	require.NoError(t, db.Compact())
+
+	require.Equal(t, 1, c.n)
+}
@@ -0,0 +1,170 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/sstable"
+	"github.com/sosomasox/LSM-Tree-based-Storage/wal"
+)
+
+var (
+	ErrExportExists = errors.New("db: export directory already exists")
+)
+
+// ExportRange writes the live keys of [start, end) to new tables in dir,
+// which must not exist, splitting them once they reach
+// Options.TargetFileSize. Merge operands are resolved, deleted keys are
+// left out and values in value logs are copied into the tables, so that
+// ImportRange can add them to another store. Expiring values keep their
+// expiration time. Writes are blocked during the export.
+func (cf *ColumnFamily) ExportRange(start, end []byte, dir string) ([]ExternalFileInfo, error) {
+	db := cf.db
+
+	db.rwmu.RLock()
+	defer db.rwmu.RUnlock()
+
+	// the range is checked like the one of DeleteRange.
+	if err := db.check(cf, wal.Recode{Ope: wal.OPE_DEL_RANGE, Key: start, Value: end}); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(dir); err == nil {
+		return nil, ErrExportExists
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// the export only appears once complete.
+	tmp := dir + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return nil, err
+	}
+
+	infos, err := cf.exportTo(start, end, tmp)
+	if err != nil {
+		os.RemoveAll(tmp)
+		return nil, err
+	}
+
+	if err := os.Rename(tmp, dir); err != nil {
+		return nil, err
+	}
+
+	for i := range infos {
+		infos[i].Path = filepath.Join(dir, filepath.Base(infos[i].Path))
+	}
+
+	return infos, nil
+}
+
+func (cf *ColumnFamily) exportTo(start, end []byte, dir string) ([]ExternalFileInfo, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	it, err := cf.newLiveIterator(true, cf.tables, true)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	it.seek(start)
+
+	cmp := cf.opts.Comparator
+
+	var (
+		infos []ExternalFileInfo
+		w     *SSTWriter
+	)
+	for it.HasNext() {
+		e, err := it.Next()
+		if err != nil {
+			if w != nil {
+				w.Abandon()
+			}
+			return nil, err
+		}
+
+		if cmp.Compare(e.Key, end) >= 0 {
+			break
+		}
+
+		if w == nil {
+			path := filepath.Join(dir, fmt.Sprintf("%06d%s", len(infos)+1, TABLE_SUFFIX))
+			if w, err = NewSSTWriter(path, cf.opts); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := cf.export(w, e); err != nil {
+			w.Abandon()
+			return nil, err
+		}
+
+		if w.b.FileSize() >= cf.opts.TargetFileSize {
+			info, err := w.Finish()
+			if err != nil {
+				return nil, err
+			}
+			infos, w = append(infos, info), nil
+		}
+	}
+
+	if w != nil {
+		info, err := w.Finish()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+// export writes a live entry to w, reading values out of the value logs.
+func (cf *ColumnFamily) export(w *SSTWriter, e sstable.Entry) error {
+	if e.Type != sstable.VALUE_POINTER {
+		return w.add(e.Key, e.Value, e.Type)
+	}
+
+	value, err := cf.readValue(e.Value)
+	if err != nil {
+		return err
+	}
+
+	return w.add(e.Key, value, sstable.NO_TOMBSTONE)
+}
+
+// ImportRange ingests the tables written to dir by ExportRange. See
+// IngestExternalFiles.
+func (cf *ColumnFamily) ImportRange(dir string) error {
+	_, tables, err := listFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(tables))
+	for _, number := range tables {
+		paths = append(paths, filepath.Join(dir, fmt.Sprintf("%06d%s", number, TABLE_SUFFIX)))
+	}
+
+	if len(paths) == 0 {
+		return nil
+	}
+
+	return cf.IngestExternalFiles(paths)
+}
+
+// ExportRange exports keys of the default column family. See
+// ColumnFamily.ExportRange.
+func (db *DB) ExportRange(start, end []byte, dir string) ([]ExternalFileInfo, error) {
+	return db.def.ExportRange(start, end, dir)
+}
+
+// ImportRange imports tables into the default column family. See
+// ColumnFamily.ImportRange.
+func (db *DB) ImportRange(dir string) error {
+	return db.def.ImportRange(dir)
+}
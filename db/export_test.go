@@ -0,0 +1,212 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/clock"
+	"github.com/sosomasox/LSM-Tree-based-Storage/merge"
+	"github.com/sosomasox/LSM-Tree-based-Storage/sstable"
+	"github.com/sosomasox/LSM-Tree-based-Storage/statistics"
+)
+
+func TestExport(t *testing.T) {
+	for scenario, fn := range map[string]func(
+		t *testing.T, dir string,
+	){
+		"RoundTrip": test_export_RoundTrip,
+		"Split":     test_export_Split,
+		"Seek":      test_export_Seek,
+		"Errors":    test_export_Errors,
+	} {
+		fn := fn // https://github.com/golang/go/wiki/CommonMistakes
+		t.Run(scenario, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "test_db_export_")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			fn(t, dir)
+		})
+	}
+}
+
+func test_export_RoundTrip(t *testing.T, dir string) {
+	clk := clock.NewManual(time.Unix(0, 0))
+
+	opts := vlogOptions()
+	opts.MergeOperator = merge.Uint64Add{}
+	opts.Clock = clk
+
+	src, err := Open(filepath.Join(dir, "src"), opts)
+	require.NoError(t, err)
+	defer src.Close()
+
+	// in tables.
+	require.NoError(t, src.Put([]byte("a"), []byte("outside")))
+	require.NoError(t, src.Put([]byte("b"), []byte(blob('b'))))
+	require.NoError(t, src.Put([]byte("c"), []byte("C")))
+	require.NoError(t, src.Merge([]byte("n"), []byte("1")))
+	require.NoError(t, src.Flush())
+
+	// in the memtable.
+	require.NoError(t, src.Del([]byte("c")))
+	require.NoError(t, src.Merge([]byte("n"), []byte("2")))
+	require.NoError(t, src.PutWithTTL([]byte("t"), []byte("T"), time.Hour))
+	require.NoError(t, src.PutWithTTL([]byte("u"), []byte("U"), time.Second))
+	require.NoError(t, src.Put([]byte("z"), []byte("outside")))
+
+	clk.Advance(time.Minute)
+
+	export := filepath.Join(dir, "export")
+	infos, err := src.ExportRange([]byte("b"), []byte("z"), export)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(infos))
+	require.Equal(t, filepath.Join(export, "000001.sst"), infos[0].Path)
+	require.Equal(t, []byte("b"), infos[0].SmallestKey)
+	require.Equal(t, []byte("t"), infos[0].LargestKey)
+	require.Equal(t, uint64(3), infos[0].NumEntries)
+
+	// the table holds live values only, with expiration times.
+	tbl, err := sstable.OpenTable(infos[0].Path, nil)
+	require.NoError(t, err)
+	types := map[string]sstable.TombstoneType{}
+	for itr := tbl.NewIterator(nil); itr.HasNext(); {
+		key, _, ty, err := itr.NextEntry()
+		require.NoError(t, err)
+		types[string(key)] = ty
+	}
+	tbl.Close()
+	require.Equal(t, map[string]sstable.TombstoneType{
+		"b": sstable.NO_TOMBSTONE,
+		"n": sstable.NO_TOMBSTONE,
+		"t": sstable.EXPIRING,
+	}, types)
+
+	dstOpts := DefaultOptions()
+	dstOpts.Clock = clk
+
+	dst, err := Open(filepath.Join(dir, "dst"), dstOpts)
+	require.NoError(t, err)
+	defer dst.Close()
+
+	require.NoError(t, dst.Put([]byte("a"), []byte("A")))
+	require.NoError(t, dst.Put([]byte("c"), []byte("stale")))
+	require.NoError(t, dst.ImportRange(export))
+
+	requireGet(t, dst, "a", "A", true)
+	requireGet(t, dst, "b", blob('b'), true)
+	// the tenant's keys replace the stale ones only where present.
+	requireGet(t, dst, "c", "stale", true)
+	requireGet(t, dst, "n", "3", true)
+	requireGet(t, dst, "t", "T", true)
+	requireGet(t, dst, "u", "", false)
+	requireGet(t, dst, "z", "", false)
+
+	clk.Advance(time.Hour)
+	requireGet(t, dst, "t", "", false)
+}
+
+func test_export_Split(t *testing.T, dir string) {
+	opts := DefaultOptions()
+	opts.Table = sstable.DefaultOptions()
+	opts.Table.BlockSize = 64
+	opts.TargetFileSize = 256
+
+	src, err := Open(filepath.Join(dir, "src"), opts)
+	require.NoError(t, err)
+	defer src.Close()
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, src.Put([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("value%03d", i))))
+	}
+	require.NoError(t, src.Flush())
+
+	export := filepath.Join(dir, "export")
+	infos, err := src.ExportRange([]byte("key010"), []byte("key090"), export)
+	require.NoError(t, err)
+	require.Greater(t, len(infos), 1)
+
+	var entries uint64
+	for i, info := range infos {
+		entries += info.NumEntries
+		if i > 0 {
+			require.Less(t, string(infos[i-1].LargestKey), string(info.SmallestKey))
+		}
+	}
+	require.Equal(t, uint64(80), entries)
+
+	dst, err := Open(filepath.Join(dir, "dst"), nil)
+	require.NoError(t, err)
+	defer dst.Close()
+
+	require.NoError(t, dst.ImportRange(export))
+
+	itr, err := dst.NewIterator()
+	require.NoError(t, err)
+	defer itr.Close()
+
+	i := 10
+	for itr.HasNext() {
+		key, value, err := itr.Next()
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("key%03d", i), string(key))
+		require.Equal(t, fmt.Sprintf("value%03d", i), string(value))
+		i += 1
+	}
+	require.Equal(t, 90, i)
+}
+
+func test_export_Seek(t *testing.T, dir string) {
+	opts := DefaultOptions()
+	opts.Table = sstable.DefaultOptions()
+	opts.Table.BlockSize = 64
+
+	src, err := Open(filepath.Join(dir, "src"), opts)
+	require.NoError(t, err)
+	defer src.Close()
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, src.Put([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("value%03d", i))))
+	}
+	require.NoError(t, src.Flush())
+
+	// the export starts at the block holding the start key, instead of
+	// reading every block before it.
+	reads := src.GetStatistics().Tickers[statistics.BLOCK_READS]
+	infos, err := src.ExportRange([]byte("key095"), []byte("key097"), filepath.Join(dir, "export"))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(infos))
+	require.Equal(t, []byte("key095"), infos[0].SmallestKey)
+	require.Equal(t, []byte("key096"), infos[0].LargestKey)
+	require.Less(t, src.GetStatistics().Tickers[statistics.BLOCK_READS]-reads, uint64(10))
+}
+
+func test_export_Errors(t *testing.T, dir string) {
+	db, err := Open(filepath.Join(dir, "db"), nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.Put([]byte("a"), []byte("A")))
+
+	export := filepath.Join(dir, "export")
+	_, err = db.ExportRange([]byte("b"), []byte("a"), export)
+	require.ErrorIs(t, err, sstable.ErrEmptyRange)
+
+	// a range without keys exports no table.
+	infos, err := db.ExportRange([]byte("b"), []byte("c"), export)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(infos))
+	require.NoError(t, db.ImportRange(export))
+
+	_, err = db.ExportRange([]byte("a"), []byte("b"), export)
+	require.ErrorIs(t, err, ErrExportExists)
+
+	require.NoError(t, db.Close())
+	_, err = db.ExportRange([]byte("a"), []byte("b"), filepath.Join(dir, "closed"))
+	require.ErrorIs(t, err, ErrClosed)
+}
@@ -0,0 +1,292 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/comparator"
+	"github.com/sosomasox/LSM-Tree-based-Storage/logging"
+	"github.com/sosomasox/LSM-Tree-based-Storage/sstable"
+	"github.com/sosomasox/LSM-Tree-based-Storage/wal"
+)
+
+var (
+	ErrUnsortedExternalFile     = errors.New("db: external file keys are not in ascending order")
+	ErrEmptyExternalFile        = errors.New("db: external file is empty")
+	ErrInvalidExternalFile      = errors.New("db: external file holds value pointers")
+	ErrOverlappingExternalFiles = errors.New("db: external files overlap")
+)
+
+// keyRange spans the keys of a table and its range tombstones, both bounds
+// included. It is not valid for a table holding neither.
+type keyRange struct {
+	smallest []byte
+	largest  []byte
+	valid    bool
+}
+
+// extend widens r to include [start, end].
+func (r *keyRange) extend(cmp comparator.Comparator, start, end []byte) {
+	if !r.valid || cmp.Compare(start, r.smallest) < 0 {
+		r.smallest = append([]byte(nil), start...)
+	}
+	if !r.valid || cmp.Compare(end, r.largest) > 0 {
+		r.largest = append([]byte(nil), end...)
+	}
+	r.valid = true
+}
+
+func (r keyRange) overlaps(cmp comparator.Comparator, o keyRange) bool {
+	if !r.valid || !o.valid {
+		return false
+	}
+
+	return cmp.Compare(r.smallest, o.largest) <= 0 && cmp.Compare(o.smallest, r.largest) <= 0
+}
+
+// tableRange returns the key range of a table of the column family. The
+// range is read once from the table's properties, or from its entries for
+// tables written before they recorded it.
+func (cf *ColumnFamily) tableRange(tf *tableFile) (keyRange, error) {
+	if tf.keyRange != nil {
+		return *tf.keyRange, nil
+	}
+
+	h, err := cf.tcache.Acquire(tf.path)
+	if err != nil {
+		return keyRange{}, err
+	}
+	defer h.Release()
+
+	cmp := cf.opts.Comparator
+
+	var r keyRange
+	smallest, ok := h.Table().Property(sstable.PROP_SMALLEST_KEY)
+	if ok {
+		largest, _ := h.Table().Property(sstable.PROP_LARGEST_KEY)
+		r.extend(cmp, smallest, largest)
+	} else {
+		itr := h.Table().NewIterator(nil)
+		defer itr.Close()
+
+		for itr.HasNext() {
+			key, _, _, err := itr.NextEntry()
+			if err != nil {
+				return keyRange{}, err
+			}
+			r.extend(cmp, key, key)
+		}
+		if _, _, _, err := itr.NextEntry(); err != io.EOF {
+			return keyRange{}, err
+		}
+	}
+
+	for _, rt := range h.Table().RangeTombstones() {
+		r.extend(cmp, rt.Start, rt.End)
+	}
+	tf.keyRange = &r
+
+	return r, nil
+}
+
+// externalFile is a table to be ingested.
+type externalFile struct {
+	path string
+	keyRange
+}
+
+// readExternalFile checks that the table at path can be ingested into the
+// column family and returns its key range.
+func (cf *ColumnFamily) readExternalFile(path string) (externalFile, error) {
+	tbl, err := sstable.OpenTable(path, cf.opts.Table)
+	if err != nil {
+		return externalFile{}, err
+	}
+	defer tbl.Close()
+
+	cmp := cf.opts.Comparator
+	f := externalFile{path: path}
+
+	itr := tbl.NewIterator(nil)
+	defer itr.Close()
+
+	for itr.HasNext() {
+		key, _, t, err := itr.NextEntry()
+		if err != nil {
+			return externalFile{}, err
+		}
+
+		if f.valid && cmp.Compare(f.largest, key) >= 0 {
+			return externalFile{}, fmt.Errorf("%w: %s", ErrUnsortedExternalFile, path)
+		}
+
+		switch t {
+		case sstable.VALUE_POINTER:
+			// pointers refer to value logs of another store.
+			return externalFile{}, fmt.Errorf("%w: %s", ErrInvalidExternalFile, path)
+		case sstable.MERGE:
+			if cf.opts.MergeOperator == nil {
+				return externalFile{}, ErrNoMergeOperator
+			}
+		}
+
+		f.extend(cmp, key, key)
+	}
+	if _, _, _, err := itr.NextEntry(); err != io.EOF {
+		return externalFile{}, err
+	}
+
+	for _, rt := range tbl.RangeTombstones() {
+		f.extend(cmp, rt.Start, rt.End)
+	}
+
+	if !f.valid {
+		return externalFile{}, fmt.Errorf("%w: %s", ErrEmptyExternalFile, path)
+	}
+
+	return f, nil
+}
+
+// memOverlaps reports whether the memtable holds entries or range
+// tombstones in r.
+func (cf *ColumnFamily) memOverlaps(r keyRange) (bool, error) {
+	cmp := cf.opts.Comparator
+
+	for _, rt := range cf.mem.RangeTombstones() {
+		var mr keyRange
+		mr.extend(cmp, rt.Start, rt.End)
+		if mr.overlaps(cmp, r) {
+			return true, nil
+		}
+	}
+
+	for itr := cf.mem.NewIterator(); itr.HasNext(); {
+		key, _, _, err := itr.NextEntry()
+		if err != nil {
+			return false, err
+		}
+
+		if cmp.Compare(key, r.largest) > 0 {
+			break
+		}
+		if cmp.Compare(key, r.smallest) >= 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// IngestExternalFiles adds the tables at paths, written by SSTWriter with
+// the comparator of the column family, without going through the WAL and
+// memtable. The tables must not overlap each other. Each one takes the
+// next file number of the store, which orders it above every table it
+// overlaps, and is placed right above the newest of them, or below every
+// table when it overlaps none. The memtable is flushed first when it holds
+// keys in their ranges.
+//
+// Reopening orders tables by file number alone, which keeps the precedence
+// of every pair of overlapping tables: the tables above an ingested one
+// with lower numbers overlap none of its keys, and flushes and compactions
+// put their tables, with new numbers, on top.
+//
+// The tables are hard-linked into the store when possible and copied
+// otherwise; the files at paths are left in place.
+func (cf *ColumnFamily) IngestExternalFiles(paths []string) error {
+	db := cf.db
+
+	db.rwmu.Lock()
+	defer db.rwmu.Unlock()
+
+	if err := db.check(cf, wal.Recode{}); err != nil {
+		return err
+	}
+
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+
+	cmp := cf.opts.Comparator
+
+	files := make([]externalFile, 0, len(paths))
+	for _, path := range paths {
+		f, err := cf.readExternalFile(path)
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return cmp.Compare(files[i].smallest, files[j].smallest) < 0
+	})
+	for i := 1; i < len(files); i++ {
+		if files[i-1].overlaps(cmp, files[i].keyRange) {
+			return fmt.Errorf("%w: %s and %s", ErrOverlappingExternalFiles, files[i-1].path, files[i].path)
+		}
+	}
+
+	// the WAL records of the memtable would be replayed over the ingested
+	// tables after a crash.
+	for _, f := range files {
+		overlaps, err := cf.memOverlaps(f.keyRange)
+		if err != nil {
+			return err
+		}
+
+		if overlaps {
+			if err := db.flush(FLUSH_REASON_INGESTION); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	for _, f := range files {
+		pos := len(cf.tables)
+		for i, tf := range cf.tables {
+			r, err := cf.tableRange(tf)
+			if err != nil {
+				return err
+			}
+
+			if r.overlaps(cmp, f.keyRange) {
+				pos = i
+				break
+			}
+		}
+
+		number := db.nextNumber
+		db.nextNumber += 1
+
+		r := f.keyRange
+		t := &tableFile{number: number, path: cf.tablePath(number), keyRange: &r}
+		if err := linkOrCopy(f.path, t.path); err != nil {
+			return err
+		}
+		if err := syncDir(cf.dir); err != nil {
+			os.Remove(t.path)
+			return err
+		}
+
+		cf.tables = append(cf.tables[:pos], append([]*tableFile{t}, cf.tables[pos:]...)...)
+		cf.opts.Logger.Info("table ingested", logging.KEY_COLUMN_FAMILY, cf.name, logging.KEY_PATH, t.path, "source", f.path, "position", pos)
+
+		created := TableFileInfo{ColumnFamily: cf.name, Path: t.path, Number: number, Reason: TABLE_FILE_REASON_INGESTION}
+		if fi, err := os.Stat(t.path); err == nil {
+			created.Size = uint64(fi.Size())
+		}
+		db.notify(func(l EventListener) { l.OnTableFileCreated(created) })
+	}
+
+	return cf.maybeCompact()
+}
+
+// IngestExternalFiles adds tables to the default column family. See
+// ColumnFamily.IngestExternalFiles.
+func (db *DB) IngestExternalFiles(paths []string) error {
+	return db.def.IngestExternalFiles(paths)
+}
@@ -0,0 +1,308 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/comparator"
+	"github.com/sosomasox/LSM-Tree-based-Storage/sstable"
+)
+
+func TestIngest(t *testing.T) {
+	for scenario, fn := range map[string]func(
+		t *testing.T, dir string,
+	){
+		"Writer":     test_ingest_Writer,
+		"Placement":  test_ingest_Placement,
+		"Reopen":     test_ingest_Reopen,
+		"MemTable":   test_ingest_MemTable,
+		"Validation": test_ingest_Validation,
+	} {
+		fn := fn // https://github.com/golang/go/wiki/CommonMistakes
+		t.Run(scenario, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "test_db_ingest_")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			fn(t, dir)
+		})
+	}
+}
+
+// writeExternalFile writes each key with the value key+"!".
+func writeExternalFile(t *testing.T, path string, opts *Options, keys ...string) {
+	t.Helper()
+
+	w, err := NewSSTWriter(path, opts)
+	require.NoError(t, err)
+
+	for _, key := range keys {
+		require.NoError(t, w.Put([]byte(key), []byte(key+"!")))
+	}
+
+	_, err = w.Finish()
+	require.NoError(t, err)
+}
+
+func test_ingest_Writer(t *testing.T, dir string) {
+	path := filepath.Join(dir, "ext.sst")
+
+	w, err := NewSSTWriter(path, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, w.Put([]byte("b"), []byte("B")))
+	require.NoError(t, w.Del([]byte("c")))
+	require.ErrorIs(t, w.Put([]byte("a"), []byte("A")), sstable.ErrOutOfOrder)
+	require.NoError(t, w.DeleteRange([]byte("a"), []byte("b")))
+
+	info, err := w.Finish()
+	require.NoError(t, err)
+	require.Equal(t, ExternalFileInfo{
+		Path:        path,
+		SmallestKey: []byte("b"),
+		LargestKey:  []byte("c"),
+		NumEntries:  2,
+	}, info)
+
+	// abandoned tables never appear.
+	w, err = NewSSTWriter(filepath.Join(dir, "abandoned.sst"), nil)
+	require.NoError(t, err)
+	require.NoError(t, w.Put([]byte("a"), []byte("A")))
+	require.NoError(t, w.Abandon())
+
+	_, err = os.Stat(filepath.Join(dir, "abandoned.sst"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func test_ingest_Placement(t *testing.T, dir string) {
+	db, err := Open(filepath.Join(dir, "db"), nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.Put([]byte("m"), []byte("old")))
+	require.NoError(t, db.Flush())
+	require.NoError(t, db.Put([]byte("x"), []byte("X")))
+	require.NoError(t, db.Flush())
+
+	walSize := db.log.Size()
+
+	// overlaps no table.
+	below := filepath.Join(dir, "below.sst")
+	writeExternalFile(t, below, nil, "a", "b")
+	// overlaps the oldest table only.
+	above := filepath.Join(dir, "above.sst")
+	writeExternalFile(t, above, nil, "l", "m")
+
+	require.NoError(t, db.IngestExternalFiles([]string{below, above}))
+
+	require.Equal(t, walSize, db.log.Size())
+	require.Equal(t, 4, db.NumTables())
+
+	// tables, newest first.
+	keys := make([]string, 0, 4)
+	for _, tf := range db.def.tables {
+		r, err := db.def.tableRange(tf)
+		require.NoError(t, err)
+		keys = append(keys, fmt.Sprintf("%s-%s", r.smallest, r.largest))
+	}
+	require.Equal(t, []string{"x-x", "l-m", "m-m", "a-b"}, keys)
+
+	// the external files are left in place.
+	_, err = os.Stat(below)
+	require.NoError(t, err)
+
+	check := func(db *DB) {
+		requireGet(t, db, "a", "a!", true)
+		requireGet(t, db, "b", "b!", true)
+		requireGet(t, db, "l", "l!", true)
+		requireGet(t, db, "m", "m!", true)
+		requireGet(t, db, "x", "X", true)
+	}
+	check(db)
+
+	require.NoError(t, db.Close())
+
+	db, err = Open(filepath.Join(dir, "db"), nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	check(db)
+
+	require.NoError(t, db.Compact())
+	check(db)
+}
+
+// requireTableOrder checks that overlapping tables are ordered by file
+// number, the order they are loaded in when the store is reopened.
+func requireTableOrder(t *testing.T, cf *ColumnFamily) {
+	t.Helper()
+
+	cmp := cf.opts.Comparator
+	for i, newer := range cf.tables {
+		for _, older := range cf.tables[i+1:] {
+			nr, err := cf.tableRange(newer)
+			require.NoError(t, err)
+			or, err := cf.tableRange(older)
+			require.NoError(t, err)
+
+			if nr.overlaps(cmp, or) {
+				require.Greater(t, newer.number, older.number)
+			}
+		}
+	}
+}
+
+func test_ingest_Reopen(t *testing.T, dir string) {
+	db, err := Open(filepath.Join(dir, "db"), nil)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Put([]byte("a"), []byte("old")))
+	require.NoError(t, db.Flush())
+	require.NoError(t, db.Put([]byte("z"), []byte("Z")))
+	require.NoError(t, db.Flush())
+
+	// placed between the two tables, under the newer it does not overlap.
+	ext := filepath.Join(dir, "ext.sst")
+	writeExternalFile(t, ext, nil, "a", "b")
+	require.NoError(t, db.IngestExternalFiles([]string{ext}))
+	requireGet(t, db, "a", "a!", true)
+
+	// newer writes, in a table and in the memtable, shadow the ingested
+	// keys.
+	require.NoError(t, db.Put([]byte("b"), []byte("newer")))
+	require.NoError(t, db.Flush())
+	require.NoError(t, db.Put([]byte("a"), []byte("newest")))
+
+	check := func(db *DB) {
+		t.Helper()
+
+		requireTableOrder(t, db.def)
+		requireGet(t, db, "a", "newest", true)
+		requireGet(t, db, "b", "newer", true)
+		requireGet(t, db, "z", "Z", true)
+	}
+	check(db)
+	require.NoError(t, db.Close())
+
+	db, err = Open(filepath.Join(dir, "db"), nil)
+	require.NoError(t, err)
+	check(db)
+
+	require.NoError(t, db.Compact())
+	check(db)
+	require.NoError(t, db.Close())
+
+	db, err = Open(filepath.Join(dir, "db"), nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	check(db)
+}
+
+func test_ingest_MemTable(t *testing.T, dir string) {
+	db, err := Open(filepath.Join(dir, "db"), nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.Put([]byte("a"), []byte("A")))
+	require.NoError(t, db.Put([]byte("z"), []byte("Z")))
+
+	// the memtable holds no key in the range; it is not flushed.
+	path := filepath.Join(dir, "1.sst")
+	writeExternalFile(t, path, nil, "m")
+	require.NoError(t, db.IngestExternalFiles([]string{path}))
+	require.Equal(t, 1, db.NumTables())
+
+	// the memtable holds keys in the range; it is flushed first.
+	path = filepath.Join(dir, "2.sst")
+	writeExternalFile(t, path, nil, "b", "z")
+	require.NoError(t, db.IngestExternalFiles([]string{path}))
+	require.Equal(t, 3, db.NumTables())
+
+	check := func(db *DB) {
+		requireGet(t, db, "a", "A", true)
+		requireGet(t, db, "b", "b!", true)
+		requireGet(t, db, "m", "m!", true)
+		requireGet(t, db, "z", "z!", true)
+	}
+	check(db)
+
+	// written after the ingestion, the WAL records shadow the table.
+	require.NoError(t, db.Put([]byte("m"), []byte("M")))
+	require.NoError(t, db.DeleteRange([]byte("b"), []byte("c")))
+
+	require.NoError(t, db.Close())
+
+	db, err = Open(filepath.Join(dir, "db"), nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	requireGet(t, db, "a", "A", true)
+	requireGet(t, db, "b", "", false)
+	requireGet(t, db, "m", "M", true)
+	requireGet(t, db, "z", "z!", true)
+}
+
+// unsortedComparator orders keys in reverse under the name of the
+// bytewise comparator, to build tables whose keys are out of order.
+type unsortedComparator struct {
+	comparator.Comparator
+}
+
+func (unsortedComparator) Name() string {
+	return comparator.Bytewise.Name()
+}
+
+func test_ingest_Validation(t *testing.T, dir string) {
+	db, err := Open(filepath.Join(dir, "db"), nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	empty := filepath.Join(dir, "empty.sst")
+	writeExternalFile(t, empty, nil)
+	require.ErrorIs(t, db.IngestExternalFiles([]string{empty}), ErrEmptyExternalFile)
+
+	reverse := DefaultOptions()
+	reverse.Comparator = comparator.ReverseBytewise
+	mismatch := filepath.Join(dir, "mismatch.sst")
+	writeExternalFile(t, mismatch, reverse, "b", "a")
+	require.ErrorIs(t, db.IngestExternalFiles([]string{mismatch}), sstable.ErrComparatorMismatch)
+
+	unsorted := DefaultOptions()
+	unsorted.Comparator = unsortedComparator{comparator.ReverseBytewise}
+	path := filepath.Join(dir, "unsorted.sst")
+	writeExternalFile(t, path, unsorted, "b", "a")
+	require.ErrorIs(t, db.IngestExternalFiles([]string{path}), ErrUnsortedExternalFile)
+
+	merges := filepath.Join(dir, "merge.sst")
+	w, err := NewSSTWriter(merges, nil)
+	require.NoError(t, err)
+	require.NoError(t, w.Merge([]byte("a"), []byte("1")))
+	_, err = w.Finish()
+	require.NoError(t, err)
+	require.ErrorIs(t, db.IngestExternalFiles([]string{merges}), ErrNoMergeOperator)
+
+	// range tombstones count in the range of a table.
+	first := filepath.Join(dir, "first.sst")
+	w, err = NewSSTWriter(first, nil)
+	require.NoError(t, err)
+	require.NoError(t, w.Put([]byte("a"), []byte("A")))
+	require.NoError(t, w.DeleteRange([]byte("b"), []byte("d")))
+	_, err = w.Finish()
+	require.NoError(t, err)
+
+	second := filepath.Join(dir, "second.sst")
+	writeExternalFile(t, second, nil, "c")
+	require.ErrorIs(t, db.IngestExternalFiles([]string{second, first}), ErrOverlappingExternalFiles)
+
+	// nothing was ingested.
+	require.Equal(t, 0, db.NumTables())
+	requireGet(t, db, "a", "", false)
+
+	require.NoError(t, db.Close())
+	require.ErrorIs(t, db.IngestExternalFiles([]string{second}), ErrClosed)
+}
@@ -0,0 +1,358 @@
+package db
+
+import (
+	"io"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/merge"
+	"github.com/sosomasox/LSM-Tree-based-Storage/sstable"
+	"github.com/sosomasox/LSM-Tree-based-Storage/statistics"
+	"github.com/sosomasox/LSM-Tree-based-Storage/wal"
+)
+
+// liveIterator merges the memtable and tables into the stream of live
+// entries: one per key, with merge operands resolved and tombstones,
+// covered and expired entries skipped. Unexpired EXPIRING entries are
+// yielded as is so that compaction can keep their expiration time.
+//
+// Unless bottommost, older tables may still hold the keys, so the entries
+// shadowing them are kept: tombstones, expired entries as tombstones,
+// unresolved merge operands and single deletes with nothing to cancel.
+type liveIterator struct {
+	cf         *ColumnFamily
+	itr        *sstable.MergingIterator
+	bottommost bool
+	// range tombstones of every source, newest source first.
+	rangeDels [][]sstable.RangeTombstone
+	// value logs held until Close, so that a collection does not remove
+	// the values the tables point to.
+	vlogs   map[uint64]*valueLog
+	closers []func()
+
+	// first entry of the next key, read while looking for the end of
+	// the current one.
+	pending *sstable.Entry
+	next    *sstable.Entry
+	err     error
+}
+
+// newLiveIterator must be called with the lock held. The memtable is
+// skipped when withMem is false.
+func (cf *ColumnFamily) newLiveIterator(withMem bool, tables []*tableFile, bottommost bool) (*liveIterator, error) {
+	it := &liveIterator{cf: cf, bottommost: bottommost, vlogs: cf.refValueLogs()}
+	it.closers = append(it.closers, func() { cf.unrefValueLogs(it.vlogs) })
+
+	var sources []sstable.EntryIterator
+	if withMem {
+		sources = append(sources, cf.mem.NewIterator())
+		it.rangeDels = append(it.rangeDels, cf.mem.RangeTombstones())
+	}
+
+	for _, tf := range tables {
+		itr, err := cf.tcache.NewIterator(tf.path, nil)
+		if err != nil {
+			it.Close()
+			return nil, err
+		}
+		it.closers = append(it.closers, itr.Close)
+
+		h, err := cf.tcache.Acquire(tf.path)
+		if err != nil {
+			it.Close()
+			return nil, err
+		}
+		it.rangeDels = append(it.rangeDels, h.Table().RangeTombstones())
+		h.Release()
+
+		sources = append(sources, itr)
+	}
+
+	it.itr = sstable.NewMergingIterator(cf.opts.Comparator, sources...)
+	it.advance()
+
+	return it, nil
+}
+
+// covered reports whether a source newer than e's holds a range tombstone
+// covering it.
+func (it *liveIterator) covered(e sstable.Entry) bool {
+	for _, rangeDels := range it.rangeDels[:e.Source] {
+		if sstable.Covers(it.cf.opts.Comparator, rangeDels, e.Key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (it *liveIterator) read() *sstable.Entry {
+	if it.pending != nil {
+		e := it.pending
+		it.pending = nil
+		return e
+	}
+
+	if !it.itr.HasNext() {
+		return nil
+	}
+
+	e, err := it.itr.Next()
+	if err != nil {
+		it.err = err
+		return nil
+	}
+
+	return &e
+}
+
+// advance finds the next live entry.
+func (it *liveIterator) advance() {
+	it.next = nil
+	cmp := it.cf.opts.Comparator
+
+	for it.err == nil {
+		e := it.read()
+		if e == nil {
+			return
+		}
+		key := append([]byte(nil), e.Key...)
+
+		var (
+			out      *sstable.Entry
+			operands [][]byte
+			// an entry older than a value or tombstone is shadowed.
+			done bool
+		)
+
+		for e != nil {
+			if !done && out != nil && out.Type == sstable.SINGLE_DELETE {
+				// the single delete cancels the value it deletes.
+				out, done = nil, true
+			} else if !done {
+				var err error
+				if out, done, err = it.apply(key, *e, &operands); err != nil {
+					it.err = err
+					return
+				}
+			}
+
+			e = it.read()
+			if e != nil && cmp.Compare(e.Key, key) != 0 {
+				it.pending = e
+				break
+			}
+		}
+
+		if it.err != nil {
+			return
+		}
+
+		if !done && len(operands) > 0 && !it.bottommost {
+			operands = merge.Collapse(it.cf.opts.MergeOperator, key, operands)
+			out = &sstable.Entry{Key: key, Value: merge.EncodeOperands(operands), Type: sstable.MERGE}
+		} else if !done && len(operands) > 0 {
+			value, _, err := it.cf.resolve(key, nil, sstable.TOMBSTONE, operands)
+			if err != nil {
+				it.err = err
+				return
+			}
+			out = &sstable.Entry{Key: key, Value: value, Type: sstable.NO_TOMBSTONE}
+		}
+
+		if out != nil {
+			it.next = out
+			return
+		}
+	}
+}
+
+// apply folds e, the next older entry of key, into operands and reports
+// whether it settles the key, along with the live entry if there is one.
+func (it *liveIterator) apply(key []byte, e sstable.Entry, operands *[][]byte) (out *sstable.Entry, done bool, err error) {
+	value, t := e.Value, e.Type
+	if it.covered(e) {
+		value, t = nil, sstable.TOMBSTONE
+	}
+
+	switch {
+	case t == sstable.MERGE:
+		ops, err := merge.DecodeOperands(value)
+		if err != nil {
+			return nil, false, err
+		}
+		*operands = append(ops, *operands...)
+		return nil, false, nil
+
+	case t == sstable.SINGLE_DELETE && len(*operands) == 0 && !it.bottommost:
+		return &sstable.Entry{Key: key, Type: sstable.SINGLE_DELETE}, false, nil
+
+	// values stay in their value log unless merged.
+	case t == sstable.VALUE_POINTER && len(*operands) == 0:
+		return &sstable.Entry{Key: key, Value: value, Type: sstable.VALUE_POINTER}, true, nil
+
+	case t == sstable.VALUE_POINTER:
+		if value, err = it.readValue(value); err != nil {
+			return nil, false, err
+		}
+		t = sstable.NO_TOMBSTONE
+
+	// unexpired entries keep their expiration time.
+	case t == sstable.EXPIRING && len(*operands) == 0:
+		_, t, err := it.cf.unexpire(value, t)
+		if err != nil {
+			return nil, false, err
+		}
+		if t == sstable.TOMBSTONE {
+			return it.tombstone(key), true, nil
+		}
+
+		return &sstable.Entry{Key: key, Value: value, Type: sstable.EXPIRING}, true, nil
+	}
+
+	value, t, err = it.cf.unexpire(value, t)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// covered entries are shadowed by the range tombstones kept with them.
+	if t == sstable.TOMBSTONE && len(*operands) == 0 && !it.covered(e) {
+		return it.tombstone(key), true, nil
+	}
+
+	value, found, err := it.cf.resolve(key, value, t, *operands)
+	if err != nil || !found {
+		return nil, true, err
+	}
+
+	return &sstable.Entry{Key: key, Value: value, Type: sstable.NO_TOMBSTONE}, true, nil
+}
+
+// tombstone returns the entry deleting key, or nil when nothing older can
+// hold it.
+func (it *liveIterator) tombstone(key []byte) *sstable.Entry {
+	if it.bottommost {
+		return nil
+	}
+
+	return &sstable.Entry{Key: key, Type: sstable.TOMBSTONE}
+}
+
+func (it *liveIterator) HasNext() bool {
+	return it.next != nil || it.err != nil
+}
+
+func (it *liveIterator) Next() (sstable.Entry, error) {
+	if it.err != nil {
+		return sstable.Entry{}, it.err
+	}
+
+	if it.next == nil {
+		return sstable.Entry{}, io.EOF
+	}
+
+	e := *it.next
+	it.advance()
+
+	return e, nil
+}
+
+// seek moves the iterator forward to the first live entry whose key is
+// >= key, skipping the table blocks before it.
+func (it *liveIterator) seek(key []byte) {
+	if it.err != nil {
+		return
+	}
+
+	cmp := it.cf.opts.Comparator
+	if it.next != nil && cmp.Compare(it.next.Key, key) >= 0 {
+		return
+	}
+
+	// the first entry of the next key was already read from itr.
+	if it.pending != nil && cmp.Compare(it.pending.Key, key) < 0 {
+		it.pending = nil
+	}
+	it.itr.Seek(key)
+	it.advance()
+}
+
+func (it *liveIterator) Close() {
+	for _, close := range it.closers {
+		close()
+	}
+	it.closers = nil
+}
+
+// Iterator walks the live keys of the store in key order. It sees the
+// tables as of its creation; writes to the memtable made afterwards may or
+// may not be seen.
+type Iterator struct {
+	live *liveIterator
+}
+
+// NewIterator returns an iterator over the live keys of the default
+// column family.
+func (db *DB) NewIterator() (*Iterator, error) {
+	return db.def.NewIterator()
+}
+
+// NewIterator returns an iterator over the live keys of the column family.
+// It keeps the tables it reads open until Close.
+func (cf *ColumnFamily) NewIterator() (*Iterator, error) {
+	cf.db.rwmu.RLock()
+	defer cf.db.rwmu.RUnlock()
+
+	if err := cf.db.check(cf, wal.Recode{}); err != nil {
+		return nil, err
+	}
+
+	live, err := cf.newLiveIterator(true, cf.tables, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Iterator{live: live}, nil
+}
+
+func (itr *Iterator) HasNext() bool {
+	return itr.live.HasNext()
+}
+
+// Next returns the next live key and its value. The value is only valid
+// until Close.
+func (itr *Iterator) Next() (key, value []byte, err error) {
+	key, value, err = itr.next()
+	if err == nil {
+		itr.live.cf.opts.Statistics.Add(statistics.ITER_BYTES_READ, uint64(len(value)))
+	}
+
+	return key, value, err
+}
+
+func (itr *Iterator) next() (key, value []byte, err error) {
+	e, err := itr.live.Next()
+	if err != nil {
+		return []byte(""), []byte(""), err
+	}
+
+	if e.Type == sstable.VALUE_POINTER {
+		value, err := itr.live.readValue(e.Value)
+		if err != nil {
+			return []byte(""), []byte(""), err
+		}
+		return e.Key, value, nil
+	}
+
+	if e.Type == sstable.EXPIRING {
+		value, _, err := sstable.DecodeExpiring(e.Value)
+		if err != nil {
+			return []byte(""), []byte(""), err
+		}
+		return e.Key, value, nil
+	}
+
+	return e.Key, e.Value, nil
+}
+
+func (itr *Iterator) Close() {
+	itr.live.Close()
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/clock"
+	"github.com/sosomasox/LSM-Tree-based-Storage/merge"
+)
+
+func TestIterator(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test_db_iterator_")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := clock.NewManual(time.Unix(1000, 0))
+
+	opts := DefaultOptions()
+	opts.MergeOperator = merge.Uint64Add{}
+	opts.Clock = c
+
+	db, err := Open(dir, opts)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.Put([]byte("a"), []byte("1")))
+	require.NoError(t, db.Put([]byte("b"), []byte("2")))
+	require.NoError(t, db.Put([]byte("c"), []byte("3")))
+	require.NoError(t, db.PutWithTTL([]byte("d"), []byte("4"), time.Minute))
+	require.NoError(t, db.Put([]byte("e"), []byte("5")))
+	require.NoError(t, db.Flush())
+
+	require.NoError(t, db.Merge([]byte("a"), []byte("10")))
+	require.NoError(t, db.Del([]byte("b")))
+	require.NoError(t, db.DeleteRange([]byte("c"), []byte("d")))
+	require.NoError(t, db.Merge([]byte("f"), []byte("6")))
+	require.NoError(t, db.PutWithTTL([]byte("g"), []byte("7"), time.Hour))
+
+	c.Advance(time.Minute)
+
+	itr, err := db.NewIterator()
+	require.NoError(t, err)
+	defer itr.Close()
+
+	for _, expected := range []struct {
+		key, value string
+	}{
+		{"a", "11"},
+		{"e", "5"},
+		{"f", "6"},
+		{"g", "7"},
+	} {
+		require.Equal(t, true, itr.HasNext())
+
+		key, value, err := itr.Next()
+		require.NoError(t, err)
+		require.Equal(t, []byte(expected.key), key)
+		require.Equal(t, []byte(expected.value), value)
+	}
+	require.Equal(t, false, itr.HasNext())
+
+	_, _, err = itr.Next()
+	require.ErrorIs(t, err, io.EOF)
+}
@@ -0,0 +1,24 @@
+//go:build !unix
+
+package db
+
+import (
+	"os"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/status"
+)
+
+// lockFile creates the file at path. Stores are not locked on this
+// platform.
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, status.IO(err)
+	}
+
+	return f, nil
+}
+
+func unlockFile(f *os.File) error {
+	return f.Close()
+}
@@ -0,0 +1,36 @@
+//go:build unix
+
+package db
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/status"
+)
+
+// lockFile takes an exclusive lock on the file at path, creating it if
+// needed. The lock is held by the open file, so that a second Open of the
+// store fails even within the same process.
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, status.IO(err)
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, fmt.Errorf("%w: %s is held by another handle", ErrBusy, path)
+		}
+		return nil, status.IO(err)
+	}
+
+	return f, nil
+}
+
+// unlockFile releases the lock by closing its file.
+func unlockFile(f *os.File) error {
+	return f.Close()
+}
@@ -0,0 +1,134 @@
+package db
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/status"
+)
+
+const (
+	MANIFEST_FILE string = "MANIFEST"
+	// name of the column family every store has, holding the keys written
+	// without a column family.
+	DEFAULT_COLUMN_FAMILY string = "default"
+)
+
+var (
+	ErrCorruptManifest = fmt.Errorf("db: manifest %w", status.ErrCorruption)
+)
+
+// manifest records the column families of the store. It is rewritten as a
+// whole, through a temporary file renamed over the previous one, whenever
+// a column family is created or dropped.
+//
+// The first line holds the id given to the next column family, and each
+// following line the id and name of a column family.
+type manifest struct {
+	nextID   uint32
+	families []manifestFamily
+}
+
+type manifestFamily struct {
+	id   uint32
+	name string
+}
+
+// readManifest reads the manifest of dir. A store without one only has the
+// default column family.
+func readManifest(dir string) (*manifest, error) {
+	f, err := os.Open(filepath.Join(dir, MANIFEST_FILE))
+	if os.IsNotExist(err) {
+		return &manifest{
+			nextID:   1,
+			families: []manifestFamily{{id: 0, name: DEFAULT_COLUMN_FAMILY}},
+		}, nil
+	} else if err != nil {
+		return nil, status.IO(err)
+	}
+	defer f.Close()
+
+	m := &manifest{}
+	sc := bufio.NewScanner(f)
+
+	if !sc.Scan() {
+		return nil, ErrCorruptManifest
+	}
+	nextID, err := strconv.ParseUint(strings.TrimPrefix(sc.Text(), "next_id "), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptManifest, err)
+	}
+	m.nextID = uint32(nextID)
+
+	for sc.Scan() {
+		id, name, ok := strings.Cut(sc.Text(), " ")
+		if !ok {
+			return nil, ErrCorruptManifest
+		}
+
+		n, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorruptManifest, err)
+		}
+		m.families = append(m.families, manifestFamily{id: uint32(n), name: name})
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// write replaces the manifest of dir with m.
+func (m *manifest) write(dir string) error {
+	path := filepath.Join(dir, MANIFEST_FILE)
+	tmp := path + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(f)
+	fmt.Fprintf(bw, "next_id %d\n", m.nextID)
+	for _, family := range m.families {
+		fmt.Fprintf(bw, "%d %s\n", family.id, family.name)
+	}
+
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// ListColumnFamilies returns the names of the column families of the store
+// in dir, in creation order, without opening it.
+func ListColumnFamilies(dir string) ([]string, error) {
+	m, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, family := range m.families {
+		names = append(names, family.name)
+	}
+
+	return names, nil
+}
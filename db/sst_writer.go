@@ -0,0 +1,109 @@
+package db
+
+import (
+	"time"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/merge"
+	"github.com/sosomasox/LSM-Tree-based-Storage/sstable"
+)
+
+// SSTWriter builds a table outside of any store, to be added to one by
+// IngestExternalFiles without going through its WAL and memtable. Keys
+// must be written in strictly ascending order of the comparator of the
+// options, which must be the one of the column family the table is
+// ingested into.
+type SSTWriter struct {
+	opts *Options
+	path string
+	b    *sstable.Builder
+}
+
+// ExternalFileInfo describes a table written by an SSTWriter.
+type ExternalFileInfo struct {
+	Path string
+	// first and last keys written, nil when the table only holds range
+	// tombstones.
+	SmallestKey []byte
+	LargestKey  []byte
+	NumEntries  uint64
+}
+
+// NewSSTWriter creates a writer of the table at path. The file only
+// appears once Finish returns.
+func NewSSTWriter(path string, opts *Options) (*SSTWriter, error) {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+	opts = opts.sanitize()
+
+	b, err := sstable.NewBuilder(path, opts.Table)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SSTWriter{opts: opts, path: path, b: b}, nil
+}
+
+// add writes an entry of any type as is.
+func (w *SSTWriter) add(key, value []byte, t sstable.TombstoneType) error {
+	return w.b.AddEntry(key, value, t)
+}
+
+func (w *SSTWriter) Put(key, value []byte) error {
+	return w.b.AddEntry(key, value, sstable.NO_TOMBSTONE)
+}
+
+// PutWithTTL writes a value expiring ttl after the call.
+func (w *SSTWriter) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	expireAt := w.opts.Clock.Now().Add(ttl)
+
+	return w.b.AddEntry(key, sstable.EncodeExpiring(value, expireAt), sstable.EXPIRING)
+}
+
+func (w *SSTWriter) Del(key []byte) error {
+	return w.b.AddEntry(key, nil, sstable.TOMBSTONE)
+}
+
+func (w *SSTWriter) SingleDelete(key []byte) error {
+	return w.b.AddEntry(key, nil, sstable.SINGLE_DELETE)
+}
+
+// Merge writes a merge operand applied on top of the value of key in the
+// store the table is ingested into.
+func (w *SSTWriter) Merge(key, operand []byte) error {
+	return w.b.AddEntry(key, merge.EncodeOperands([][]byte{operand}), sstable.MERGE)
+}
+
+// DeleteRange deletes [start, end) in the tables older than this one. It
+// may be called in any order with respect to the other writes.
+func (w *SSTWriter) DeleteRange(start, end []byte) error {
+	return w.b.AddRangeTombstone(start, end)
+}
+
+// Finish writes the table and syncs it.
+func (w *SSTWriter) Finish() (ExternalFileInfo, error) {
+	if err := w.b.Finish(); err != nil {
+		return ExternalFileInfo{}, err
+	}
+
+	tbl, err := sstable.OpenTable(w.path, w.opts.Table)
+	if err != nil {
+		return ExternalFileInfo{}, err
+	}
+	defer tbl.Close()
+
+	smallest, _ := tbl.Property(sstable.PROP_SMALLEST_KEY)
+	largest, _ := tbl.Property(sstable.PROP_LARGEST_KEY)
+
+	return ExternalFileInfo{
+		Path:        w.path,
+		SmallestKey: append([]byte(nil), smallest...),
+		LargestKey:  append([]byte(nil), largest...),
+		NumEntries:  w.b.NumEntries(),
+	}, nil
+}
+
+// Abandon discards the table.
+func (w *SSTWriter) Abandon() error {
+	return w.b.Abandon()
+}
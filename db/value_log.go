@@ -0,0 +1,399 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/logging"
+	"github.com/sosomasox/LSM-Tree-based-Storage/sstable"
+	"github.com/sosomasox/LSM-Tree-based-Storage/vlog"
+	"github.com/sosomasox/LSM-Tree-based-Storage/wal"
+)
+
+const (
+	VALUE_LOG_SUFFIX string = ".vlog"
+)
+
+func (cf *ColumnFamily) valueLogPath(number uint64) string {
+	return filepath.Join(cf.dir, fmt.Sprintf("%06d%s", number, VALUE_LOG_SUFFIX))
+}
+
+// valueLog is an open value log of a column family. The column family holds
+// a reference until the value log is collected, and reads and iterators
+// hold one while they use it; the last one closes the value log, and
+// removes it once collected.
+type valueLog struct {
+	r         *vlog.Reader
+	refs      atomic.Int32
+	collected atomic.Bool
+}
+
+func newValueLog(r *vlog.Reader) *valueLog {
+	vl := &valueLog{r: r}
+	vl.refs.Store(1)
+
+	return vl
+}
+
+// unrefValueLog drops a reference to vl.
+func (cf *ColumnFamily) unrefValueLog(vl *valueLog) error {
+	if vl.refs.Add(-1) > 0 {
+		return nil
+	}
+
+	vl.r.Close()
+	if !vl.collected.Load() {
+		return nil
+	}
+
+	number := vl.r.Number()
+	if err := os.Remove(cf.valueLogPath(number)); err != nil {
+		cf.opts.Logger.Error("value log removal failed", logging.KEY_COLUMN_FAMILY, cf.name, logging.KEY_NUMBER, number, logging.KEY_ERROR, err)
+		return err
+	}
+	cf.opts.Logger.Info("value log removed", logging.KEY_COLUMN_FAMILY, cf.name, logging.KEY_NUMBER, number)
+
+	return nil
+}
+
+// refValueLogs returns the value logs of the column family, each with a
+// reference the caller drops with unrefValueLogs.
+func (cf *ColumnFamily) refValueLogs() map[uint64]*valueLog {
+	cf.vlogmu.RLock()
+	defer cf.vlogmu.RUnlock()
+
+	vlogs := make(map[uint64]*valueLog, len(cf.vlogs))
+	for number, vl := range cf.vlogs {
+		vl.refs.Add(1)
+		vlogs[number] = vl
+	}
+
+	return vlogs
+}
+
+func (cf *ColumnFamily) unrefValueLogs(vlogs map[uint64]*valueLog) {
+	for _, vl := range vlogs {
+		cf.unrefValueLog(vl)
+	}
+}
+
+// loadValueLogs opens the value logs in the directory of the column
+// family.
+func (cf *ColumnFamily) loadValueLogs() error {
+	entries, err := os.ReadDir(cf.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		if filepath.Ext(name) != VALUE_LOG_SUFFIX {
+			continue
+		}
+
+		number, err := strconv.ParseUint(strings.TrimSuffix(name, VALUE_LOG_SUFFIX), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		r, err := vlog.Open(cf.valueLogPath(number), number)
+		if err != nil {
+			return err
+		}
+		cf.vlogs[number] = newValueLog(r)
+		cf.db.bumpNumber(number)
+	}
+
+	return nil
+}
+
+// readValue returns the value a VALUE_POINTER entry points to.
+func (cf *ColumnFamily) readValue(ptr []byte) ([]byte, error) {
+	p, err := vlog.DecodePointer(ptr)
+	if err != nil {
+		return nil, err
+	}
+
+	cf.vlogmu.RLock()
+	vl, ok := cf.vlogs[p.File]
+	if ok {
+		vl.refs.Add(1)
+	}
+	cf.vlogmu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: value log %d not found", vlog.ErrInvalidPointer, p.File)
+	}
+	defer cf.unrefValueLog(vl)
+
+	_, value, err := vl.r.Read(p)
+
+	return value, err
+}
+
+// readValue is like ColumnFamily.readValue but reads the value logs held by
+// the iterator.
+func (it *liveIterator) readValue(ptr []byte) ([]byte, error) {
+	p, err := vlog.DecodePointer(ptr)
+	if err != nil {
+		return nil, err
+	}
+
+	vl, ok := it.vlogs[p.File]
+	if !ok {
+		return nil, fmt.Errorf("%w: value log %d not found", vlog.ErrInvalidPointer, p.File)
+	}
+
+	_, value, err := vl.r.Read(p)
+
+	return value, err
+}
+
+// closeValueLogs closes the value logs of the column family.
+func (cf *ColumnFamily) closeValueLogs() {
+	cf.vlogmu.Lock()
+	defer cf.vlogmu.Unlock()
+
+	for number, vl := range cf.vlogs {
+		cf.unrefValueLog(vl)
+		delete(cf.vlogs, number)
+	}
+}
+
+// tableWriter adds entries to a new table, moving values of at least
+// Options.ValueThreshold bytes to a new value log created on demand.
+type tableWriter struct {
+	cf *ColumnFamily
+	b  *sstable.Builder
+	w  *vlog.Writer
+	// value logs whose values pointed to are moved to the new value log.
+	relocate map[uint64]bool
+}
+
+func (cf *ColumnFamily) newTableWriter(b *sstable.Builder, relocate map[uint64]bool) *tableWriter {
+	return &tableWriter{cf: cf, b: b, relocate: relocate}
+}
+
+func (tw *tableWriter) add(key, value []byte, t sstable.TombstoneType) error {
+	threshold := tw.cf.opts.ValueThreshold
+
+	switch {
+	case t == sstable.NO_TOMBSTONE && threshold > 0 && len(value) >= threshold:
+		ptr, err := tw.append(key, value)
+		if err != nil {
+			return err
+		}
+		value, t = ptr, sstable.VALUE_POINTER
+
+	case t == sstable.VALUE_POINTER && len(tw.relocate) > 0:
+		p, err := vlog.DecodePointer(value)
+		if err != nil {
+			return err
+		}
+
+		if tw.relocate[p.File] {
+			v, err := tw.cf.readValue(value)
+			if err != nil {
+				return err
+			}
+			if value, err = tw.append(key, v); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tw.b.AddEntry(key, value, t)
+}
+
+// append writes the value to the new value log and returns its pointer.
+func (tw *tableWriter) append(key, value []byte) ([]byte, error) {
+	if tw.w == nil {
+		db := tw.cf.db
+
+		number := db.nextNumber
+		db.nextNumber += 1
+
+		w, err := vlog.Create(tw.cf.valueLogPath(number), number)
+		if err != nil {
+			return nil, err
+		}
+		tw.w = w
+	}
+
+	p, err := tw.w.Append(key, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.Encode(), nil
+}
+
+// finish makes the value log durable before the table pointing to it.
+func (tw *tableWriter) finish() error {
+	if tw.w == nil {
+		return tw.b.Finish()
+	}
+	path := tw.cf.valueLogPath(tw.w.Number())
+
+	if err := tw.w.Finish(); err != nil {
+		tw.b.Abandon()
+		os.Remove(path)
+		return err
+	}
+
+	if err := tw.b.Finish(); err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	// the table points to the value log, so neither is kept without the
+	// other.
+	r, err := vlog.Open(path, tw.w.Number())
+	if err != nil {
+		os.Remove(tw.b.Path())
+		os.Remove(path)
+		return err
+	}
+
+	tw.cf.vlogmu.Lock()
+	tw.cf.vlogs[tw.w.Number()] = newValueLog(r)
+	tw.cf.vlogmu.Unlock()
+
+	return nil
+}
+
+// size returns the bytes written to the table and the value log.
+func (tw *tableWriter) size() uint64 {
+	size := tw.b.FileSize()
+	if tw.w != nil {
+		size += tw.w.Size()
+	}
+
+	return size
+}
+
+func (tw *tableWriter) abandon() {
+	tw.b.Abandon()
+	if tw.w != nil {
+		tw.w.Abandon()
+	}
+}
+
+// CollectValueLogs reclaims the space of value logs in which at least
+// discardRatio of the bytes belong to overwritten or deleted values. Their
+// live values are rewritten to a new value log by a compaction of every
+// table, which then points to the new copies, and the value logs are
+// removed.
+//
+// Value logs still read by iterators or reads in flight are removed once
+// they are done.
+func (cf *ColumnFamily) CollectValueLogs(discardRatio float64) error {
+	db := cf.db
+
+	db.rwmu.Lock()
+	defer db.rwmu.Unlock()
+
+	if err := db.check(cf, wal.Recode{}); err != nil {
+		return err
+	}
+
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+
+	if err := db.flush(FLUSH_REASON_VALUE_LOG_GC); err != nil {
+		return err
+	}
+
+	live, err := cf.liveValueBytes()
+	if err != nil {
+		return err
+	}
+
+	cf.vlogmu.RLock()
+	relocate := map[uint64]bool{}
+	compact := false
+	for number, vl := range cf.vlogs {
+		size := vl.r.Size()
+		if size == 0 || float64(size-live[number])/float64(size) >= discardRatio {
+			relocate[number] = true
+			compact = compact || live[number] > 0
+		}
+	}
+	cf.vlogmu.RUnlock()
+
+	if compact {
+		if err := cf.compact(cf.tables, relocate, COMPACTION_REASON_VALUE_LOG_GC); err != nil {
+			return err
+		}
+	}
+
+	numbers := make([]uint64, 0, len(relocate))
+	for number := range relocate {
+		numbers = append(numbers, number)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	for _, number := range numbers {
+		cf.vlogmu.Lock()
+		vl := cf.vlogs[number]
+		delete(cf.vlogs, number)
+		cf.vlogmu.Unlock()
+
+		vl.collected.Store(true)
+		if err := cf.unrefValueLog(vl); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// liveValueBytes returns the size of the records live tables point to in
+// each value log.
+func (cf *ColumnFamily) liveValueBytes() (map[uint64]uint64, error) {
+	it, err := cf.newLiveIterator(false, cf.tables, true)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	live := map[uint64]uint64{}
+	for it.HasNext() {
+		e, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		if e.Type != sstable.VALUE_POINTER {
+			continue
+		}
+
+		p, err := vlog.DecodePointer(e.Value)
+		if err != nil {
+			return nil, err
+		}
+		live[p.File] += p.Size
+	}
+
+	return live, nil
+}
+
+// CollectValueLogs reclaims value logs of the default column family. See
+// ColumnFamily.CollectValueLogs.
+func (db *DB) CollectValueLogs(discardRatio float64) error {
+	return db.def.CollectValueLogs(discardRatio)
+}
+
+// NumValueLogs returns the number of value logs of the column family.
+func (cf *ColumnFamily) NumValueLogs() int {
+	cf.vlogmu.RLock()
+	defer cf.vlogmu.RUnlock()
+
+	return len(cf.vlogs)
+}
@@ -0,0 +1,222 @@
+package db
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/merge"
+	"github.com/sosomasox/LSM-Tree-based-Storage/sstable"
+)
+
+func TestValueLog(t *testing.T) {
+	for scenario, fn := range map[string]func(
+		t *testing.T, dir string,
+	){
+		"Separate":        test_vlog_Separate,
+		"Collect":         test_vlog_Collect,
+		"CollectIterator": test_vlog_CollectIterator,
+		"Merge":           test_vlog_Merge,
+	} {
+		fn := fn // https://github.com/golang/go/wiki/CommonMistakes
+		t.Run(scenario, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "test_db_vlog_")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			fn(t, dir)
+		})
+	}
+}
+
+func vlogOptions() *Options {
+	opts := DefaultOptions()
+	opts.ValueThreshold = 64
+
+	return opts
+}
+
+func blob(c byte) string {
+	return string(bytes.Repeat([]byte{c}, 256))
+}
+
+// tableTypes returns the type of every entry of the newest table.
+func tableTypes(t *testing.T, cf *ColumnFamily) map[string]sstable.TombstoneType {
+	h, err := cf.tcache.Acquire(cf.tables[0].path)
+	require.NoError(t, err)
+	defer h.Release()
+
+	types := map[string]sstable.TombstoneType{}
+	for itr := h.Table().NewIterator(nil); itr.HasNext(); {
+		key, _, ty, err := itr.NextEntry()
+		require.NoError(t, err)
+		types[string(key)] = ty
+	}
+
+	return types
+}
+
+func test_vlog_Separate(t *testing.T, dir string) {
+	db, err := Open(dir, vlogOptions())
+	require.NoError(t, err)
+
+	require.NoError(t, db.Put([]byte("a"), []byte(blob('a'))))
+	require.NoError(t, db.Put([]byte("b"), []byte("small")))
+	require.NoError(t, db.Flush())
+
+	// only large values are moved out of the table.
+	require.Equal(t, 1, db.def.NumValueLogs())
+	require.Equal(t, map[string]sstable.TombstoneType{
+		"a": sstable.VALUE_POINTER,
+		"b": sstable.NO_TOMBSTONE,
+	}, tableTypes(t, db.def))
+
+	requireGet(t, db, "a", blob('a'), true)
+	requireGet(t, db, "b", "small", true)
+
+	// compaction copies pointers, not values.
+	require.NoError(t, db.Put([]byte("c"), []byte("small")))
+	require.NoError(t, db.Compact())
+	require.Equal(t, 1, db.def.NumValueLogs())
+	require.Equal(t, sstable.VALUE_POINTER, tableTypes(t, db.def)["a"])
+
+	require.NoError(t, db.Close())
+
+	db, err = Open(dir, vlogOptions())
+	require.NoError(t, err)
+	defer db.Close()
+
+	requireGet(t, db, "a", blob('a'), true)
+
+	itr, err := db.NewIterator()
+	require.NoError(t, err)
+	defer itr.Close()
+
+	key, value, err := itr.Next()
+	require.NoError(t, err)
+	require.Equal(t, []byte("a"), key)
+	require.Equal(t, []byte(blob('a')), value)
+}
+
+func test_vlog_Collect(t *testing.T, dir string) {
+	db, err := Open(dir, vlogOptions())
+	require.NoError(t, err)
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, db.Put([]byte(key), []byte(blob(key[0]))))
+	}
+	require.NoError(t, db.Flush())
+
+	// three of the four values of the first value log become garbage.
+	require.NoError(t, db.Put([]byte("a"), []byte(blob('A'))))
+	require.NoError(t, db.Put([]byte("b"), []byte(blob('B'))))
+	require.NoError(t, db.Del([]byte("c")))
+	require.NoError(t, db.Flush())
+	require.Equal(t, 2, db.def.NumValueLogs())
+
+	check := func() {
+		requireGet(t, db, "a", blob('A'), true)
+		requireGet(t, db, "b", blob('B'), true)
+		requireGet(t, db, "c", "", false)
+		requireGet(t, db, "d", blob('d'), true)
+	}
+	check()
+
+	// the second value log is fully live and stays.
+	require.NoError(t, db.CollectValueLogs(0.9))
+	require.Equal(t, 2, db.def.NumValueLogs())
+
+	// d is moved to a new value log and the first one removed.
+	require.NoError(t, db.CollectValueLogs(0.5))
+	require.Equal(t, 2, db.def.NumValueLogs())
+	check()
+
+	live, err := db.def.liveValueBytes()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(live))
+	for number := range live {
+		_, ok := db.def.vlogs[number]
+		require.True(t, ok)
+	}
+
+	require.NoError(t, db.Close())
+
+	db, err = Open(dir, vlogOptions())
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.Equal(t, 2, db.def.NumValueLogs())
+	check()
+
+	// once every value is deleted, every value log goes.
+	for _, key := range []string{"a", "b", "d"} {
+		require.NoError(t, db.Del([]byte(key)))
+	}
+	require.NoError(t, db.CollectValueLogs(1))
+	require.Equal(t, 0, db.def.NumValueLogs())
+}
+
+func test_vlog_CollectIterator(t *testing.T, dir string) {
+	db, err := Open(dir, vlogOptions())
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.Put([]byte("a"), []byte(blob('a'))))
+	require.NoError(t, db.Put([]byte("b"), []byte(blob('b'))))
+	require.NoError(t, db.Flush())
+	require.Equal(t, 1, db.def.NumValueLogs())
+
+	var numbers []uint64
+	for number := range db.def.vlogs {
+		numbers = append(numbers, number)
+	}
+	path := db.def.valueLogPath(numbers[0])
+
+	itr, err := db.NewIterator()
+	require.NoError(t, err)
+
+	require.NoError(t, db.Del([]byte("a")))
+	require.NoError(t, db.Del([]byte("b")))
+	require.NoError(t, db.CollectValueLogs(1))
+	require.Equal(t, 0, db.def.NumValueLogs())
+
+	// the iterator still reads the collected value log, which is removed
+	// once the iterator is closed.
+	_, err = os.Stat(path)
+	require.NoError(t, err)
+
+	for _, want := range []string{"a", "b"} {
+		require.True(t, itr.HasNext())
+		key, value, err := itr.Next()
+		require.NoError(t, err)
+		require.Equal(t, want, string(key))
+		require.Equal(t, blob(want[0]), string(value))
+	}
+	require.False(t, itr.HasNext())
+	itr.Close()
+
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+}
+
+func test_vlog_Merge(t *testing.T, dir string) {
+	opts := vlogOptions()
+	opts.MergeOperator = merge.Append{}
+
+	db, err := Open(dir, opts)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.Put([]byte("a"), []byte(blob('a'))))
+	require.NoError(t, db.Flush())
+	require.NoError(t, db.Merge([]byte("a"), []byte("tail")))
+
+	// operands are applied to the value read from the value log.
+	requireGet(t, db, "a", blob('a')+"tail", true)
+
+	require.NoError(t, db.Compact())
+	requireGet(t, db, "a", blob('a')+"tail", true)
+	require.Equal(t, sstable.VALUE_POINTER, tableTypes(t, db.def)["a"])
+}
@@ -1,17 +1,25 @@
 module github.com/sosomasox/LSM-Tree-based-Storage
 
-go 1.20
+go 1.21
 
 require (
 	github.com/bits-and-blooms/bloom v2.0.3+incompatible
 	github.com/emirpasic/gods v1.18.1
+	github.com/prometheus/client_golang v1.19.1
 	github.com/stretchr/testify v1.8.1
 )
 
 require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
 	github.com/spaolacci/murmur3 v1.1.0 // indirect
 	github.com/willf/bitset v1.1.11 // indirect
+	golang.org/x/sys v0.17.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,39 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Keys of the attributes shared by the records of the engine.
+const (
+	KEY_PATH          string = "path"
+	KEY_NUMBER        string = "number"
+	KEY_COLUMN_FAMILY string = "column_family"
+	KEY_REASON        string = "reason"
+	KEY_OFFSET        string = "offset"
+	KEY_ERROR         string = "err"
+)
+
+var (
+	// drops every record.
+	Discard = slog.New(discardHandler{})
+)
+
+// OrDefault returns l, or Discard when l is nil. Stores, WALs and tables
+// read their logger through their options so that they stay silent unless
+// told otherwise.
+func OrDefault(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return Discard
+	}
+
+	return l
+}
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
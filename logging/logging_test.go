@@ -0,0 +1,45 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogging(t *testing.T) {
+	for scenario, fn := range map[string]func(
+		t *testing.T,
+	){
+		"Discard":   test_Discard,
+		"OrDefault": test_OrDefault,
+	} {
+		fn := fn // https://github.com/golang/go/wiki/CommonMistakes
+		t.Run(scenario, func(t *testing.T) {
+			fn(t)
+		})
+	}
+}
+
+func test_Discard(t *testing.T) {
+	for _, level := range []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelWarn, slog.LevelError} {
+		require.False(t, Discard.Enabled(context.Background(), level))
+	}
+
+	l := Discard.With(KEY_PATH, "000001.sst").WithGroup("table")
+	require.False(t, l.Enabled(context.Background(), slog.LevelError))
+	l.Error("table corrupted")
+}
+
+func test_OrDefault(t *testing.T) {
+	require.Equal(t, Discard, OrDefault(nil))
+
+	var buf bytes.Buffer
+	l := slog.New(slog.NewTextHandler(&buf, nil))
+	require.Equal(t, l, OrDefault(l))
+
+	OrDefault(l).Info("wal replayed", KEY_NUMBER, 1)
+	require.Contains(t, buf.String(), `msg="wal replayed" number=1`)
+}
@@ -0,0 +1,104 @@
+package memtable
+
+import (
+	"sync"
+)
+
+const (
+	DEFAULT_ARENA_CHUNK_SIZE int = 1 << 20 // Byte
+)
+
+// Arena hands out byte slices carved from large preallocated chunks, so
+// that copying many small keys and values costs a few big allocations
+// instead of one per entry. Memory is only reclaimed all at once by Reset.
+type Arena struct {
+	rwmu      sync.RWMutex
+	chunkSize int
+	chunks    [][]byte
+	// chunk small allocations are carved from. Dedicated chunks never
+	// replace it, so its tail is not wasted.
+	cur []byte
+	// bytes reserved by all chunks.
+	size uint64
+	// bytes handed out.
+	used uint64
+}
+
+func NewArena(chunkSize int) *Arena {
+	if chunkSize <= 0 {
+		chunkSize = DEFAULT_ARENA_CHUNK_SIZE
+	}
+
+	return &Arena{
+		chunkSize: chunkSize,
+	}
+}
+
+// Alloc returns a zeroed slice of n bytes owned by the arena. Requests
+// larger than a quarter chunk get a dedicated chunk so they don't waste
+// the tail of the current one.
+func (a *Arena) Alloc(n int) []byte {
+	a.rwmu.Lock()
+	defer a.rwmu.Unlock()
+
+	a.used += uint64(n)
+
+	if n > a.chunkSize/4 {
+		buf := make([]byte, n)
+		a.chunks = append(a.chunks, buf)
+		a.size += uint64(n)
+		return buf
+	}
+
+	if cap(a.cur)-len(a.cur) < n {
+		a.newChunk()
+	}
+
+	buf := a.cur[len(a.cur) : len(a.cur)+n : len(a.cur)+n]
+	a.cur = a.cur[:len(a.cur)+n]
+
+	return buf
+}
+
+// newChunk must be called with the arena lock held.
+func (a *Arena) newChunk() {
+	a.cur = make([]byte, 0, a.chunkSize)
+	a.chunks = append(a.chunks, a.cur)
+	a.size += uint64(a.chunkSize)
+}
+
+// Copy returns a copy of b allocated from the arena.
+func (a *Arena) Copy(b []byte) []byte {
+	buf := a.Alloc(len(b))
+	copy(buf, b)
+
+	return buf
+}
+
+// Size returns the bytes reserved by the arena's chunks.
+func (a *Arena) Size() uint64 {
+	a.rwmu.RLock()
+	defer a.rwmu.RUnlock()
+
+	return a.size
+}
+
+// Used returns the bytes handed out by Alloc.
+func (a *Arena) Used() uint64 {
+	a.rwmu.RLock()
+	defer a.rwmu.RUnlock()
+
+	return a.used
+}
+
+// Reset drops every chunk at once. Slices handed out earlier stay valid
+// for as long as they are referenced, but no longer count against Size.
+func (a *Arena) Reset() {
+	a.rwmu.Lock()
+	defer a.rwmu.Unlock()
+
+	a.chunks = nil
+	a.cur = nil
+	a.size = 0
+	a.used = 0
+}
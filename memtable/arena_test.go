@@ -0,0 +1,122 @@
+package memtable
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArena(t *testing.T) {
+	for scenario, fn := range map[string]func(
+		t *testing.T, a *Arena,
+	){
+		"Alloc":    test_arena_Alloc,
+		"Mixed":    test_arena_Mixed,
+		"Copy":     test_arena_Copy,
+		"Reset":    test_arena_Reset,
+		"SkipList": test_arena_SkipList,
+	} {
+		fn := fn // https://github.com/golang/go/wiki/CommonMistakes
+		t.Run(scenario, func(t *testing.T) {
+			fn(t, NewArena(64))
+		})
+	}
+}
+
+func test_arena_Alloc(t *testing.T, a *Arena) {
+	buf := a.Alloc(10)
+	require.Equal(t, 10, len(buf))
+	require.Equal(t, 10, cap(buf))
+	require.Equal(t, uint64(64), a.Size())
+
+	// fits in the current chunk.
+	a.Alloc(10)
+	require.Equal(t, uint64(64), a.Size())
+
+	// does not fit, so a new chunk is reserved.
+	a.Alloc(15)
+	a.Alloc(15)
+	a.Alloc(15)
+	require.Equal(t, uint64(128), a.Size())
+
+	// large allocations get a dedicated chunk.
+	a.Alloc(100)
+	require.Equal(t, uint64(228), a.Size())
+	require.Equal(t, uint64(10+10+15+15+15+100), a.Used())
+}
+
+func test_arena_Mixed(t *testing.T, a *Arena) {
+	a.Alloc(10)
+
+	// a dedicated chunk leaves the current one in use.
+	a.Alloc(100)
+	a.Alloc(10)
+	a.Alloc(100)
+	a.Alloc(16)
+	a.Alloc(16)
+	require.Equal(t, uint64(64+100+100), a.Size())
+
+	// only once the current chunk is full is another one reserved.
+	a.Alloc(16)
+	require.Equal(t, uint64(64+100+100+64), a.Size())
+
+	// the memtable reserves little more than the data it holds.
+	sl := NewSkipListWithArena(NewArena(1 << 10))
+	var data uint64
+	for i := 0; i < 64; i++ {
+		value := make([]byte, 8)
+		if i%4 == 0 {
+			value = make([]byte, 512)
+		}
+		key := []byte(fmt.Sprintf("key%03d", i))
+		sl.Put(key, value)
+		data += uint64(len(key) + len(value))
+	}
+
+	usage := sl.MemoryUsage()
+	require.GreaterOrEqual(t, usage.Arena, data)
+	require.Less(t, usage.Arena, data+2<<10)
+}
+
+func test_arena_Copy(t *testing.T, a *Arena) {
+	src := []byte("abc")
+	dst := a.Copy(src)
+	src[0] = 'X'
+
+	require.Equal(t, []byte("abc"), dst)
+
+	// appending to a copy must not clobber the next allocation.
+	next := a.Copy([]byte("def"))
+	_ = append(dst, 'Z')
+	require.Equal(t, []byte("def"), next)
+}
+
+func test_arena_Reset(t *testing.T, a *Arena) {
+	a.Alloc(10)
+	a.Reset()
+
+	require.Equal(t, uint64(0), a.Size())
+	require.Equal(t, uint64(0), a.Used())
+}
+
+func test_arena_SkipList(t *testing.T, a *Arena) {
+	sl := NewSkipListWithArena(a)
+
+	key := []byte("key")
+	value := []byte("value")
+	sl.Put(key, value)
+
+	// the skiplist must own its copies.
+	key[0] = 'X'
+	value[0] = 'X'
+
+	got, found, _ := sl.Get([]byte("key"))
+	require.Equal(t, true, found)
+	require.Equal(t, []byte("value"), got)
+	require.Equal(t, a.Size()+SKIPLIST_NODE_OVERHEAD, sl.Size())
+
+	sl.Clear()
+	require.Equal(t, uint64(0), sl.Size())
+	require.Equal(t, uint64(0), a.Size())
+}
@@ -0,0 +1,133 @@
+package memtable
+
+import (
+	"io"
+	"sort"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/comparator"
+	"github.com/sosomasox/LSM-Tree-based-Storage/sstable"
+)
+
+// entry is a memtable entry in the form it is flushed to a table.
+type entry struct {
+	key   []byte
+	value []byte
+	t     sstable.TombstoneType
+}
+
+// sliceIterator iterates over entries collected up front, e.g. from the
+// tree while holding its lock.
+type sliceIterator struct {
+	cmp     comparator.Comparator
+	entries []entry
+	err     error
+}
+
+var (
+	_ sstable.EntryIterator = (*sliceIterator)(nil)
+	_ sstable.Seeker        = (*sliceIterator)(nil)
+)
+
+func (itr *sliceIterator) HasNext() bool {
+	return itr.err != nil || len(itr.entries) > 0
+}
+
+func (itr *sliceIterator) NextEntry() (key, value []byte, t sstable.TombstoneType, err error) {
+	if itr.err != nil {
+		err, itr.err = itr.err, nil
+		itr.entries = nil
+		return []byte(""), []byte(""), sstable.NO_TOMBSTONE, err
+	}
+
+	if len(itr.entries) == 0 {
+		return []byte(""), []byte(""), sstable.NO_TOMBSTONE, io.EOF
+	}
+
+	e := itr.entries[0]
+	itr.entries = itr.entries[1:]
+
+	return e.key, e.value, e.t, nil
+}
+
+func (itr *sliceIterator) Seek(key []byte) {
+	i := sort.Search(len(itr.entries), func(i int) bool {
+		return itr.cmp.Compare(itr.entries[i].key, key) >= 0
+	})
+	itr.entries = itr.entries[i:]
+}
+
+// skipListIterator walks the bottom level of a skiplist. It never blocks
+// writers and observes entries added after it was created.
+type skipListIterator struct {
+	sl *SkipList
+	x  *skipNode
+	// value of x, loaded by HasNext.
+	v *skipValue
+}
+
+var (
+	_ sstable.EntryIterator = (*skipListIterator)(nil)
+	_ sstable.Seeker        = (*skipListIterator)(nil)
+)
+
+// HasNext moves to the next node whose key is not absent.
+func (itr *skipListIterator) HasNext() bool {
+	for itr.v == nil && itr.x != nil {
+		if v := itr.x.value.Load(); !v.absent {
+			itr.v = v
+			break
+		}
+		itr.x = itr.x.next[0].Load()
+	}
+
+	return itr.v != nil
+}
+
+func (itr *skipListIterator) NextEntry() (key, value []byte, t sstable.TombstoneType, err error) {
+	if !itr.HasNext() {
+		return []byte(""), []byte(""), sstable.NO_TOMBSTONE, io.EOF
+	}
+
+	x, v := itr.x, itr.v
+	itr.x = x.next[0].Load()
+	itr.v = nil
+
+	e, err := itr.sl.entry(x.key, v)
+	if err != nil {
+		itr.x = nil
+		return []byte(""), []byte(""), sstable.NO_TOMBSTONE, err
+	}
+
+	return e.key, e.value, e.t, nil
+}
+
+func (itr *skipListIterator) Seek(key []byte) {
+	itr.x = itr.sl.findGreaterOrEqual(key, nil)
+	itr.v = nil
+}
+
+// flushTo adds the entries of itr and the range tombstones to b and
+// finishes the table. The builder is abandoned on failure.
+func flushTo(b *sstable.Builder, itr sstable.EntryIterator, rangeDels []sstable.RangeTombstone) error {
+	for itr.HasNext() {
+		key, value, t, err := itr.NextEntry()
+		if err != nil {
+			b.Abandon()
+			return err
+		}
+
+		if err := b.AddEntry(key, value, t); err != nil {
+			b.Abandon()
+			return err
+		}
+	}
+
+	for _, rt := range rangeDels {
+		if err := b.AddRangeTombstone(rt.Start, rt.End); err != nil {
+			b.Abandon()
+			return err
+		}
+	}
+
+	return b.Finish()
+}
@@ -0,0 +1,69 @@
+package memtable
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/merge"
+	"github.com/sosomasox/LSM-Tree-based-Storage/sstable"
+)
+
+func TestIterator(t *testing.T) {
+	for name, rep := range reps {
+		rep := rep
+		t.Run(name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.Rep = rep
+			opts.MergeOperator = merge.Uint64Add{}
+
+			test_iterator(t, NewRepWithOptions(opts))
+		})
+	}
+}
+
+func test_iterator(t *testing.T, mt MemTableRep) {
+	expireAt := time.Now().Add(time.Hour)
+
+	mt.Put([]byte("a"), []byte("A"))
+	mt.Del([]byte("b"))
+	mt.Put([]byte("c"), []byte("1"))
+	mt.Merge([]byte("c"), []byte("2"))
+	mt.Merge([]byte("d"), []byte("3"))
+	mt.PutWithExpiry([]byte("e"), []byte("E"), expireAt)
+
+	itr := mt.NewIterator()
+	for _, expected := range []struct {
+		key   string
+		value []byte
+		t     sstable.TombstoneType
+	}{
+		{"a", []byte("A"), sstable.NO_TOMBSTONE},
+		{"b", nil, sstable.TOMBSTONE},
+		// resolved against the base in the memtable.
+		{"c", []byte("3"), sstable.NO_TOMBSTONE},
+		{"d", merge.EncodeOperands([][]byte{[]byte("3")}), sstable.MERGE},
+		{"e", sstable.EncodeExpiring([]byte("E"), expireAt), sstable.EXPIRING},
+	} {
+		require.Equal(t, true, itr.HasNext())
+
+		key, value, ty, err := itr.NextEntry()
+		require.NoError(t, err)
+		require.Equal(t, []byte(expected.key), key)
+		require.Equal(t, expected.value, value)
+		require.Equal(t, expected.t, ty)
+	}
+	require.Equal(t, false, itr.HasNext())
+
+	_, _, _, err := itr.NextEntry()
+	require.ErrorIs(t, err, io.EOF)
+
+	itr = mt.NewIterator()
+	itr.(sstable.Seeker).Seek([]byte("bb"))
+
+	key, _, _, err := itr.NextEntry()
+	require.NoError(t, err)
+	require.Equal(t, []byte("c"), key)
+}
@@ -1,27 +1,147 @@
 package memtable
 
 import (
+	"errors"
 	"os"
 	"sync"
+	"time"
 
 	rbt "github.com/emirpasic/gods/trees/redblacktree"
 
+	"github.com/sosomasox/LSM-Tree-based-Storage/clock"
+	"github.com/sosomasox/LSM-Tree-based-Storage/comparator"
+	"github.com/sosomasox/LSM-Tree-based-Storage/merge"
 	"github.com/sosomasox/LSM-Tree-based-Storage/sstable"
 )
 
 type Tombstone struct{}
 
+// singleDelete is stored for keys deleted with SingleDelete whose value
+// is not in the memtable.
+type singleDelete struct{}
+
+// expiringValue is stored for values written with PutWithExpiry.
+type expiringValue struct {
+	value    []byte
+	expireAt time.Time
+}
+
+var (
+	// the two-file SSTable format cannot hold expiration times.
+	ErrUnsupportedTTL = errors.New("memtable: expiring entries need the table format")
+	// the two-file SSTable format cannot hold range tombstones.
+	ErrUnsupportedRangeDel = errors.New("memtable: range tombstones need the table format")
+)
+
+const (
+	// estimated bytes used by a red-black tree node besides its key and
+	// value bytes: the node struct, the key string header and the boxed
+	// value.
+	TREE_NODE_OVERHEAD uint64 = 96 // Byte
+)
+
+// MemoryUsage breaks down the memory held by a memtable.
+type MemoryUsage struct {
+	// bytes of keys, including keys of tombstones.
+	Keys uint64
+	// bytes of live values.
+	Values uint64
+	// number of entries, including tombstones.
+	Entries uint64
+	// number of tombstones.
+	Tombstones uint64
+	// number of range tombstones. Their bounds count as keys.
+	RangeTombstones uint64
+	// estimated per-entry bookkeeping bytes.
+	Overhead uint64
+	// bytes reserved by the arena, if the memtable uses one.
+	Arena uint64
+}
+
+// Total returns the bytes accounted to the memtable. For arena-backed
+// memtables it is the arena reservation, which already holds keys and
+// values.
+func (u MemoryUsage) Total() uint64 {
+	if u.Arena > 0 {
+		return u.Arena + u.Overhead
+	}
+
+	return u.Keys + u.Values + u.Overhead
+}
+
+// put accounts for storing a value of size bytes (or a tombstone) under
+// key. oldSize is the size of the previous value, if any.
+func (u *MemoryUsage) put(key []byte, found, oldTombstone bool, oldSize, size uint64, tombstone bool, overhead uint64) {
+	if !found {
+		u.Keys += uint64(len(key))
+		u.Entries += 1
+		u.Overhead += overhead
+	} else if oldTombstone {
+		u.Tombstones -= 1
+	} else {
+		u.Values -= oldSize
+	}
+
+	if tombstone {
+		u.Tombstones += 1
+	} else {
+		u.Values += size
+	}
+}
+
+// remove accounts for dropping the entry of key, holding a value of size
+// bytes or a tombstone.
+func (u *MemoryUsage) remove(key []byte, tombstone bool, size, overhead uint64) {
+	u.Keys -= uint64(len(key))
+	u.Entries -= 1
+	u.Overhead -= overhead
+
+	if tombstone {
+		u.Tombstones -= 1
+	} else {
+		u.Values -= size
+	}
+}
+
 type MemTable struct {
 	// read & write lock to control access to the in-memory tree.
 	rwmu sync.RWMutex
 	// the in-memory tree.
-	tree *rbt.Tree
-	size uint64
+	tree  *rbt.Tree
+	size  uint64
+	usage MemoryUsage
+	// resolves merge operands whose base is in the memtable.
+	op merge.Operator
+	// tells whether expiring values have expired.
+	clock clock.Clock
+	cmp   comparator.Comparator
+	// range tombstones, covering entries older than the memtable.
+	rangeDels []sstable.RangeTombstone
 }
 
 func New() *MemTable {
+	return NewWithComparator(comparator.Bytewise)
+}
+
+// NewWithComparator returns a memtable ordering keys with cmp.
+func NewWithComparator(cmp comparator.Comparator) *MemTable {
+	cmp = comparator.OrDefault(cmp)
+
+	// strings compare bytewise, so the default order needs no conversion.
+	if cmp == comparator.Bytewise {
+		return &MemTable{
+			tree:  rbt.NewWithStringComparator(),
+			clock: clock.System,
+			cmp:   cmp,
+		}
+	}
+
 	return &MemTable{
-		tree: rbt.NewWithStringComparator(),
+		tree: rbt.NewWith(func(a, b interface{}) int {
+			return cmp.Compare([]byte(a.(string)), []byte(b.(string)))
+		}),
+		clock: clock.System,
+		cmp:   cmp,
 	}
 }
 
@@ -30,61 +150,227 @@ func (mt *MemTable) Clear() {
 	defer mt.rwmu.Unlock()
 
 	mt.tree.Clear()
+	mt.rangeDels = nil
 	mt.size = 0
+	mt.usage = MemoryUsage{}
 }
 
 func (mt *MemTable) Put(key, value []byte) {
 	mt.rwmu.Lock()
 	defer mt.rwmu.Unlock()
 
-	if val, found := mt.tree.Get(string(key)); !found {
-		mt.size += uint64(len(key) + len(value))
+	mt.account(key, uint64(len(value)), false)
+	mt.tree.Put(string(key), value)
+}
+
+// PutWithExpiry stores value under key until expireAt, after which the key
+// reads as deleted.
+func (mt *MemTable) PutWithExpiry(key, value []byte, expireAt time.Time) {
+	mt.rwmu.Lock()
+	defer mt.rwmu.Unlock()
+
+	mt.account(key, uint64(len(value)), false)
+	mt.tree.Put(string(key), expiringValue{value: value, expireAt: expireAt})
+}
+
+// expired reports whether a value expiring at expireAt has expired. The
+// zero time never expires.
+func (mt *MemTable) expired(expireAt time.Time) bool {
+	return !expireAt.IsZero() && sstable.Expired(expireAt, mt.clock.Now())
+}
+
+// Merge records a merge operand for key. Operands applying to a value or
+// tombstone held by the memtable are kept with it and resolved on flush.
+func (mt *MemTable) Merge(key, operand []byte) {
+	mt.rwmu.Lock()
+	defer mt.rwmu.Unlock()
+
+	val, found := mt.tree.Get(string(key))
+	value, tombstone, cur, expireAt := treeValue(val)
+	if mt.expired(expireAt) || (!found && sstable.Covers(mt.cmp, mt.rangeDels, key)) {
+		found, value, tombstone = true, nil, true
+	}
+
+	m := addOperand(mt.op, key, found, value, tombstone, cur, append([]byte(nil), operand...))
+
+	mt.account(key, m.size(), false)
+	mt.tree.Put(string(key), m)
+}
+
+// treeValue unpacks a value stored in the tree. expireAt is zero unless
+// the value was written with PutWithExpiry.
+func treeValue(val interface{}) (value []byte, tombstone bool, m *MergeOperands, expireAt time.Time) {
+	switch v := val.(type) {
+	case Tombstone, singleDelete:
+		return nil, true, nil, time.Time{}
+	case *MergeOperands:
+		return nil, false, v, time.Time{}
+	case expiringValue:
+		return v.value, false, nil, v.expireAt
+	case []byte:
+		return v, false, nil, time.Time{}
+	}
+
+	return nil, false, nil, time.Time{}
+}
+
+// account must be called with the write lock held, before the tree is
+// updated.
+func (mt *MemTable) account(key []byte, size uint64, tombstone bool) {
+	var oldSize uint64
+
+	val, found := mt.tree.Get(string(key))
+	old, oldTombstone, m, _ := treeValue(val)
+	if m != nil {
+		oldSize = m.size()
 	} else {
-		if val == (Tombstone{}) {
-			mt.size += uint64(len(value))
-		} else {
-			mt.size += uint64(len(value) - len(val.([]byte)))
-		}
+		oldSize = uint64(len(old))
 	}
 
-	mt.tree.Put(string(key), value)
+	mt.usage.put(key, found, oldTombstone, oldSize, size, tombstone, TREE_NODE_OVERHEAD)
+	mt.size = mt.usage.Total()
 }
 
+// Get returns the value of key. Expired values read as tombstones. Keys
+// with merge operands are reported as found only when the operands can be
+// resolved within the memtable; use Lookup to get the raw operands.
 func (mt *MemTable) Get(key []byte) (value []byte, found, tombstone bool) {
 	mt.rwmu.RLock()
 	defer mt.rwmu.RUnlock()
 
 	val, found := mt.tree.Get(string(key))
+	if !found {
+		if sstable.Covers(mt.cmp, mt.rangeDels, key) {
+			return []byte(""), false, true
+		}
+		return []byte(""), false, false
+	}
 
-	if val == (Tombstone{}) {
-		found = false
-		value = []byte("")
+	value, tombstone, m, expireAt := treeValue(val)
 
-		return value, found, true
+	if tombstone || mt.expired(expireAt) {
+		return []byte(""), false, true
 	}
 
+	if m != nil {
+		if !m.HasBase {
+			return []byte(""), false, false
+		}
+
+		value, err := m.resolve(mt.op, key)
+		if err != nil {
+			return []byte(""), false, false
+		}
+
+		return value, true, false
+	}
+
+	return value, true, false
+}
+
+// Lookup returns the raw entry for key. operands holds pending merge
+// operands, oldest first, to apply on top of the value or tombstone; t is
+// MERGE when their base is not in the memtable.
+func (mt *MemTable) Lookup(key []byte) (value []byte, operands [][]byte, t sstable.TombstoneType, found bool) {
+	mt.rwmu.RLock()
+	defer mt.rwmu.RUnlock()
+
+	val, found := mt.tree.Get(string(key))
 	if !found {
-		value = []byte("")
-	} else {
-		value = val.([]byte)
+		if sstable.Covers(mt.cmp, mt.rangeDels, key) {
+			return []byte(""), nil, sstable.TOMBSTONE, true
+		}
+		return []byte(""), nil, sstable.NO_TOMBSTONE, false
 	}
 
-	return value, found, false
+	value, tombstone, m, expireAt := treeValue(val)
+	switch {
+	case m != nil:
+		return m.lookup()
+	case tombstone || mt.expired(expireAt):
+		return []byte(""), nil, sstable.TOMBSTONE, true
+	}
+
+	return value, nil, sstable.NO_TOMBSTONE, true
 }
 
 func (mt *MemTable) Del(key []byte) {
 	mt.rwmu.Lock()
 	defer mt.rwmu.Unlock()
 
-	if value, found := mt.tree.Get(string(key)); found {
-		if value != (Tombstone{}) {
-			mt.size -= uint64(len(value.([]byte)))
+	mt.account(key, 0, true)
+	mt.tree.Put(string(key), Tombstone{})
+}
+
+// SingleDelete deletes a key that was written exactly once. When its value
+// is in the memtable both vanish; otherwise a single-delete tombstone is
+// kept, which compaction drops together with the value. The result of
+// reads is undefined if the key was written more than once, merged, or
+// mixed with Del.
+func (mt *MemTable) SingleDelete(key []byte) {
+	mt.rwmu.Lock()
+	defer mt.rwmu.Unlock()
+
+	val, found := mt.tree.Get(string(key))
+	value, tombstone, m, _ := treeValue(val)
+
+	if found && !tombstone && m == nil {
+		mt.usage.remove(key, false, uint64(len(value)), TREE_NODE_OVERHEAD)
+		mt.size = mt.usage.Total()
+		mt.tree.Remove(string(key))
+		return
+	}
+
+	mt.account(key, 0, true)
+	mt.tree.Put(string(key), singleDelete{})
+}
+
+// DeleteRange deletes every key in [start, end). Keys of the memtable in
+// the range become tombstones, while older keys are covered by a range
+// tombstone flushed along with the memtable. Empty ranges are ignored.
+func (mt *MemTable) DeleteRange(start, end []byte) {
+	mt.rwmu.Lock()
+	defer mt.rwmu.Unlock()
+
+	if mt.cmp.Compare(start, end) >= 0 {
+		return
+	}
+
+	// the iterator starts positioned at the ceiling node.
+	var keys []string
+	if node, ok := mt.tree.Ceiling(string(start)); ok {
+		for it := mt.tree.IteratorAt(node); mt.cmp.Compare([]byte(it.Key().(string)), end) < 0; {
+			keys = append(keys, it.Key().(string))
+			if !it.Next() {
+				break
+			}
 		}
 	}
 
-	mt.tree.Put(string(key), Tombstone{})
+	for _, key := range keys {
+		mt.account([]byte(key), 0, true)
+		mt.tree.Put(key, Tombstone{})
+	}
+
+	mt.rangeDels = append(mt.rangeDels, sstable.RangeTombstone{
+		Start: append([]byte(nil), start...),
+		End:   append([]byte(nil), end...),
+	})
+	mt.usage.RangeTombstones += 1
+	mt.usage.Keys += uint64(len(start) + len(end))
+	mt.size = mt.usage.Total()
+}
+
+// RangeTombstones returns the range tombstones of the memtable.
+func (mt *MemTable) RangeTombstones() []sstable.RangeTombstone {
+	mt.rwmu.RLock()
+	defer mt.rwmu.RUnlock()
+
+	return append([]sstable.RangeTombstone(nil), mt.rangeDels...)
 }
 
+// Size returns the bytes accounted to the memtable, including tombstones
+// and per-entry overhead.
 func (mt *MemTable) Size() uint64 {
 	mt.rwmu.RLock()
 	defer mt.rwmu.RUnlock()
@@ -92,10 +378,21 @@ func (mt *MemTable) Size() uint64 {
 	return mt.size
 }
 
+func (mt *MemTable) MemoryUsage() MemoryUsage {
+	mt.rwmu.RLock()
+	defer mt.rwmu.RUnlock()
+
+	return mt.usage
+}
+
 func (mt *MemTable) Flush(idxfile, segfile *os.File) (*sstable.SSTable, error) {
 	mt.rwmu.RLock()
 	defer mt.rwmu.RUnlock()
 
+	if len(mt.rangeDels) > 0 {
+		return nil, ErrUnsupportedRangeDel
+	}
+
 	sst, err := sstable.New(idxfile, segfile)
 
 	if err != nil {
@@ -109,8 +406,20 @@ func (mt *MemTable) Flush(idxfile, segfile *os.File) (*sstable.SSTable, error) {
 		key := []byte(it.Node().Key.(string))
 		val := it.Node().Value
 
-		if val == (Tombstone{}) {
+		if val == (Tombstone{}) || val == (singleDelete{}) {
 			tombstone = true
+		} else if _, ok := val.(expiringValue); ok {
+			return nil, ErrUnsupportedTTL
+		} else if m, ok := val.(*MergeOperands); ok {
+			if !m.HasBase {
+				return nil, ErrUnresolvedMerge
+			}
+
+			resolved, err := m.resolve(mt.op, key)
+			if err != nil {
+				return nil, err
+			}
+			value = resolved
 		} else {
 			tombstone = false
 			value = val.([]byte)
@@ -127,3 +436,56 @@ func (mt *MemTable) Flush(idxfile, segfile *os.File) (*sstable.SSTable, error) {
 
 	return sst, nil
 }
+
+// treeEntry converts a value stored in the tree to the entry flushed for
+// it.
+func (mt *MemTable) treeEntry(key string, val interface{}) (entry, error) {
+	e := entry{key: []byte(key)}
+
+	switch v := val.(type) {
+	case Tombstone:
+		e.t = sstable.TOMBSTONE
+	case singleDelete:
+		e.t = sstable.SINGLE_DELETE
+	case *MergeOperands:
+		value, t, err := v.entry(mt.op, e.key)
+		if err != nil {
+			return entry{}, err
+		}
+		e.value, e.t = value, t
+	case expiringValue:
+		// expired values are kept so that they keep shadowing older
+		// tables until compaction drops them.
+		e.value, e.t = sstable.EncodeExpiring(v.value, v.expireAt), sstable.EXPIRING
+	case []byte:
+		e.value = v
+	}
+
+	return e, nil
+}
+
+// NewIterator returns an iterator over a snapshot of the memtable's
+// entries in key order, typed like the entries FlushTo writes.
+func (mt *MemTable) NewIterator() sstable.EntryIterator {
+	mt.rwmu.RLock()
+	defer mt.rwmu.RUnlock()
+
+	itr := &sliceIterator{cmp: mt.cmp, entries: make([]entry, 0, mt.tree.Size())}
+	for it := mt.tree.Iterator(); it.Next(); {
+		e, err := mt.treeEntry(it.Key().(string), it.Value())
+		if err != nil {
+			itr.err = err
+			break
+		}
+		itr.entries = append(itr.entries, e)
+	}
+
+	return itr
+}
+
+// FlushTo adds every entry and range tombstone of the memtable to b in key
+// order and finishes the table. The builder is abandoned if any entry
+// cannot be added.
+func (mt *MemTable) FlushTo(b *sstable.Builder) error {
+	return flushTo(b, mt.NewIterator(), mt.RangeTombstones())
+}
@@ -1,10 +1,15 @@
 package memtable
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/sstable"
+	"github.com/sosomasox/LSM-Tree-based-Storage/status"
 )
 
 func TestMemTable(t *testing.T) {
@@ -20,10 +25,20 @@ func TestMemTable(t *testing.T) {
 	} {
 		fn := fn // https://github.com/golang/go/wiki/CommonMistakes
 		t.Run(scenario, func(t *testing.T) {
-			fn(t, mt)
+			// scenarios run in random map order, so each one gets its own
+			// memtable to avoid seeing another scenario's tombstones.
+			fn(t, New())
 		})
 	}
 
+	t.Run("FlushTo", func(t *testing.T) {
+		test_FlushTo(t, mt)
+	})
+
+	t.Run("FlushToErrors", func(t *testing.T) {
+		test_FlushToErrors(t, mt)
+	})
+
 	t.Run("Clear", func(t *testing.T) {
 		test_Clear(t, mt)
 	})
@@ -37,6 +52,55 @@ func test_Clear(t *testing.T, mt *MemTable) {
 	require.Equal(t, uint64(0), mt.size)
 }
 
+func test_FlushTo(t *testing.T, mt *MemTable) {
+	dir, err := os.MkdirTemp("", "test_memtable_flushto_")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "000001.sst")
+
+	mt.Put([]byte("flush"), []byte("FLUSH"))
+	mt.Del([]byte("flushed"))
+
+	b, err := sstable.NewBuilder(path, nil)
+	require.NoError(t, err)
+	require.NoError(t, mt.FlushTo(b))
+	require.Equal(t, uint64(mt.tree.Size()), b.NumEntries())
+
+	tbl, err := sstable.OpenTable(path, nil)
+	require.NoError(t, err)
+	defer tbl.Close()
+
+	{
+		value, err := tbl.Get([]byte("flush"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("FLUSH"), value)
+	}
+
+	{
+		value, err := tbl.Get([]byte("flushed"))
+		require.ErrorIs(t, err, sstable.ErrDeleted)
+		require.Nil(t, value)
+	}
+}
+
+func test_FlushToErrors(t *testing.T, mt *MemTable) {
+	dir, err := os.MkdirTemp("", "test_memtable_flushto_errors_")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	b, err := sstable.NewBuilder(filepath.Join(dir, "000001.sst"), nil)
+	require.NoError(t, err)
+
+	// the table cannot be renamed into place.
+	require.NoError(t, os.RemoveAll(dir))
+	require.ErrorIs(t, mt.FlushTo(b), status.ErrIO)
+
+	err = mt.FlushTo(b)
+	require.ErrorIs(t, err, sstable.ErrBuilderClosed)
+	require.ErrorIs(t, err, status.ErrClosed)
+}
+
 func test_Put(t *testing.T, mt *MemTable) {
 	mt.Put([]byte("test"), []byte("test"))
 	mt.Put([]byte("void"), []byte(""))
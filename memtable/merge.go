@@ -0,0 +1,102 @@
+package memtable
+
+import (
+	"errors"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/merge"
+	"github.com/sosomasox/LSM-Tree-based-Storage/sstable"
+)
+
+var (
+	ErrNoMergeOperator = errors.New("memtable: merge operands without a merge operator")
+	// the two-file SSTable format cannot hold merge operands whose base is
+	// not in the memtable.
+	ErrUnresolvedMerge = errors.New("memtable: unresolved merge operands")
+)
+
+// MergeOperands is stored for keys with pending merge operands. When the
+// entry the operands apply to is in the memtable it is kept as the base;
+// otherwise the base lives in an older table and the operands can only
+// be resolved by the store.
+type MergeOperands struct {
+	HasBase bool
+	Base    []byte
+	// the base is a deletion.
+	Tombstone bool
+	// operands, oldest first.
+	Operands [][]byte
+}
+
+// size returns the bytes held by the base and the operands.
+func (m *MergeOperands) size() uint64 {
+	n := uint64(len(m.Base))
+	for _, op := range m.Operands {
+		n += uint64(len(op))
+	}
+
+	return n
+}
+
+// resolve applies the operands to the base. It must only be called when
+// the base is known.
+func (m *MergeOperands) resolve(op merge.Operator, key []byte) ([]byte, error) {
+	if op == nil {
+		return nil, ErrNoMergeOperator
+	}
+
+	var base []byte
+	if !m.Tombstone {
+		base = m.Base
+	}
+
+	return op.FullMerge(key, base, m.Operands)
+}
+
+// lookup converts m to the result of MemTableRep.Lookup.
+func (m *MergeOperands) lookup() (value []byte, operands [][]byte, t sstable.TombstoneType, found bool) {
+	switch {
+	case !m.HasBase:
+		return []byte(""), m.Operands, sstable.MERGE, true
+	case m.Tombstone:
+		return []byte(""), m.Operands, sstable.TOMBSTONE, true
+	default:
+		return m.Base, m.Operands, sstable.NO_TOMBSTONE, true
+	}
+}
+
+// addOperand returns the entry resulting from merging operand into the
+// current entry for key, described by value/tombstone/cur. operand must
+// already be owned by the memtable.
+func addOperand(op merge.Operator, key []byte, found bool, value []byte, tombstone bool, cur *MergeOperands, operand []byte) *MergeOperands {
+	switch {
+	case !found:
+		return &MergeOperands{Operands: [][]byte{operand}}
+	case cur != nil:
+		operands := append(append([][]byte(nil), cur.Operands...), operand)
+		return &MergeOperands{
+			HasBase:   cur.HasBase,
+			Base:      cur.Base,
+			Tombstone: cur.Tombstone,
+			Operands:  merge.Collapse(op, key, operands),
+		}
+	case tombstone:
+		return &MergeOperands{HasBase: true, Tombstone: true, Operands: [][]byte{operand}}
+	default:
+		return &MergeOperands{HasBase: true, Base: value, Operands: [][]byte{operand}}
+	}
+}
+
+// entry returns the entry flushed for m, resolving it when its base is in
+// the memtable and encoding the operands as a MERGE entry otherwise.
+func (m *MergeOperands) entry(op merge.Operator, key []byte) (value []byte, t sstable.TombstoneType, err error) {
+	if !m.HasBase {
+		return merge.EncodeOperands(m.Operands), sstable.MERGE, nil
+	}
+
+	value, err = m.resolve(op, key)
+	if err != nil {
+		return nil, sstable.NO_TOMBSTONE, err
+	}
+
+	return value, sstable.NO_TOMBSTONE, nil
+}
@@ -0,0 +1,98 @@
+package memtable
+
+import (
+	"time"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/clock"
+	"github.com/sosomasox/LSM-Tree-based-Storage/comparator"
+	"github.com/sosomasox/LSM-Tree-based-Storage/merge"
+	"github.com/sosomasox/LSM-Tree-based-Storage/sstable"
+)
+
+type RepType uint8
+
+const (
+	// red-black tree guarded by a read/write lock.
+	TREE_REP RepType = iota
+	// concurrent skiplist with lock-free reads and a single writer.
+	SKIPLIST_REP
+	// skiplist copying keys and values into an Arena.
+	ARENA_SKIPLIST_REP
+)
+
+// MemTableRep is the in-memory representation of a memtable. All
+// implementations keep entries sorted by key so they can be flushed
+// straight into an sstable.Builder.
+type MemTableRep interface {
+	Put(key, value []byte)
+	PutWithExpiry(key, value []byte, expireAt time.Time)
+	Get(key []byte) (value []byte, found, tombstone bool)
+	Del(key []byte)
+	SingleDelete(key []byte)
+	DeleteRange(start, end []byte)
+	RangeTombstones() []sstable.RangeTombstone
+	Merge(key, operand []byte)
+	Lookup(key []byte) (value []byte, operands [][]byte, t sstable.TombstoneType, found bool)
+	Size() uint64
+	MemoryUsage() MemoryUsage
+	Clear()
+	NewIterator() sstable.EntryIterator
+	FlushTo(b *sstable.Builder) error
+}
+
+var (
+	_ MemTableRep = (*MemTable)(nil)
+	_ MemTableRep = (*SkipList)(nil)
+)
+
+type Options struct {
+	Rep RepType
+	// order of keys; defaults to comparator.Bytewise.
+	Comparator comparator.Comparator
+	// chunk size of the arena used by ARENA_SKIPLIST_REP.
+	ArenaChunkSize int
+	// resolves merge operands whose base is in the memtable.
+	MergeOperator merge.Operator
+	// tells whether expiring values have expired; defaults to
+	// clock.System.
+	Clock clock.Clock
+}
+
+func DefaultOptions() *Options {
+	return &Options{
+		Rep:            TREE_REP,
+		Comparator:     comparator.Bytewise,
+		ArenaChunkSize: DEFAULT_ARENA_CHUNK_SIZE,
+	}
+}
+
+func NewRep(t RepType) MemTableRep {
+	opts := DefaultOptions()
+	opts.Rep = t
+
+	return NewRepWithOptions(opts)
+}
+
+func NewRepWithOptions(opts *Options) MemTableRep {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+
+	switch opts.Rep {
+	case SKIPLIST_REP:
+		sl := newSkipList(nil, opts.Comparator)
+		sl.op = opts.MergeOperator
+		sl.clock = clock.OrDefault(opts.Clock)
+		return sl
+	case ARENA_SKIPLIST_REP:
+		sl := newSkipList(NewArena(opts.ArenaChunkSize), opts.Comparator)
+		sl.op = opts.MergeOperator
+		sl.clock = clock.OrDefault(opts.Clock)
+		return sl
+	default:
+		mt := NewWithComparator(opts.Comparator)
+		mt.op = opts.MergeOperator
+		mt.clock = clock.OrDefault(opts.Clock)
+		return mt
+	}
+}
@@ -0,0 +1,508 @@
+package memtable
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/clock"
+	"github.com/sosomasox/LSM-Tree-based-Storage/comparator"
+	"github.com/sosomasox/LSM-Tree-based-Storage/merge"
+	"github.com/sosomasox/LSM-Tree-based-Storage/sstable"
+)
+
+var reps = map[string]RepType{
+	"Tree":          TREE_REP,
+	"SkipList":      SKIPLIST_REP,
+	"ArenaSkipList": ARENA_SKIPLIST_REP,
+}
+
+func TestMemTableRep(t *testing.T) {
+	for name, rep := range reps {
+		rep := rep
+		t.Run(name, func(t *testing.T) {
+			test_rep_FlushTo(t, NewRep(rep))
+		})
+
+		t.Run(name+"/Merge", func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.Rep = rep
+			opts.MergeOperator = merge.Uint64Add{}
+
+			test_rep_Merge(t, NewRepWithOptions(opts))
+		})
+
+		t.Run(name+"/Expiry", func(t *testing.T) {
+			c := clock.NewManual(time.Unix(1000, 0))
+
+			opts := DefaultOptions()
+			opts.Rep = rep
+			opts.Clock = c
+			opts.MergeOperator = merge.Uint64Add{}
+
+			test_rep_Expiry(t, NewRepWithOptions(opts), c)
+		})
+
+		t.Run(name+"/DeleteRange", func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.Rep = rep
+			opts.MergeOperator = merge.Uint64Add{}
+
+			test_rep_DeleteRange(t, NewRepWithOptions(opts))
+		})
+
+		t.Run(name+"/SingleDelete", func(t *testing.T) {
+			test_rep_SingleDelete(t, NewRep(rep))
+		})
+
+		t.Run(name+"/Comparator", func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.Rep = rep
+			opts.Comparator = comparator.ReverseBytewise
+
+			test_rep_Comparator(t, NewRepWithOptions(opts))
+		})
+	}
+}
+
+func test_rep_Comparator(t *testing.T, mt MemTableRep) {
+	dir, err := os.MkdirTemp("", "test_memtable_rep_comparator_")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "000001.sst")
+
+	mt.Put([]byte("a"), []byte("A"))
+	mt.Put([]byte("c"), []byte("C"))
+	mt.Put([]byte("b"), []byte("B"))
+
+	value, found, _ := mt.Get([]byte("b"))
+	require.Equal(t, true, found)
+	require.Equal(t, []byte("B"), value)
+
+	// a bytewise builder rejects the reverse order.
+	{
+		b, err := sstable.NewBuilder(path, nil)
+		require.NoError(t, err)
+		require.ErrorIs(t, mt.FlushTo(b), sstable.ErrOutOfOrder)
+	}
+
+	opts := sstable.DefaultOptions()
+	opts.Comparator = comparator.ReverseBytewise
+
+	b, err := sstable.NewBuilder(path, opts)
+	require.NoError(t, err)
+	require.NoError(t, mt.FlushTo(b))
+
+	tbl, err := sstable.OpenTable(path, opts)
+	require.NoError(t, err)
+	defer tbl.Close()
+
+	var keys []string
+	for itr := tbl.NewIterator(nil); itr.HasNext(); {
+		key, _, _, err := itr.Next()
+		require.NoError(t, err)
+		keys = append(keys, string(key))
+	}
+	require.Equal(t, []string{"c", "b", "a"}, keys)
+}
+
+func test_rep_Merge(t *testing.T, mt MemTableRep) {
+	dir, err := os.MkdirTemp("", "test_memtable_rep_merge_")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "000001.sst")
+
+	// base in the memtable.
+	mt.Put([]byte("a"), []byte("1"))
+	mt.Merge([]byte("a"), []byte("2"))
+	mt.Merge([]byte("a"), []byte("3"))
+
+	// base deleted in the memtable.
+	mt.Del([]byte("b"))
+	mt.Merge([]byte("b"), []byte("5"))
+
+	// base in an older table.
+	mt.Merge([]byte("c"), []byte("1"))
+	mt.Merge([]byte("c"), []byte("1"))
+
+	{
+		value, found, _ := mt.Get([]byte("a"))
+		require.Equal(t, true, found)
+		require.Equal(t, []byte("6"), value)
+	}
+
+	{
+		value, found, _ := mt.Get([]byte("b"))
+		require.Equal(t, true, found)
+		require.Equal(t, []byte("5"), value)
+	}
+
+	{
+		_, found, _ := mt.Get([]byte("c"))
+		require.Equal(t, false, found)
+
+		// the partial merge collapses the operands.
+		_, operands, ty, found := mt.Lookup([]byte("c"))
+		require.Equal(t, true, found)
+		require.Equal(t, sstable.MERGE, ty)
+		require.Equal(t, [][]byte{[]byte("2")}, operands)
+	}
+
+	b, err := sstable.NewBuilder(path, nil)
+	require.NoError(t, err)
+	require.NoError(t, mt.FlushTo(b))
+
+	tbl, err := sstable.OpenTable(path, nil)
+	require.NoError(t, err)
+	defer tbl.Close()
+
+	for _, expected := range []struct {
+		key, value string
+		t          sstable.TombstoneType
+	}{
+		{"a", "6", sstable.NO_TOMBSTONE},
+		{"b", "5", sstable.NO_TOMBSTONE},
+		{"c", string(merge.EncodeOperands([][]byte{[]byte("2")})), sstable.MERGE},
+	} {
+		value, ty, err := tbl.GetEntry([]byte(expected.key), nil)
+		require.NoError(t, err)
+		require.Equal(t, expected.t, ty)
+		require.Equal(t, []byte(expected.value), value)
+	}
+}
+
+func test_rep_Expiry(t *testing.T, mt MemTableRep, c *clock.Manual) {
+	dir, err := os.MkdirTemp("", "test_memtable_rep_expiry_")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "000001.sst")
+	expireAt := c.Now().Add(time.Minute)
+
+	mt.PutWithExpiry([]byte("a"), []byte("A"), expireAt)
+	mt.PutWithExpiry([]byte("b"), []byte("1"), expireAt)
+
+	{
+		value, found, tombstone := mt.Get([]byte("a"))
+		require.Equal(t, []byte("A"), value)
+		require.Equal(t, true, found)
+		require.Equal(t, false, tombstone)
+	}
+
+	c.Advance(time.Minute)
+
+	{
+		value, found, tombstone := mt.Get([]byte("a"))
+		require.Equal(t, []byte(""), value)
+		require.Equal(t, false, found)
+		require.Equal(t, true, tombstone)
+
+		_, _, ty, found := mt.Lookup([]byte("a"))
+		require.Equal(t, true, found)
+		require.Equal(t, sstable.TOMBSTONE, ty)
+	}
+
+	// an expired base reads as deleted.
+	mt.Merge([]byte("b"), []byte("2"))
+	{
+		value, found, _ := mt.Get([]byte("b"))
+		require.Equal(t, true, found)
+		require.Equal(t, []byte("2"), value)
+	}
+
+	b, err := sstable.NewBuilder(path, nil)
+	require.NoError(t, err)
+	require.NoError(t, mt.FlushTo(b))
+
+	tbl, err := sstable.OpenTable(path, nil)
+	require.NoError(t, err)
+	defer tbl.Close()
+
+	// the expired entry keeps its expiration time so that it still
+	// shadows older tables.
+	value, ty, err := tbl.GetEntry([]byte("a"), nil)
+	require.NoError(t, err)
+	require.Equal(t, sstable.EXPIRING, ty)
+	require.Equal(t, sstable.EncodeExpiring([]byte("A"), expireAt), value)
+}
+
+func test_rep_DeleteRange(t *testing.T, mt MemTableRep) {
+	dir, err := os.MkdirTemp("", "test_memtable_rep_delete_range_")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "000001.sst")
+
+	mt.Put([]byte("a"), []byte("A"))
+	mt.Put([]byte("b"), []byte("B"))
+	mt.Put([]byte("c"), []byte("C"))
+	mt.DeleteRange([]byte("b"), []byte("d"))
+	// empty ranges are ignored.
+	mt.DeleteRange([]byte("d"), []byte("b"))
+
+	// written after the range tombstone.
+	mt.Put([]byte("c"), []byte("CC"))
+	mt.Merge([]byte("bb"), []byte("1"))
+
+	for _, expected := range []struct {
+		key, value string
+		found      bool
+		tombstone  bool
+	}{
+		{"a", "A", true, false},
+		{"b", "", false, true},
+		{"bb", "1", true, false},
+		{"c", "CC", true, false},
+		// not in the memtable, but covered.
+		{"cc", "", false, true},
+		{"d", "", false, false},
+	} {
+		value, found, tombstone := mt.Get([]byte(expected.key))
+		require.Equal(t, []byte(expected.value), value, expected.key)
+		require.Equal(t, expected.found, found, expected.key)
+		require.Equal(t, expected.tombstone, tombstone, expected.key)
+	}
+
+	{
+		_, _, ty, found := mt.Lookup([]byte("cc"))
+		require.Equal(t, true, found)
+		require.Equal(t, sstable.TOMBSTONE, ty)
+	}
+
+	require.Equal(t, uint64(1), mt.MemoryUsage().RangeTombstones)
+	require.Equal(t, []sstable.RangeTombstone{
+		{Start: []byte("b"), End: []byte("d")},
+	}, mt.RangeTombstones())
+
+	b, err := sstable.NewBuilder(path, nil)
+	require.NoError(t, err)
+	require.NoError(t, mt.FlushTo(b))
+
+	tbl, err := sstable.OpenTable(path, nil)
+	require.NoError(t, err)
+	defer tbl.Close()
+
+	require.Equal(t, mt.RangeTombstones(), tbl.RangeTombstones())
+
+	var keys []string
+	for itr := tbl.NewIterator(nil); itr.HasNext(); {
+		key, _, tombstone, err := itr.Next()
+		require.NoError(t, err)
+		if !tombstone {
+			keys = append(keys, string(key))
+		}
+	}
+	require.Equal(t, []string{"a", "bb", "c"}, keys)
+
+	mt.Clear()
+	require.Equal(t, 0, len(mt.RangeTombstones()))
+
+	_, _, tombstone := mt.Get([]byte("cc"))
+	require.Equal(t, false, tombstone)
+}
+
+func test_rep_FlushTo(t *testing.T, mt MemTableRep) {
+	dir, err := os.MkdirTemp("", "test_memtable_rep_")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "000001.sst")
+
+	mt.Put([]byte("c"), []byte("C"))
+	mt.Put([]byte("a"), []byte("A"))
+	mt.Del([]byte("b"))
+
+	{
+		overhead := TREE_NODE_OVERHEAD
+		arena := uint64(0)
+		if sl, ok := mt.(*SkipList); ok {
+			overhead = SKIPLIST_NODE_OVERHEAD
+			if sl.arena != nil {
+				arena = uint64(DEFAULT_ARENA_CHUNK_SIZE)
+			}
+		}
+
+		usage := mt.MemoryUsage()
+		require.Equal(t, MemoryUsage{
+			Keys:       uint64(len("c") + len("a") + len("b")),
+			Values:     uint64(len("C") + len("A")),
+			Entries:    3,
+			Tombstones: 1,
+			Overhead:   3 * overhead,
+			Arena:      arena,
+		}, usage)
+		require.Equal(t, usage.Total(), mt.Size())
+	}
+
+	// overwriting with a shorter value or a tombstone must never wrap.
+	mt.Put([]byte("c"), []byte(""))
+	mt.Del([]byte("a"))
+	mt.Put([]byte("b"), []byte("B"))
+	{
+		usage := mt.MemoryUsage()
+		require.Equal(t, uint64(len("B")), usage.Values)
+		require.Equal(t, uint64(1), usage.Tombstones)
+		require.Equal(t, uint64(3), usage.Entries)
+		require.Less(t, mt.Size(), uint64(1<<32))
+	}
+	mt.Put([]byte("c"), []byte("C"))
+	mt.Put([]byte("a"), []byte("A"))
+	mt.Del([]byte("b"))
+
+	b, err := sstable.NewBuilder(path, nil)
+	require.NoError(t, err)
+	require.NoError(t, mt.FlushTo(b))
+	require.Equal(t, uint64(3), b.NumEntries())
+
+	tbl, err := sstable.OpenTable(path, nil)
+	require.NoError(t, err)
+	defer tbl.Close()
+
+	itr := tbl.NewIterator(nil)
+	for _, expected := range []struct {
+		key, value string
+		tombstone  bool
+	}{
+		{"a", "A", false},
+		{"b", "", true},
+		{"c", "C", false},
+	} {
+		require.Equal(t, true, itr.HasNext())
+
+		key, value, tombstone, err := itr.Next()
+		require.NoError(t, err)
+		require.Equal(t, []byte(expected.key), key)
+		require.Equal(t, []byte(expected.value), value)
+		require.Equal(t, expected.tombstone, tombstone)
+	}
+	require.Equal(t, false, itr.HasNext())
+
+	mt.Clear()
+	require.Equal(t, uint64(0), mt.Size())
+	require.Equal(t, MemoryUsage{}, mt.MemoryUsage())
+}
+
+func BenchmarkMemTableRep(b *testing.B) {
+	keys := make([][]byte, 10000)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key%08d", (i*7919)%len(keys)))
+	}
+	value := []byte("value")
+
+	for name, rep := range reps {
+		rep := rep
+
+		b.Run(name+"/Put", func(b *testing.B) {
+			mt := NewRep(rep)
+
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				mt.Put(keys[i%len(keys)], value)
+			}
+		})
+
+		b.Run(name+"/Get", func(b *testing.B) {
+			mt := NewRep(rep)
+			for _, key := range keys {
+				mt.Put(key, value)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					mt.Get(keys[i%len(keys)])
+					i += 1
+				}
+			})
+		})
+
+		// readers racing a single writer, the case the skiplist targets.
+		b.Run(name+"/GetWhilePut", func(b *testing.B) {
+			mt := NewRep(rep)
+			for _, key := range keys {
+				mt.Put(key, value)
+			}
+
+			done := make(chan struct{})
+			go func() {
+				for i := 0; ; i++ {
+					select {
+					case <-done:
+						return
+					default:
+						mt.Put(keys[i%len(keys)], value)
+					}
+				}
+			}()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					mt.Get(keys[i%len(keys)])
+					i += 1
+				}
+			})
+			b.StopTimer()
+			close(done)
+		})
+	}
+}
+
+func test_rep_SingleDelete(t *testing.T, mt MemTableRep) {
+	dir, err := os.MkdirTemp("", "test_memtable_rep_single_delete_")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "000001.sst")
+
+	// cancels the value written in the memtable.
+	mt.Put([]byte("a"), []byte("A"))
+	mt.SingleDelete([]byte("a"))
+	// the value is in an older table.
+	mt.SingleDelete([]byte("b"))
+	mt.Put([]byte("c"), []byte("C"))
+	mt.Del([]byte("c"))
+
+	for _, expected := range []struct {
+		key       string
+		found     bool
+		tombstone bool
+	}{
+		{"a", false, false},
+		{"b", false, true},
+		{"c", false, true},
+	} {
+		_, found, tombstone := mt.Get([]byte(expected.key))
+		require.Equal(t, expected.found, found, expected.key)
+		require.Equal(t, expected.tombstone, tombstone, expected.key)
+	}
+
+	b, err := sstable.NewBuilder(path, nil)
+	require.NoError(t, err)
+	require.NoError(t, mt.FlushTo(b))
+
+	tbl, err := sstable.OpenTable(path, nil)
+	require.NoError(t, err)
+	defer tbl.Close()
+
+	var keys []string
+	var types []sstable.TombstoneType
+	for itr := tbl.NewIterator(nil); itr.HasNext(); {
+		key, _, ty, err := itr.NextEntry()
+		require.NoError(t, err)
+		keys = append(keys, string(key))
+		types = append(types, ty)
+	}
+	require.Equal(t, []string{"b", "c"}, keys)
+	require.Equal(t, []sstable.TombstoneType{sstable.SINGLE_DELETE, sstable.TOMBSTONE}, types)
+}
@@ -0,0 +1,488 @@
+package memtable
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/clock"
+	"github.com/sosomasox/LSM-Tree-based-Storage/comparator"
+	"github.com/sosomasox/LSM-Tree-based-Storage/merge"
+	"github.com/sosomasox/LSM-Tree-based-Storage/sstable"
+)
+
+const (
+	SKIPLIST_MAX_HEIGHT int = 12
+	SKIPLIST_BRANCHING  int = 4
+)
+
+const (
+	// estimated bytes used by a skiplist node besides its key and value
+	// bytes: the node struct, its average tower of next pointers and the
+	// boxed value.
+	SKIPLIST_NODE_OVERHEAD uint64 = 112 // Byte
+)
+
+type skipValue struct {
+	value     []byte
+	tombstone bool
+	// pending merge operands, if any.
+	merge *MergeOperands
+	// zero unless written with PutWithExpiry.
+	expireAt time.Time
+	// the tombstone was written by SingleDelete.
+	single bool
+	// a SingleDelete cancelled the value; the key reads as absent.
+	absent bool
+}
+
+// size returns the value bytes held by v.
+func (v *skipValue) size() uint64 {
+	if v.merge != nil {
+		return v.merge.size()
+	}
+
+	return uint64(len(v.value))
+}
+
+type skipNode struct {
+	key   []byte
+	value atomic.Pointer[skipValue]
+	next  []atomic.Pointer[skipNode]
+}
+
+func newSkipNode(key []byte, height int) *skipNode {
+	return &skipNode{
+		key:  key,
+		next: make([]atomic.Pointer[skipNode], height),
+	}
+}
+
+// SkipList is a memtable backed by a concurrent skiplist. Writers are
+// serialized by a mutex while readers never block: nodes are fully built
+// before being published with atomic stores, and a node's value is
+// replaced atomically on overwrite.
+//
+// When created with an arena, keys and values are copied into the arena,
+// Size reports the arena's reservation plus node overhead and Clear
+// releases the arena all at once.
+type SkipList struct {
+	// serializes writers; readers do not take it.
+	wmu    sync.Mutex
+	head   *skipNode
+	height atomic.Int32
+	size   atomic.Uint64
+	// guarded by wmu.
+	usage MemoryUsage
+	rnd   *rand.Rand
+	// optional arena owning copies of keys and values.
+	arena *Arena
+	cmp   comparator.Comparator
+	// resolves merge operands whose base is in the skiplist.
+	op merge.Operator
+	// tells whether expiring values have expired.
+	clock clock.Clock
+	// range tombstones, covering entries older than the skiplist. The
+	// slice is replaced, never modified, so readers can load it without
+	// locking.
+	rangeDels atomic.Pointer[[]sstable.RangeTombstone]
+}
+
+func NewSkipList() *SkipList {
+	return newSkipList(nil, comparator.Bytewise)
+}
+
+func NewSkipListWithArena(arena *Arena) *SkipList {
+	return newSkipList(arena, comparator.Bytewise)
+}
+
+func newSkipList(arena *Arena, cmp comparator.Comparator) *SkipList {
+	sl := &SkipList{
+		head:  newSkipNode(nil, SKIPLIST_MAX_HEIGHT),
+		rnd:   rand.New(rand.NewSource(0xdeadbeef)),
+		arena: arena,
+		cmp:   comparator.OrDefault(cmp),
+		clock: clock.System,
+	}
+	sl.height.Store(1)
+
+	return sl
+}
+
+func (sl *SkipList) randomHeight() int {
+	h := 1
+	for h < SKIPLIST_MAX_HEIGHT && sl.rnd.Intn(SKIPLIST_BRANCHING) == 0 {
+		h += 1
+	}
+
+	return h
+}
+
+// findGreaterOrEqual returns the first node whose key is >= key. When prev
+// is non-nil it is filled with the rightmost node before key at every
+// level.
+func (sl *SkipList) findGreaterOrEqual(key []byte, prev []*skipNode) *skipNode {
+	x := sl.head
+	level := int(sl.height.Load()) - 1
+
+	for {
+		next := x.next[level].Load()
+		if next != nil && sl.cmp.Compare(next.key, key) < 0 {
+			x = next
+			continue
+		}
+
+		if prev != nil {
+			prev[level] = x
+		}
+
+		if level == 0 {
+			return next
+		}
+		level -= 1
+	}
+}
+
+// copyBytes returns a copy of b owned by the skiplist.
+func (sl *SkipList) copyBytes(b []byte) []byte {
+	if sl.arena != nil {
+		return sl.arena.Copy(b)
+	}
+
+	return append([]byte(nil), b...)
+}
+
+// set stores the value returned by fn under key. fn is called with wmu
+// held and the current value, or nil if key is absent.
+func (sl *SkipList) set(key []byte, fn func(old *skipValue) *skipValue) {
+	sl.wmu.Lock()
+	defer sl.wmu.Unlock()
+
+	var prev [SKIPLIST_MAX_HEIGHT]*skipNode
+	x := sl.findGreaterOrEqual(key, prev[:])
+
+	if x != nil && sl.cmp.Compare(x.key, key) == 0 {
+		old := x.value.Load()
+		v := fn(old)
+		sl.account(key, old, v, true)
+		x.value.Store(v)
+		return
+	}
+
+	v := fn(nil)
+
+	height := sl.randomHeight()
+	if cur := int(sl.height.Load()); height > cur {
+		for i := cur; i < height; i++ {
+			prev[i] = sl.head
+		}
+		sl.height.Store(int32(height))
+	}
+
+	key = sl.copyBytes(key)
+
+	x = newSkipNode(key, height)
+	x.value.Store(v)
+
+	for i := 0; i < height; i++ {
+		x.next[i].Store(prev[i].next[i].Load())
+		prev[i].next[i].Store(x)
+	}
+
+	sl.account(key, nil, v, false)
+}
+
+// account must be called with wmu held.
+func (sl *SkipList) account(key []byte, old, v *skipValue, found bool) {
+	var oldSize uint64
+	var oldTombstone bool
+
+	if old != nil {
+		oldSize = old.size()
+		oldTombstone = old.tombstone
+	}
+
+	sl.usage.put(key, found, oldTombstone, oldSize, v.size(), v.tombstone, SKIPLIST_NODE_OVERHEAD)
+
+	if sl.arena != nil {
+		sl.usage.Arena = sl.arena.Size()
+	}
+
+	sl.size.Store(sl.usage.Total())
+}
+
+func (sl *SkipList) Put(key, value []byte) {
+	sl.set(key, func(*skipValue) *skipValue {
+		if sl.arena != nil {
+			value = sl.arena.Copy(value)
+		}
+
+		return &skipValue{value: value}
+	})
+}
+
+// PutWithExpiry stores value under key until expireAt, after which the key
+// reads as deleted.
+func (sl *SkipList) PutWithExpiry(key, value []byte, expireAt time.Time) {
+	sl.set(key, func(*skipValue) *skipValue {
+		if sl.arena != nil {
+			value = sl.arena.Copy(value)
+		}
+
+		return &skipValue{value: value, expireAt: expireAt}
+	})
+}
+
+// deleted reports whether v reads as a tombstone, either because it is
+// one or because it has expired.
+func (sl *SkipList) deleted(v *skipValue) bool {
+	if v.tombstone {
+		return true
+	}
+
+	return !v.expireAt.IsZero() && sstable.Expired(v.expireAt, sl.clock.Now())
+}
+
+// SingleDelete deletes a key that was written exactly once. When its value
+// is in the skiplist both vanish; otherwise a single-delete tombstone is
+// kept, which compaction drops together with the value. The result of
+// reads is undefined if the key was written more than once, merged, or
+// mixed with Del.
+func (sl *SkipList) SingleDelete(key []byte) {
+	sl.set(key, func(old *skipValue) *skipValue {
+		if old != nil && !old.tombstone && old.merge == nil && !old.absent {
+			return &skipValue{absent: true}
+		}
+
+		return &skipValue{tombstone: true, single: true}
+	})
+}
+
+func (sl *SkipList) Del(key []byte) {
+	sl.set(key, func(*skipValue) *skipValue {
+		return &skipValue{tombstone: true}
+	})
+}
+
+// Merge records a merge operand for key. Operands applying to a value or
+// tombstone held by the skiplist are kept with it and resolved on flush.
+func (sl *SkipList) Merge(key, operand []byte) {
+	sl.set(key, func(old *skipValue) *skipValue {
+		operand := sl.copyBytes(operand)
+
+		if old == nil || old.absent {
+			if sl.covered(key) {
+				return &skipValue{merge: addOperand(sl.op, key, true, nil, true, nil, operand)}
+			}
+			return &skipValue{merge: addOperand(sl.op, key, false, nil, false, nil, operand)}
+		}
+
+		if sl.deleted(old) {
+			return &skipValue{merge: addOperand(sl.op, key, true, nil, true, old.merge, operand)}
+		}
+
+		return &skipValue{merge: addOperand(sl.op, key, true, old.value, false, old.merge, operand)}
+	})
+}
+
+func (sl *SkipList) find(key []byte) *skipValue {
+	x := sl.findGreaterOrEqual(key, nil)
+
+	if x == nil || sl.cmp.Compare(x.key, key) != 0 {
+		return nil
+	}
+
+	if v := x.value.Load(); !v.absent {
+		return v
+	}
+
+	return nil
+}
+
+// Get returns the value of key. Expired values read as tombstones. Keys
+// with merge operands are reported as found only when the operands can be
+// resolved within the skiplist; use Lookup to get the raw operands.
+func (sl *SkipList) Get(key []byte) (value []byte, found, tombstone bool) {
+	v := sl.find(key)
+
+	switch {
+	case v == nil:
+		if sl.covered(key) {
+			return []byte(""), false, true
+		}
+		return []byte(""), false, false
+	case sl.deleted(v):
+		return []byte(""), false, true
+	case v.merge != nil:
+		if !v.merge.HasBase {
+			return []byte(""), false, false
+		}
+
+		value, err := v.merge.resolve(sl.op, key)
+		if err != nil {
+			return []byte(""), false, false
+		}
+
+		return value, true, false
+	}
+
+	return v.value, true, false
+}
+
+// Lookup returns the raw entry for key. operands holds pending merge
+// operands, oldest first, to apply on top of the value or tombstone; t is
+// MERGE when their base is not in the skiplist.
+func (sl *SkipList) Lookup(key []byte) (value []byte, operands [][]byte, t sstable.TombstoneType, found bool) {
+	v := sl.find(key)
+
+	switch {
+	case v == nil:
+		if sl.covered(key) {
+			return []byte(""), nil, sstable.TOMBSTONE, true
+		}
+		return []byte(""), nil, sstable.NO_TOMBSTONE, false
+	case v.merge != nil:
+		return v.merge.lookup()
+	case sl.deleted(v):
+		return []byte(""), nil, sstable.TOMBSTONE, true
+	}
+
+	return v.value, nil, sstable.NO_TOMBSTONE, true
+}
+
+// covered reports whether a range tombstone of the skiplist covers key.
+func (sl *SkipList) covered(key []byte) bool {
+	rangeDels := sl.rangeDels.Load()
+	if rangeDels == nil {
+		return false
+	}
+
+	return sstable.Covers(sl.cmp, *rangeDels, key)
+}
+
+// DeleteRange deletes every key in [start, end). Keys of the skiplist in
+// the range become tombstones, while older keys are covered by a range
+// tombstone flushed along with the skiplist. Empty ranges are ignored.
+func (sl *SkipList) DeleteRange(start, end []byte) {
+	sl.wmu.Lock()
+	defer sl.wmu.Unlock()
+
+	if sl.cmp.Compare(start, end) >= 0 {
+		return
+	}
+
+	for x := sl.findGreaterOrEqual(start, nil); x != nil && sl.cmp.Compare(x.key, end) < 0; x = x.next[0].Load() {
+		old := x.value.Load()
+		v := &skipValue{tombstone: true}
+		sl.account(x.key, old, v, true)
+		x.value.Store(v)
+	}
+
+	var rangeDels []sstable.RangeTombstone
+	if cur := sl.rangeDels.Load(); cur != nil {
+		rangeDels = append(rangeDels, *cur...)
+	}
+	rangeDels = append(rangeDels, sstable.RangeTombstone{
+		Start: sl.copyBytes(start),
+		End:   sl.copyBytes(end),
+	})
+	sl.rangeDels.Store(&rangeDels)
+
+	sl.usage.RangeTombstones += 1
+	sl.usage.Keys += uint64(len(start) + len(end))
+	if sl.arena != nil {
+		sl.usage.Arena = sl.arena.Size()
+	}
+	sl.size.Store(sl.usage.Total())
+}
+
+// RangeTombstones returns the range tombstones of the skiplist.
+func (sl *SkipList) RangeTombstones() []sstable.RangeTombstone {
+	rangeDels := sl.rangeDels.Load()
+	if rangeDels == nil {
+		return nil
+	}
+
+	return *rangeDels
+}
+
+// Size returns the bytes accounted to the skiplist, including tombstones
+// and per-node overhead. With an arena, keys and values are accounted by
+// the arena's reservation.
+func (sl *SkipList) Size() uint64 {
+	return sl.size.Load()
+}
+
+func (sl *SkipList) MemoryUsage() MemoryUsage {
+	sl.wmu.Lock()
+	defer sl.wmu.Unlock()
+
+	return sl.usage
+}
+
+func (sl *SkipList) Clear() {
+	sl.wmu.Lock()
+	defer sl.wmu.Unlock()
+
+	for i := range sl.head.next {
+		sl.head.next[i].Store(nil)
+	}
+	sl.height.Store(1)
+	sl.rangeDels.Store(nil)
+	sl.size.Store(0)
+	sl.usage = MemoryUsage{}
+
+	if sl.arena != nil {
+		sl.arena.Reset()
+	}
+}
+
+// Len returns the number of keys, including tombstones.
+func (sl *SkipList) Len() int {
+	n := 0
+	for x := sl.head.next[0].Load(); x != nil; x = x.next[0].Load() {
+		n += 1
+	}
+
+	return n
+}
+
+// entry converts a skiplist value to the entry flushed for it.
+func (sl *SkipList) entry(key []byte, v *skipValue) (entry, error) {
+	e := entry{key: key}
+
+	switch {
+	case v.merge != nil:
+		value, t, err := v.merge.entry(sl.op, key)
+		if err != nil {
+			return entry{}, err
+		}
+		e.value, e.t = value, t
+	case v.tombstone && v.single:
+		e.t = sstable.SINGLE_DELETE
+	case v.tombstone:
+		e.t = sstable.TOMBSTONE
+	case !v.expireAt.IsZero():
+		// expired values are kept so that they keep shadowing older
+		// tables until compaction drops them.
+		e.value, e.t = sstable.EncodeExpiring(v.value, v.expireAt), sstable.EXPIRING
+	default:
+		e.value = v.value
+	}
+
+	return e, nil
+}
+
+// NewIterator returns an iterator over the entries of the skiplist in key
+// order, typed like the entries FlushTo writes.
+func (sl *SkipList) NewIterator() sstable.EntryIterator {
+	return &skipListIterator{sl: sl, x: sl.head.next[0].Load()}
+}
+
+// FlushTo adds every entry and range tombstone of the skiplist to b in key
+// order and finishes the table. The builder is abandoned if any entry
+// cannot be added.
+func (sl *SkipList) FlushTo(b *sstable.Builder) error {
+	return flushTo(b, sl.NewIterator(), sl.RangeTombstones())
+}
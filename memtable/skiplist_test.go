@@ -0,0 +1,122 @@
+package memtable
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSkipList(t *testing.T) {
+	for scenario, fn := range map[string]func(
+		t *testing.T, sl *SkipList,
+	){
+		"Put/Get/Del": test_skiplist_PutGetDel,
+		"Order":       test_skiplist_Order,
+		"Clear":       test_skiplist_Clear,
+		"Concurrent":  test_skiplist_Concurrent,
+	} {
+		fn := fn // https://github.com/golang/go/wiki/CommonMistakes
+		t.Run(scenario, func(t *testing.T) {
+			fn(t, NewSkipList())
+		})
+	}
+}
+
+func test_skiplist_PutGetDel(t *testing.T, sl *SkipList) {
+	sl.Put([]byte("test"), []byte("test"))
+
+	{
+		value, found, tombstone := sl.Get([]byte("test"))
+		require.Equal(t, []byte("test"), value)
+		require.Equal(t, true, found)
+		require.Equal(t, false, tombstone)
+	}
+
+	sl.Put([]byte("test"), []byte("overwritten"))
+
+	{
+		value, found, tombstone := sl.Get([]byte("test"))
+		require.Equal(t, []byte("overwritten"), value)
+		require.Equal(t, true, found)
+		require.Equal(t, false, tombstone)
+	}
+
+	sl.Del([]byte("test"))
+
+	{
+		value, found, tombstone := sl.Get([]byte("test"))
+		require.Equal(t, []byte(""), value)
+		require.Equal(t, false, found)
+		require.Equal(t, true, tombstone)
+	}
+
+	{
+		value, found, tombstone := sl.Get([]byte("no-entry"))
+		require.Equal(t, []byte(""), value)
+		require.Equal(t, false, found)
+		require.Equal(t, false, tombstone)
+	}
+
+	require.Equal(t, 1, sl.Len())
+}
+
+func test_skiplist_Order(t *testing.T, sl *SkipList) {
+	for _, key := range []string{"d", "b", "e", "a", "c"} {
+		sl.Put([]byte(key), []byte(key))
+	}
+
+	var keys []string
+	for x := sl.head.next[0].Load(); x != nil; x = x.next[0].Load() {
+		keys = append(keys, string(x.key))
+	}
+
+	require.Equal(t, []string{"a", "b", "c", "d", "e"}, keys)
+}
+
+func test_skiplist_Clear(t *testing.T, sl *SkipList) {
+	sl.Put([]byte("a"), []byte("A"))
+	sl.Clear()
+
+	require.Equal(t, 0, sl.Len())
+	require.Equal(t, uint64(0), sl.Size())
+
+	_, found, _ := sl.Get([]byte("a"))
+	require.Equal(t, false, found)
+}
+
+func test_skiplist_Concurrent(t *testing.T, sl *SkipList) {
+	const n = 1000
+
+	var wg sync.WaitGroup
+
+	// one writer
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < n; i++ {
+			sl.Put([]byte(fmt.Sprintf("key%04d", i)), []byte(fmt.Sprintf("value%04d", i)))
+		}
+	}()
+
+	// lock-free readers must only ever observe complete entries.
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < n; i++ {
+				value, found, _ := sl.Get([]byte(fmt.Sprintf("key%04d", i)))
+				if found {
+					require.Equal(t, []byte(fmt.Sprintf("value%04d", i)), value)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	require.Equal(t, n, sl.Len())
+}
@@ -0,0 +1,163 @@
+package merge
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+const (
+	OPERAND_SIZE int = 8 // Byte
+)
+
+var (
+	enc = binary.BigEndian
+)
+
+var (
+	ErrTruncated = errors.New("merge: truncated operand list")
+)
+
+// Operator combines merge operands written with Merge into a value,
+// letting read-modify-write updates skip the read.
+type Operator interface {
+	// Name identifies the operator.
+	Name() string
+	// FullMerge applies operands, oldest first, to existing. existing is
+	// nil when the key has no value, e.g. it was never written or was
+	// deleted.
+	FullMerge(key, existing []byte, operands [][]byte) ([]byte, error)
+	// PartialMerge combines two adjacent operands, left being the older
+	// one, into a single operand. It reports false when the operands
+	// cannot be combined without the base value.
+	PartialMerge(key, left, right []byte) ([]byte, bool)
+}
+
+// EncodeOperands packs operands into a single value as a sequence of
+// size-prefixed byte strings.
+func EncodeOperands(operands [][]byte) []byte {
+	n := 0
+	for _, op := range operands {
+		n += OPERAND_SIZE + len(op)
+	}
+
+	buf := make([]byte, 0, n)
+	for _, op := range operands {
+		buf = enc.AppendUint64(buf, uint64(len(op)))
+		buf = append(buf, op...)
+	}
+
+	return buf
+}
+
+// DecodeOperands unpacks a value written by EncodeOperands. The returned
+// operands alias buf.
+func DecodeOperands(buf []byte) ([][]byte, error) {
+	var operands [][]byte
+
+	for len(buf) > 0 {
+		if len(buf) < OPERAND_SIZE {
+			return nil, ErrTruncated
+		}
+		size := enc.Uint64(buf)
+		buf = buf[OPERAND_SIZE:]
+
+		if uint64(len(buf)) < size {
+			return nil, ErrTruncated
+		}
+		operands = append(operands, buf[:size:size])
+		buf = buf[size:]
+	}
+
+	return operands, nil
+}
+
+// Collapse shrinks operands, oldest first, by partially merging adjacent
+// pairs where op allows it.
+func Collapse(op Operator, key []byte, operands [][]byte) [][]byte {
+	if op == nil || len(operands) < 2 {
+		return operands
+	}
+
+	out := [][]byte{operands[0]}
+	for _, right := range operands[1:] {
+		left := out[len(out)-1]
+		if merged, ok := op.PartialMerge(key, left, right); ok {
+			out[len(out)-1] = merged
+		} else {
+			out = append(out, right)
+		}
+	}
+
+	return out
+}
+
+// Uint64Add treats values and operands as decimal unsigned integers and
+// adds operands to the value, e.g. for counters.
+type Uint64Add struct{}
+
+func (Uint64Add) Name() string {
+	return "lsm.Uint64AddOperator"
+}
+
+func (Uint64Add) FullMerge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	var sum uint64
+
+	if existing != nil {
+		n, err := strconv.ParseUint(string(existing), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		sum = n
+	}
+
+	for _, op := range operands {
+		n, err := strconv.ParseUint(string(op), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		sum += n
+	}
+
+	return []byte(strconv.FormatUint(sum, 10)), nil
+}
+
+func (u Uint64Add) PartialMerge(key, left, right []byte) ([]byte, bool) {
+	merged, err := u.FullMerge(key, left, [][]byte{right})
+	if err != nil {
+		return nil, false
+	}
+
+	return merged, true
+}
+
+// Append concatenates operands to the value, separated by Sep, e.g. for
+// append-only lists.
+type Append struct {
+	Sep []byte
+}
+
+func (Append) Name() string {
+	return "lsm.AppendOperator"
+}
+
+func (a Append) FullMerge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	out := append([]byte(nil), existing...)
+
+	for i, op := range operands {
+		if existing != nil || i > 0 {
+			out = append(out, a.Sep...)
+		}
+		out = append(out, op...)
+	}
+
+	return out, nil
+}
+
+func (a Append) PartialMerge(key, left, right []byte) ([]byte, bool) {
+	out := append([]byte(nil), left...)
+	out = append(out, a.Sep...)
+	out = append(out, right...)
+
+	return out, true
+}
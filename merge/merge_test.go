@@ -0,0 +1,79 @@
+package merge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerge(t *testing.T) {
+	for scenario, fn := range map[string]func(
+		t *testing.T,
+	){
+		"Operands":  test_Operands,
+		"Collapse":  test_Collapse,
+		"Uint64Add": test_Uint64Add,
+		"Append":    test_Append,
+	} {
+		fn := fn // https://github.com/golang/go/wiki/CommonMistakes
+		t.Run(scenario, func(t *testing.T) {
+			fn(t)
+		})
+	}
+}
+
+func test_Operands(t *testing.T) {
+	operands := [][]byte{[]byte("a"), []byte(""), []byte("ccc")}
+
+	decoded, err := DecodeOperands(EncodeOperands(operands))
+	require.NoError(t, err)
+	require.Equal(t, operands, decoded)
+
+	_, err = DecodeOperands([]byte{0, 0, 0})
+	require.ErrorIs(t, err, ErrTruncated)
+
+	_, err = DecodeOperands(EncodeOperands(operands)[:10])
+	require.ErrorIs(t, err, ErrTruncated)
+}
+
+func test_Collapse(t *testing.T) {
+	operands := [][]byte{[]byte("1"), []byte("2"), []byte("3")}
+
+	require.Equal(t, [][]byte{[]byte("6")}, Collapse(Uint64Add{}, nil, operands))
+	require.Equal(t, operands, Collapse(nil, nil, operands))
+
+	// operands that cannot be combined are kept apart.
+	mixed := [][]byte{[]byte("1"), []byte("x"), []byte("2"), []byte("3")}
+	require.Equal(t, [][]byte{[]byte("1"), []byte("x"), []byte("5")}, Collapse(Uint64Add{}, nil, mixed))
+}
+
+func test_Uint64Add(t *testing.T) {
+	op := Uint64Add{}
+
+	value, err := op.FullMerge(nil, []byte("10"), [][]byte{[]byte("1"), []byte("2")})
+	require.NoError(t, err)
+	require.Equal(t, []byte("13"), value)
+
+	value, err = op.FullMerge(nil, nil, [][]byte{[]byte("5")})
+	require.NoError(t, err)
+	require.Equal(t, []byte("5"), value)
+
+	_, err = op.FullMerge(nil, []byte("x"), nil)
+	require.Error(t, err)
+}
+
+func test_Append(t *testing.T) {
+	op := Append{Sep: []byte(",")}
+
+	value, err := op.FullMerge(nil, []byte("a"), [][]byte{[]byte("b"), []byte("c")})
+	require.NoError(t, err)
+	require.Equal(t, []byte("a,b,c"), value)
+
+	value, err = op.FullMerge(nil, nil, [][]byte{[]byte("b"), []byte("c")})
+	require.NoError(t, err)
+	require.Equal(t, []byte("b,c"), value)
+
+	merged, ok := op.PartialMerge(nil, []byte("b"), []byte("c"))
+	require.Equal(t, true, ok)
+	require.Equal(t, []byte("b,c"), merged)
+}
@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/db"
+	"github.com/sosomasox/LSM-Tree-based-Storage/statistics"
+)
+
+const (
+	NAMESPACE string = "lsm"
+	// label of the metrics of a column family.
+	COLUMN_FAMILY_LABEL string = "column_family"
+)
+
+// Collector exports the statistics of a store and the state of its column
+// families as Prometheus metrics: tickers as counters, histograms as
+// summaries with the 50th, 95th and 99th percentiles, and the sizes of the
+// memtables, tables and value logs as gauges. Each column family is a
+// single stack of tables, reported under its name.
+type Collector struct {
+	store *db.DB
+
+	tickers    [statistics.TICKER_MAX]*prometheus.Desc
+	histograms [statistics.HISTOGRAM_MAX]*prometheus.Desc
+
+	memTableSize           *prometheus.Desc
+	numTables              *prometheus.Desc
+	tableBytes             *prometheus.Desc
+	valueLogBytes          *prometheus.Desc
+	pendingCompactionBytes *prometheus.Desc
+	blockCacheHitRatio     *prometheus.Desc
+}
+
+// NewCollector returns a collector of the metrics of store. The store must
+// have been opened with Options.Statistics for the counters and summaries
+// to move.
+func NewCollector(store *db.DB) *Collector {
+	c := &Collector{store: store}
+
+	for t := range c.tickers {
+		ticker := statistics.Ticker(t)
+		c.tickers[t] = prometheus.NewDesc(metricName(ticker.String())+"_total",
+			"Store statistics ticker "+ticker.String()+".", nil, nil)
+	}
+
+	for h := range c.histograms {
+		histogram := statistics.Histogram(h)
+		c.histograms[h] = prometheus.NewDesc(metricName(histogram.String()),
+			"Store statistics histogram "+histogram.String()+".", nil, nil)
+	}
+
+	cfDesc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(NAMESPACE, "", name), help,
+			[]string{COLUMN_FAMILY_LABEL}, nil)
+	}
+	c.memTableSize = cfDesc("memtable_bytes", "Approximate bytes held by the memtable.")
+	c.numTables = cfDesc("tables", "Number of tables.")
+	c.tableBytes = cfDesc("table_bytes", "Bytes of the tables.")
+	c.valueLogBytes = cfDesc("value_log_bytes", "Bytes of the value logs.")
+	c.pendingCompactionBytes = cfDesc("compaction_pending_bytes",
+		"Bytes of the tables flushed over the oldest one, merged by the next compaction.")
+
+	c.blockCacheHitRatio = prometheus.NewDesc(prometheus.BuildFQName(NAMESPACE, "block_cache", "hit_ratio"),
+		"Ratio of block reads served by the block cache.", nil, nil)
+
+	return c
+}
+
+// metricName turns the dotted name of a ticker or histogram into a metric
+// name.
+func metricName(name string) string {
+	return strings.ReplaceAll(name, ".", "_")
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range c.tickers {
+		ch <- desc
+	}
+
+	for _, desc := range c.histograms {
+		ch <- desc
+	}
+
+	ch <- c.memTableSize
+	ch <- c.numTables
+	ch <- c.tableBytes
+	ch <- c.valueLogBytes
+	ch <- c.pendingCompactionBytes
+	ch <- c.blockCacheHitRatio
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	snap := c.store.GetStatistics()
+
+	for t, v := range snap.Tickers {
+		ch <- prometheus.MustNewConstMetric(c.tickers[t], prometheus.CounterValue, float64(v))
+	}
+
+	for h, d := range snap.Histograms {
+		ch <- prometheus.MustNewConstSummary(c.histograms[h], d.Count, float64(d.Sum), map[float64]float64{
+			0.5:  d.P50,
+			0.95: d.P95,
+			0.99: d.P99,
+		})
+	}
+
+	hits := snap.Tickers[statistics.BLOCK_CACHE_HITS]
+	misses := snap.Tickers[statistics.BLOCK_CACHE_MISSES]
+	ratio := 0.0
+	if hits+misses > 0 {
+		ratio = float64(hits) / float64(hits+misses)
+	}
+	ch <- prometheus.MustNewConstMetric(c.blockCacheHitRatio, prometheus.GaugeValue, ratio)
+
+	for _, name := range c.store.ListColumnFamilies() {
+		cf, ok := c.store.ColumnFamily(name)
+		if !ok {
+			continue
+		}
+
+		// the column family may have been dropped or the store closed
+		// since it was listed.
+		m, err := cf.Metrics()
+		if err != nil {
+			continue
+		}
+
+		gauge := func(desc *prometheus.Desc, v float64) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, name)
+		}
+		gauge(c.memTableSize, float64(m.MemTableSize))
+		gauge(c.numTables, float64(m.NumTables))
+		gauge(c.tableBytes, float64(m.TableBytes))
+		gauge(c.valueLogBytes, float64(m.ValueLogBytes))
+		gauge(c.pendingCompactionBytes, float64(m.PendingCompactionBytes))
+	}
+}
+
+// Register registers a collector of the metrics of store with r.
+func Register(r prometheus.Registerer, store *db.DB) error {
+	return r.Register(NewCollector(store))
+}
+
+// Handler returns an HTTP handler serving the metrics of store alone, in
+// the Prometheus exposition format. Servers exporting other metrics
+// should Register the store with their own registry instead.
+func Handler(store *db.DB) http.Handler {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewCollector(store))
+
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+}
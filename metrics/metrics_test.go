@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/db"
+	"github.com/sosomasox/LSM-Tree-based-Storage/sstable"
+)
+
+func TestMetrics(t *testing.T) {
+	for scenario, fn := range map[string]func(
+		t *testing.T, store *db.DB,
+	){
+		"Handler":  test_metrics_Handler,
+		"Register": test_metrics_Register,
+	} {
+		fn := fn // https://github.com/golang/go/wiki/CommonMistakes
+		t.Run(scenario, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "test_metrics_")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			opts := db.DefaultOptions()
+			opts.Table = sstable.DefaultOptions()
+			opts.Table.BlockCache = sstable.NewCache(1 << 20)
+
+			store, err := db.Open(dir, opts)
+			require.NoError(t, err)
+			defer store.Close()
+
+			fn(t, store)
+		})
+	}
+}
+
+func scrape(t *testing.T, url string) string {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return string(body)
+}
+
+func requireLines(t *testing.T, body string, lines ...string) {
+	t.Helper()
+
+	for _, line := range lines {
+		require.True(t, strings.Contains(body, line+"\n"), "missing %q in:\n%s", line, body)
+	}
+}
+
+func test_metrics_Handler(t *testing.T, store *db.DB) {
+	users, err := store.CreateColumnFamily("users", nil)
+	require.NoError(t, err)
+
+	require.NoError(t, store.Put([]byte("a"), []byte("A")))
+	require.NoError(t, store.Flush())
+	require.NoError(t, store.Put([]byte("b"), []byte("B")))
+	require.NoError(t, store.Flush())
+	require.NoError(t, users.Put([]byte("u"), []byte("U")))
+
+	// a miss then a hit of the block cache.
+	for i := 0; i < 2; i++ {
+		_, err := store.Get([]byte("a"))
+		require.NoError(t, err)
+	}
+
+	srv := httptest.NewServer(Handler(store))
+	defer srv.Close()
+
+	body := scrape(t, srv.URL)
+
+	requireLines(t, body,
+		"lsm_keys_written_total 3",
+		"lsm_wal_syncs_total 3",
+		"lsm_flushes_total 2",
+		"lsm_get_micros_count 2",
+		"lsm_block_cache_hit_ratio 0.5",
+		`lsm_tables{column_family="default"} 2`,
+		`lsm_tables{column_family="users"} 0`,
+		`lsm_memtable_bytes{column_family="default"} 0`,
+		`lsm_compaction_pending_bytes{column_family="users"} 0`,
+	)
+	require.True(t, strings.Contains(body, "# TYPE lsm_get_micros summary\n"))
+	require.True(t, strings.Contains(body, `lsm_get_micros{quantile="0.99"}`))
+	require.True(t, strings.Contains(body, "lsm_wal_bytes_written_total "))
+	require.False(t, strings.Contains(body, `lsm_compaction_pending_bytes{column_family="default"} 0`+"\n"))
+	require.False(t, strings.Contains(body, `lsm_memtable_bytes{column_family="users"} 0`+"\n"))
+
+	// counters move between scrapes.
+	require.NoError(t, store.Put([]byte("c"), []byte("C")))
+	requireLines(t, scrape(t, srv.URL), "lsm_keys_written_total 4")
+
+	// dropped column families disappear.
+	require.NoError(t, store.DropColumnFamily(users))
+	require.False(t, strings.Contains(scrape(t, srv.URL), `column_family="users"`))
+}
+
+func test_metrics_Register(t *testing.T, store *db.DB) {
+	reg := prometheus.NewRegistry()
+	require.NoError(t, Register(reg, store))
+
+	// a store registers its metrics once per registry.
+	var already prometheus.AlreadyRegisteredError
+	require.ErrorAs(t, Register(reg, store), &already)
+
+	require.NoError(t, store.Put([]byte("a"), []byte("A")))
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	values := map[string]float64{}
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			switch {
+			case m.GetCounter() != nil:
+				values[mf.GetName()] = m.GetCounter().GetValue()
+			case m.GetSummary() != nil:
+				values[mf.GetName()] = float64(m.GetSummary().GetSampleCount())
+			}
+		}
+	}
+
+	require.Equal(t, float64(1), values["lsm_keys_written_total"])
+	require.Equal(t, float64(1), values["lsm_write_micros"])
+	require.Equal(t, float64(0), values["lsm_compactions_total"])
+}
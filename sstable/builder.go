@@ -0,0 +1,394 @@
+package sstable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/bits-and-blooms/bloom"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/comparator"
+	"github.com/sosomasox/LSM-Tree-based-Storage/logging"
+	"github.com/sosomasox/LSM-Tree-based-Storage/status"
+)
+
+var (
+	ErrOutOfOrder    = errors.New("sstable: keys must be added in strictly ascending order")
+	ErrBuilderClosed = fmt.Errorf("sstable: builder %w: already finished or abandoned", status.ErrClosed)
+)
+
+// Builder builds a table file from entries added in ascending key order.
+// Entries are written to a temporary file which Finish renames to its
+// final path, so a crash never leaves a half-written table under the
+// final name. Abandon discards everything written so far.
+type Builder struct {
+	rwmu   sync.RWMutex
+	opts   *Options
+	path   string
+	file   *os.File
+	offset uint64
+	block  bytes.Buffer
+	last   []byte
+	index  []indexEntry
+	// first key, and the filter hashes of the keys: only these are kept
+	// until Finish, so that memory does not grow with the size of keys.
+	smallest []byte
+	hashes   []uint64
+	entries  uint64
+	closed   bool
+	// handle of the last flushed block, waiting for the next key so that
+	// its index entry can use a short separator.
+	pending    *blockHandle
+	pendingKey []byte
+	props      map[string][]byte
+	rangeDels  []RangeTombstone
+}
+
+func NewBuilder(path string, opts *Options) (*Builder, error) {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+
+	f, err := os.OpenFile(path+TMP_SUFFIX, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, status.IO(err)
+	}
+
+	return &Builder{
+		opts: opts,
+		path: path,
+		file: f,
+		props: map[string][]byte{
+			PROP_COMPARATOR: []byte(opts.comparator().Name()),
+		},
+	}, nil
+}
+
+// SetProperty records a property in the table's meta block.
+func (b *Builder) SetProperty(name string, value []byte) {
+	b.rwmu.Lock()
+	defer b.rwmu.Unlock()
+
+	b.props[name] = append([]byte(nil), value...)
+}
+
+func (b *Builder) Add(key, value []byte, tombstone bool) error {
+	if tombstone {
+		return b.AddEntry(key, value, TOMBSTONE)
+	}
+
+	return b.AddEntry(key, value, NO_TOMBSTONE)
+}
+
+// AddEntry adds an entry of the given type, e.g. a MERGE entry whose value
+// holds encoded merge operands.
+func (b *Builder) AddEntry(key, value []byte, t TombstoneType) error {
+	b.rwmu.Lock()
+	defer b.rwmu.Unlock()
+
+	if b.closed {
+		return ErrBuilderClosed
+	}
+
+	cmp := b.opts.comparator()
+
+	if b.entries > 0 && cmp.Compare(key, b.last) <= 0 {
+		return ErrOutOfOrder
+	}
+
+	if b.pending != nil {
+		b.addIndexEntry(cmp.FindShortestSeparator(b.pendingKey, key))
+	}
+
+	encodeEntry(&b.block, key, value, t)
+	if b.entries == 0 {
+		b.smallest = append([]byte(nil), key...)
+	}
+	if fk, ok := comparator.FilterKey(b.opts.Comparator, key); ok {
+		b.hashes = append(b.hashes, filterHash(fk))
+	}
+	b.last = append(b.last[:0], key...)
+	b.entries += 1
+
+	if b.block.Len() >= b.opts.BlockSize {
+		return b.flushBlock()
+	}
+
+	return nil
+}
+
+// AddRangeTombstone records a tombstone deleting [start, end) in older
+// tables. Range tombstones may be added in any order.
+func (b *Builder) AddRangeTombstone(start, end []byte) error {
+	b.rwmu.Lock()
+	defer b.rwmu.Unlock()
+
+	if b.closed {
+		return ErrBuilderClosed
+	}
+
+	if b.opts.comparator().Compare(start, end) >= 0 {
+		return ErrEmptyRange
+	}
+
+	b.rangeDels = append(b.rangeDels, RangeTombstone{
+		Start: append([]byte(nil), start...),
+		End:   append([]byte(nil), end...),
+	})
+
+	return nil
+}
+
+// NumEntries returns the number of entries added so far.
+func (b *Builder) NumEntries() uint64 {
+	b.rwmu.RLock()
+	defer b.rwmu.RUnlock()
+
+	return b.entries
+}
+
+// Path returns the path the table is written to once finished.
+func (b *Builder) Path() string {
+	return b.path
+}
+
+// FileSize returns the number of bytes written to the file so far,
+// excluding the block still being buffered.
+func (b *Builder) FileSize() uint64 {
+	b.rwmu.RLock()
+	defer b.rwmu.RUnlock()
+
+	return b.offset
+}
+
+func (b *Builder) flushBlock() error {
+	if b.block.Len() == 0 {
+		return nil
+	}
+
+	handle, err := b.writeBlock(b.block.Bytes())
+	if err != nil {
+		return err
+	}
+
+	b.pending = &handle
+	b.pendingKey = append(b.pendingKey[:0], b.last...)
+	b.block.Reset()
+
+	return nil
+}
+
+func (b *Builder) addIndexEntry(key []byte) {
+	b.index = append(b.index, indexEntry{
+		key:    key,
+		handle: *b.pending,
+	})
+	b.pending = nil
+}
+
+// writeBlock writes block followed by its checksum trailer.
+func (b *Builder) writeBlock(block []byte) (blockHandle, error) {
+	handle := blockHandle{offset: b.offset, size: uint64(len(block))}
+
+	if err := b.write(block); err != nil {
+		return blockHandle{}, err
+	}
+
+	var trailer [BLOCK_TRAILER_SIZE]byte
+	enc.PutUint32(trailer[:], crc32.Checksum(block, crcTable))
+	if err := b.write(trailer[:]); err != nil {
+		return blockHandle{}, err
+	}
+
+	return handle, nil
+}
+
+func (b *Builder) write(buf []byte) error {
+	n, err := b.file.Write(buf)
+	b.offset += uint64(n)
+	if err != nil {
+		return status.IO(err)
+	}
+
+	return nil
+}
+
+// Finish writes the filter, index and footer, syncs the file and renames
+// it into place. On failure no table is left: the temporary file is
+// removed, and so is the renamed table if its directory cannot be synced.
+func (b *Builder) Finish() error {
+	b.rwmu.Lock()
+	defer b.rwmu.Unlock()
+
+	if b.closed {
+		return ErrBuilderClosed
+	}
+	b.closed = true
+
+	if err := b.finish(); err != nil {
+		b.file.Close()
+		os.Remove(b.file.Name())
+		b.opts.logger().Error("table write failed", logging.KEY_PATH, b.path, logging.KEY_ERROR, err)
+		return err
+	}
+
+	// the rename may not survive a crash, so callers must not rely on the
+	// table being there.
+	if err := syncDir(filepath.Dir(b.path)); err != nil {
+		os.Remove(b.path)
+		b.opts.logger().Error("table write failed", logging.KEY_PATH, b.path, logging.KEY_ERROR, err)
+		return err
+	}
+	b.opts.logger().Debug("table written", logging.KEY_PATH, b.path, "entries", b.entries, "size", b.offset)
+
+	return nil
+}
+
+func (b *Builder) finish() error {
+	if err := b.flushBlock(); err != nil {
+		return err
+	}
+
+	if b.pending != nil {
+		b.addIndexEntry(b.opts.comparator().FindShortSuccessor(b.pendingKey))
+	}
+
+	// write filter block
+	var filterHandle blockHandle
+	{
+		n := uint(len(b.hashes))
+		if n == 0 {
+			n = 1
+		}
+
+		// the filter stays empty when the comparator has no filter keys;
+		// tables then skip it.
+		filter := bloom.NewWithEstimates(n, b.opts.BloomFalsePositiveRate)
+		for _, h := range b.hashes {
+			filter.Add(filterHashBytes(h))
+		}
+
+		var buf bytes.Buffer
+		if _, err := filter.WriteTo(&buf); err != nil {
+			return err
+		}
+
+		handle, err := b.writeBlock(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		filterHandle = handle
+	}
+
+	// write index block
+	var indexHandle blockHandle
+	{
+		var buf bytes.Buffer
+		for _, e := range b.index {
+			binary.Write(&buf, enc, uint64(len(e.key)))
+			buf.Write(e.key)
+			binary.Write(&buf, enc, e.handle.offset)
+			binary.Write(&buf, enc, e.handle.size)
+		}
+
+		handle, err := b.writeBlock(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		indexHandle = handle
+	}
+
+	if b.entries > 0 {
+		b.props[PROP_SMALLEST_KEY] = b.smallest
+		b.props[PROP_LARGEST_KEY] = append([]byte(nil), b.last...)
+	}
+
+	// write range deletion block
+	if len(b.rangeDels) > 0 {
+		handle, err := b.writeBlock(encodeRangeTombstones(b.rangeDels))
+		if err != nil {
+			return err
+		}
+
+		v := make([]byte, BLOCK_HANDLE_SIZE)
+		enc.PutUint64(v[0:], handle.offset)
+		enc.PutUint64(v[8:], handle.size)
+		b.props[PROP_RANGE_DEL] = v
+	}
+
+	// write meta block
+	metaHandle, err := b.writeBlock(encodeProperties(b.props))
+	if err != nil {
+		return err
+	}
+
+	// write footer
+	{
+		footer := make([]byte, FOOTER_SIZE)
+		enc.PutUint64(footer[0:], filterHandle.offset)
+		enc.PutUint64(footer[8:], filterHandle.size)
+		enc.PutUint64(footer[16:], indexHandle.offset)
+		enc.PutUint64(footer[24:], indexHandle.size)
+		enc.PutUint64(footer[32:], metaHandle.offset)
+		enc.PutUint64(footer[40:], metaHandle.size)
+		enc.PutUint32(footer[48:], crc32.Checksum(footer[:48], crcTable))
+		enc.PutUint64(footer[FOOTER_SIZE-MAGIC_SIZE:], TABLE_MAGIC)
+
+		if err := b.write(footer); err != nil {
+			return err
+		}
+	}
+
+	if err := b.file.Sync(); err != nil {
+		return status.IO(err)
+	}
+
+	if err := b.file.Close(); err != nil {
+		return status.IO(err)
+	}
+
+	if err := os.Rename(b.file.Name(), b.path); err != nil {
+		return status.IO(err)
+	}
+
+	return nil
+}
+
+// Abandon stops building the table and removes the temporary file. It is
+// a no-op after Finish.
+func (b *Builder) Abandon() error {
+	b.rwmu.Lock()
+	defer b.rwmu.Unlock()
+
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+
+	b.file.Close()
+
+	if err := os.Remove(b.file.Name()); err != nil && !os.IsNotExist(err) {
+		return status.IO(err)
+	}
+
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return status.IO(err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return status.IO(err)
+	}
+
+	return nil
+}
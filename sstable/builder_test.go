@@ -0,0 +1,233 @@
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/comparator"
+	"github.com/sosomasox/LSM-Tree-based-Storage/status"
+)
+
+func TestBuilder(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test_builder_")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for scenario, fn := range map[string]func(
+		t *testing.T, path string,
+	){
+		"Finish":     test_builder_Finish,
+		"OutOfOrder": test_builder_OutOfOrder,
+		"Abandon":    test_builder_Abandon,
+		"RenameFail": test_builder_RenameFail,
+		"Comparator": test_builder_Comparator,
+		"Fold":       test_builder_Fold,
+		"Filter":     test_builder_Filter,
+	} {
+		fn := fn // https://github.com/golang/go/wiki/CommonMistakes
+		path := filepath.Join(dir, scenario+".sst")
+		t.Run(scenario, func(t *testing.T) {
+			fn(t, path)
+		})
+	}
+}
+
+func test_builder_Finish(t *testing.T, path string) {
+	b, err := NewBuilder(path, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, b.Add([]byte("a"), []byte("A"), false))
+	require.NoError(t, b.Add([]byte("b"), []byte(""), true))
+	require.Equal(t, uint64(2), b.NumEntries())
+
+	// the table must not be visible under its final name before Finish.
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+
+	require.NoError(t, b.Finish())
+	require.ErrorIs(t, b.Finish(), ErrBuilderClosed)
+	require.ErrorIs(t, b.Add([]byte("c"), []byte("C"), false), ErrBuilderClosed)
+
+	_, err = os.Stat(path + TMP_SUFFIX)
+	require.True(t, os.IsNotExist(err))
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, int64(b.FileSize()), fi.Size())
+}
+
+func test_builder_OutOfOrder(t *testing.T, path string) {
+	b, err := NewBuilder(path, nil)
+	require.NoError(t, err)
+	defer b.Abandon()
+
+	require.NoError(t, b.Add([]byte("b"), []byte("B"), false))
+	require.ErrorIs(t, b.Add([]byte("b"), []byte("B"), false), ErrOutOfOrder)
+	require.ErrorIs(t, b.Add([]byte("a"), []byte("A"), false), ErrOutOfOrder)
+	require.Equal(t, uint64(1), b.NumEntries())
+}
+
+func test_builder_RenameFail(t *testing.T, path string) {
+	b, err := NewBuilder(path, nil)
+	require.NoError(t, err)
+	require.NoError(t, b.Add([]byte("a"), []byte("A"), false))
+
+	// a non-empty directory cannot be replaced by the table.
+	require.NoError(t, os.MkdirAll(filepath.Join(path, "dir"), 0755))
+	defer os.RemoveAll(path)
+
+	err = b.Finish()
+	require.ErrorIs(t, err, status.ErrIO)
+
+	_, err = os.Stat(path + TMP_SUFFIX)
+	require.True(t, os.IsNotExist(err))
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	require.True(t, fi.IsDir())
+}
+
+func test_builder_Abandon(t *testing.T, path string) {
+	b, err := NewBuilder(path, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, b.Add([]byte("a"), []byte("A"), false))
+	require.NoError(t, b.Abandon())
+	require.NoError(t, b.Abandon())
+
+	_, err = os.Stat(path + TMP_SUFFIX)
+	require.True(t, os.IsNotExist(err))
+
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+}
+
+func test_builder_Comparator(t *testing.T, path string) {
+	opts := DefaultOptions()
+	opts.BlockSize = 1
+	opts.Comparator = comparator.ReverseBytewise
+
+	b, err := NewBuilder(path, opts)
+	require.NoError(t, err)
+
+	require.NoError(t, b.Add([]byte("cherry"), []byte("C"), false))
+	require.NoError(t, b.Add([]byte("banana"), []byte("B"), false))
+	require.NoError(t, b.Add([]byte("apple"), []byte("A"), false))
+	require.ErrorIs(t, b.Add([]byte("banana"), []byte("B"), false), ErrOutOfOrder)
+	require.NoError(t, b.Finish())
+
+	tbl, err := OpenTable(path, opts)
+	require.NoError(t, err)
+	defer tbl.Close()
+
+	name, found := tbl.Property(PROP_COMPARATOR)
+	require.Equal(t, true, found)
+	require.Equal(t, []byte(comparator.ReverseBytewise.Name()), name)
+
+	smallest, _ := tbl.Property(PROP_SMALLEST_KEY)
+	largest, _ := tbl.Property(PROP_LARGEST_KEY)
+	require.Equal(t, []byte("cherry"), smallest)
+	require.Equal(t, []byte("apple"), largest)
+
+	for _, key := range []string{"apple", "banana", "cherry"} {
+		value, err := tbl.Get([]byte(key))
+		require.NoError(t, err)
+		require.Equal(t, []byte{byte(key[0]) - 'a' + 'A'}, value)
+	}
+
+	_, err = OpenTable(path, nil)
+	require.ErrorIs(t, err, ErrComparatorMismatch)
+}
+
+// foldComparator orders keys case-insensitively, so that distinct byte
+// strings compare equal.
+type foldComparator struct{}
+
+func (foldComparator) Compare(a, b []byte) int {
+	return bytes.Compare(bytes.ToLower(a), bytes.ToLower(b))
+}
+
+func (foldComparator) Name() string {
+	return "test.FoldComparator"
+}
+
+func (foldComparator) FindShortestSeparator(start, limit []byte) []byte {
+	return append([]byte(nil), start...)
+}
+
+func (foldComparator) FindShortSuccessor(key []byte) []byte {
+	return append([]byte(nil), key...)
+}
+
+// normalizedFoldComparator lets bloom filters hash lower-cased keys.
+type normalizedFoldComparator struct {
+	foldComparator
+}
+
+func (normalizedFoldComparator) NormalizeKey(key []byte) []byte {
+	return bytes.ToLower(key)
+}
+
+func test_builder_Fold(t *testing.T, path string) {
+	for _, cmp := range []comparator.Comparator{foldComparator{}, normalizedFoldComparator{}} {
+		opts := DefaultOptions()
+		opts.Comparator = cmp
+
+		b, err := NewBuilder(path, opts)
+		require.NoError(t, err)
+		require.NoError(t, b.Add([]byte("Apple"), []byte("A"), false))
+		require.NoError(t, b.Add([]byte("banana"), []byte("B"), false))
+		require.NoError(t, b.Finish())
+
+		tbl, err := OpenTable(path, opts)
+		require.NoError(t, err)
+
+		for _, key := range []string{"apple", "APPLE", "Banana"} {
+			value, err := tbl.Get([]byte(key))
+			require.NoError(t, err, key)
+			require.Equal(t, []byte{key[0] &^ 0x20}, value)
+		}
+
+		_, err = tbl.Get([]byte("cherry"))
+		require.ErrorIs(t, err, ErrNotFound)
+
+		tbl.Close()
+	}
+}
+
+func test_builder_Filter(t *testing.T, path string) {
+	b, err := NewBuilder(path, nil)
+	require.NoError(t, err)
+
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, b.Add([]byte(fmt.Sprintf("key%04d", i)), []byte("V"), false))
+	}
+
+	// only the hashes of the keys are kept until Finish.
+	require.Equal(t, 1000, len(b.hashes))
+	require.Equal(t, []byte("key0000"), b.smallest)
+	require.NoError(t, b.Finish())
+
+	tbl, err := OpenTable(path, nil)
+	require.NoError(t, err)
+	defer tbl.Close()
+
+	smallest, _ := tbl.Property(PROP_SMALLEST_KEY)
+	largest, _ := tbl.Property(PROP_LARGEST_KEY)
+	require.Equal(t, []byte("key0000"), smallest)
+	require.Equal(t, []byte("key0999"), largest)
+
+	falsePositives := 0
+	for i := 0; i < 1000; i++ {
+		require.True(t, tbl.filter.Test(filterHashBytes(filterHash([]byte(fmt.Sprintf("key%04d", i))))))
+		if tbl.filter.Test(filterHashBytes(filterHash([]byte(fmt.Sprintf("absent%04d", i))))) {
+			falsePositives += 1
+		}
+	}
+	require.Less(t, falsePositives, 50)
+}
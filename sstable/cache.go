@@ -0,0 +1,161 @@
+package sstable
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	CACHE_SHARDS int = 16
+)
+
+type cacheKey struct {
+	id     uint64
+	offset uint64
+}
+
+type cacheEntry struct {
+	key   cacheKey
+	block []byte
+}
+
+type cacheShard struct {
+	rwmu     sync.RWMutex
+	capacity int64
+	size     int64
+	lru      *list.List
+	// blocks by table ID and offset, so that a table can be erased
+	// without scanning the whole shard.
+	items map[uint64]map[uint64]*list.Element
+}
+
+// Cache is a sharded LRU cache of data blocks keyed by (table ID, block
+// offset). A single Cache can be shared by every open table through
+// Options.BlockCache. Cached blocks must not be modified.
+type Cache struct {
+	shards [CACHE_SHARDS]*cacheShard
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// NewCache returns a cache holding at most capacity bytes of blocks.
+func NewCache(capacity int64) *Cache {
+	c := &Cache{}
+
+	perShard := capacity / int64(CACHE_SHARDS)
+	if perShard < 1 {
+		perShard = 1
+	}
+
+	for i := range c.shards {
+		c.shards[i] = &cacheShard{
+			capacity: perShard,
+			lru:      list.New(),
+			items:    make(map[uint64]map[uint64]*list.Element),
+		}
+	}
+
+	return c
+}
+
+func (c *Cache) shard(key cacheKey) *cacheShard {
+	h := key.id*0x9e3779b97f4a7c15 ^ key.offset
+	h ^= h >> 29
+
+	return c.shards[h%uint64(CACHE_SHARDS)]
+}
+
+func (c *Cache) Get(id, offset uint64) ([]byte, bool) {
+	key := cacheKey{id: id, offset: offset}
+	s := c.shard(key)
+
+	s.rwmu.Lock()
+	e, found := s.items[id][offset]
+	if found {
+		s.lru.MoveToFront(e)
+	}
+	s.rwmu.Unlock()
+
+	if !found {
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	c.hits.Add(1)
+
+	return e.Value.(*cacheEntry).block, true
+}
+
+func (c *Cache) Set(id, offset uint64, block []byte) {
+	key := cacheKey{id: id, offset: offset}
+	s := c.shard(key)
+
+	s.rwmu.Lock()
+	defer s.rwmu.Unlock()
+
+	if e, found := s.items[id][offset]; found {
+		s.size -= int64(len(e.Value.(*cacheEntry).block))
+		e.Value.(*cacheEntry).block = block
+		s.size += int64(len(block))
+		s.lru.MoveToFront(e)
+	} else {
+		if s.items[id] == nil {
+			s.items[id] = make(map[uint64]*list.Element)
+		}
+		s.items[id][offset] = s.lru.PushFront(&cacheEntry{key: key, block: block})
+		s.size += int64(len(block))
+	}
+
+	for s.size > s.capacity && s.lru.Len() > 0 {
+		s.remove(s.lru.Back())
+	}
+}
+
+// EraseTable drops every block of the table with the given ID, e.g. once
+// the table is closed: table IDs are never reused, so its blocks could
+// only be evicted by the LRU otherwise.
+func (c *Cache) EraseTable(id uint64) {
+	for _, s := range c.shards {
+		s.rwmu.Lock()
+		for _, e := range s.items[id] {
+			s.remove(e)
+		}
+		s.rwmu.Unlock()
+	}
+}
+
+// remove must be called with the shard lock held.
+func (s *cacheShard) remove(e *list.Element) {
+	entry := e.Value.(*cacheEntry)
+
+	s.lru.Remove(e)
+	delete(s.items[entry.key.id], entry.key.offset)
+	if len(s.items[entry.key.id]) == 0 {
+		delete(s.items, entry.key.id)
+	}
+	s.size -= int64(len(entry.block))
+}
+
+// Hits returns the number of lookups served from the cache.
+func (c *Cache) Hits() uint64 {
+	return c.hits.Load()
+}
+
+// Misses returns the number of lookups not found in the cache.
+func (c *Cache) Misses() uint64 {
+	return c.misses.Load()
+}
+
+// Size returns the number of bytes currently cached.
+func (c *Cache) Size() int64 {
+	var size int64
+
+	for _, s := range c.shards {
+		s.rwmu.RLock()
+		size += s.size
+		s.rwmu.RUnlock()
+	}
+
+	return size
+}
@@ -0,0 +1,105 @@
+package sstable
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	for scenario, fn := range map[string]func(
+		t *testing.T, c *Cache,
+	){
+		"Get/Set":    test_cache_GetSet,
+		"Evict":      test_cache_Evict,
+		"EraseTable": test_cache_EraseTable,
+		"Concurrent": test_cache_Concurrent,
+	} {
+		fn := fn // https://github.com/golang/go/wiki/CommonMistakes
+		t.Run(scenario, func(t *testing.T) {
+			fn(t, NewCache(int64(CACHE_SHARDS)*64))
+		})
+	}
+}
+
+func test_cache_GetSet(t *testing.T, c *Cache) {
+	_, found := c.Get(1, 0)
+	require.Equal(t, false, found)
+	require.Equal(t, uint64(0), c.Hits())
+	require.Equal(t, uint64(1), c.Misses())
+
+	c.Set(1, 0, []byte("block"))
+
+	block, found := c.Get(1, 0)
+	require.Equal(t, true, found)
+	require.Equal(t, []byte("block"), block)
+	require.Equal(t, uint64(1), c.Hits())
+	require.Equal(t, int64(len("block")), c.Size())
+
+	// same offset in another table is a different entry.
+	_, found = c.Get(2, 0)
+	require.Equal(t, false, found)
+
+	c.Set(1, 0, []byte("replaced"))
+	block, _ = c.Get(1, 0)
+	require.Equal(t, []byte("replaced"), block)
+	require.Equal(t, int64(len("replaced")), c.Size())
+}
+
+func test_cache_EraseTable(t *testing.T, c *Cache) {
+	for offset := uint64(0); offset < 8; offset++ {
+		c.Set(1, offset, []byte("block"))
+		c.Set(2, offset, []byte("other"))
+	}
+
+	c.EraseTable(1)
+	require.Equal(t, int64(8*len("other")), c.Size())
+
+	for offset := uint64(0); offset < 8; offset++ {
+		_, found := c.Get(1, offset)
+		require.Equal(t, false, found)
+
+		_, found = c.Get(2, offset)
+		require.Equal(t, true, found)
+	}
+
+	// erasing an unknown table is a no-op.
+	c.EraseTable(3)
+	require.Equal(t, int64(8*len("other")), c.Size())
+}
+
+func test_cache_Evict(t *testing.T, c *Cache) {
+	// every shard holds 64 bytes, so filling one table's blocks far beyond
+	// the total capacity must keep the size bounded.
+	for offset := uint64(0); offset < 1024; offset++ {
+		c.Set(1, offset, make([]byte, 32))
+	}
+
+	require.LessOrEqual(t, c.Size(), int64(CACHE_SHARDS)*64)
+
+	// the most recently inserted block survives.
+	_, found := c.Get(1, 1023)
+	require.Equal(t, true, found)
+}
+
+func test_cache_Concurrent(t *testing.T, c *Cache) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(id uint64) {
+			defer wg.Done()
+
+			for offset := uint64(0); offset < 256; offset++ {
+				c.Set(id, offset, make([]byte, 8))
+				c.Get(id, offset)
+			}
+		}(uint64(i))
+	}
+
+	wg.Wait()
+
+	require.Equal(t, uint64(8*256), c.Hits()+c.Misses())
+	require.LessOrEqual(t, c.Size(), int64(CACHE_SHARDS)*64)
+}
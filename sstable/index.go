@@ -7,6 +7,8 @@ import (
 	"io"
 	"os"
 	"sync"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/status"
 )
 
 type Iterator struct {
@@ -57,7 +59,10 @@ func (itr *Iterator) Next() (key []byte, value uint64, err error) {
 	itr.offset += int64(n)
 
 	key = []byte(string(keyBuf))
-	value, _ = itr.index.Get(key)
+	value, err = itr.index.Get(key)
+	if err != nil {
+		return []byte(""), uint64(0), err
+	}
 
 	return key, value, nil
 }
@@ -72,7 +77,7 @@ type Index struct {
 func newIndex(f *os.File) (*Index, error) {
 	fi, err := f.Stat()
 	if err != nil {
-		return nil, err
+		return nil, status.IO(err)
 	}
 
 	if fi.Size() == 0 {
@@ -99,7 +104,7 @@ func rebuildIndex(f *os.File) (*Index, error) {
 
 		n, err := f.ReadAt(ksizeBuf, offset)
 		if err != nil && err != io.EOF {
-			return nil, err
+			return nil, readError(err, "index %s at %d", f.Name(), offset)
 		} else if err == io.EOF {
 			break
 		}
@@ -114,7 +119,7 @@ func rebuildIndex(f *os.File) (*Index, error) {
 
 		n, err = f.ReadAt(keyBuf, offset)
 		if err != nil {
-			return nil, err
+			return nil, readError(err, "index %s at %d", f.Name(), offset)
 		}
 
 		offset += int64(n)
@@ -123,7 +128,7 @@ func rebuildIndex(f *os.File) (*Index, error) {
 
 		n, err = f.ReadAt(offsetSizeBuf, offset)
 		if err != nil {
-			return nil, err
+			return nil, readError(err, "index %s at %d", f.Name(), offset)
 		}
 
 		offset += int64(n)
@@ -186,7 +191,7 @@ func (idx *Index) Append(key []byte, offset uint64) error {
 	}
 
 	if err := bw.Flush(); err != nil {
-		return err
+		return status.IO(err)
 	}
 
 	/*
@@ -198,13 +203,17 @@ func (idx *Index) Append(key []byte, offset uint64) error {
 	return nil
 }
 
-func (idx *Index) Get(key []byte) (offset uint64, found bool) {
+// Get returns the segment offset of the record of key, or ErrNotFound.
+func (idx *Index) Get(key []byte) (offset uint64, err error) {
 	idx.rwmu.RLock()
 	defer idx.rwmu.RUnlock()
 
-	offset, found = idx.HashMap[string(key)]
+	offset, found := idx.HashMap[string(key)]
+	if !found {
+		return 0, ErrNotFound
+	}
 
-	return offset, found
+	return offset, nil
 }
 
 func (idx *Index) Size() uint64 {
@@ -0,0 +1,179 @@
+package sstable
+
+import (
+	"container/heap"
+	"io"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/comparator"
+)
+
+// EntryIterator yields typed entries in key order.
+type EntryIterator interface {
+	HasNext() bool
+	NextEntry() (key, value []byte, t TombstoneType, err error)
+}
+
+// Seeker is implemented by EntryIterators that can skip ahead, e.g. using
+// an index, instead of reading every entry before a key.
+type Seeker interface {
+	// Seek moves the iterator to the first entry whose key is >= key.
+	Seek(key []byte)
+}
+
+var (
+	_ EntryIterator = (*TableIterator)(nil)
+	_ Seeker        = (*TableIterator)(nil)
+)
+
+// Entry is an entry yielded by MergingIterator. Source is the index of
+// the iterator it came from.
+type Entry struct {
+	Key    []byte
+	Value  []byte
+	Type   TombstoneType
+	Source int
+}
+
+type entryHeap struct {
+	cmp     comparator.Comparator
+	entries []Entry
+}
+
+func (h *entryHeap) Len() int {
+	return len(h.entries)
+}
+
+func (h *entryHeap) Less(i, j int) bool {
+	if c := h.cmp.Compare(h.entries[i].Key, h.entries[j].Key); c != 0 {
+		return c < 0
+	}
+
+	return h.entries[i].Source < h.entries[j].Source
+}
+
+func (h *entryHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+}
+
+func (h *entryHeap) Push(x interface{}) {
+	h.entries = append(h.entries, x.(Entry))
+}
+
+func (h *entryHeap) Pop() interface{} {
+	e := h.entries[len(h.entries)-1]
+	h.entries = h.entries[:len(h.entries)-1]
+
+	return e
+}
+
+// MergingIterator merges several EntryIterators into a single stream
+// ordered by key. Entries for the same key are all yielded, ordered by
+// source index, so sources should be passed newest first.
+type MergingIterator struct {
+	sources []EntryIterator
+	heap    *entryHeap
+	err     error
+	started bool
+}
+
+func NewMergingIterator(cmp comparator.Comparator, sources ...EntryIterator) *MergingIterator {
+	return &MergingIterator{
+		sources: sources,
+		heap:    &entryHeap{cmp: comparator.OrDefault(cmp)},
+	}
+}
+
+func (itr *MergingIterator) fill(source int) {
+	if !itr.sources[source].HasNext() {
+		return
+	}
+
+	key, value, t, err := itr.sources[source].NextEntry()
+	if err != nil {
+		itr.err = err
+		return
+	}
+
+	heap.Push(itr.heap, Entry{Key: key, Value: value, Type: t, Source: source})
+}
+
+// fillFrom is like fill but skips the entries of source before key.
+func (itr *MergingIterator) fillFrom(source int, key []byte) {
+	for itr.sources[source].HasNext() {
+		k, v, t, err := itr.sources[source].NextEntry()
+		if err != nil {
+			itr.err = err
+			return
+		}
+
+		if itr.heap.cmp.Compare(k, key) >= 0 {
+			heap.Push(itr.heap, Entry{Key: k, Value: v, Type: t, Source: source})
+			return
+		}
+	}
+}
+
+func (itr *MergingIterator) start() {
+	if itr.started {
+		return
+	}
+	itr.started = true
+
+	for i := range itr.sources {
+		itr.fill(i)
+	}
+}
+
+func (itr *MergingIterator) HasNext() bool {
+	itr.start()
+
+	return itr.err == nil && itr.heap.Len() > 0
+}
+
+func (itr *MergingIterator) Next() (Entry, error) {
+	if !itr.HasNext() {
+		if itr.err != nil {
+			return Entry{}, itr.err
+		}
+		return Entry{}, io.EOF
+	}
+
+	e := heap.Pop(itr.heap).(Entry)
+	itr.fill(e.Source)
+
+	return e, nil
+}
+
+// Seek moves the iterator forward to the first entry whose key is >= key.
+// Sources that are Seekers skip ahead, the others are read up to key.
+func (itr *MergingIterator) Seek(key []byte) {
+	// each source read ahead by one entry, which is kept if it is not
+	// before key; a started source without one is exhausted.
+	pending := make(map[int]Entry)
+	for _, e := range itr.heap.entries {
+		pending[e.Source] = e
+	}
+	itr.heap.entries = itr.heap.entries[:0]
+	started := itr.started
+	itr.started = true
+
+	for i, source := range itr.sources {
+		if itr.err != nil {
+			return
+		}
+
+		e, found := pending[i]
+		switch {
+		case found && itr.heap.cmp.Compare(e.Key, key) >= 0:
+			heap.Push(itr.heap, e)
+			continue
+		case !found && started:
+			continue
+		}
+
+		if s, ok := source.(Seeker); ok {
+			s.Seek(key)
+		}
+		itr.fillFrom(i, key)
+	}
+}
@@ -0,0 +1,99 @@
+package sstable
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergingIterator(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test_merging_iterator_")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	build := func(name string, entries []Entry) *Table {
+		path := filepath.Join(dir, name)
+
+		b, err := NewBuilder(path, nil)
+		require.NoError(t, err)
+		for _, e := range entries {
+			require.NoError(t, b.AddEntry(e.Key, e.Value, e.Type))
+		}
+		require.NoError(t, b.Finish())
+
+		tbl, err := OpenTable(path, nil)
+		require.NoError(t, err)
+
+		return tbl
+	}
+
+	newer := build("000002.sst", []Entry{
+		{Key: []byte("b"), Value: []byte("B2"), Type: NO_TOMBSTONE},
+		{Key: []byte("d"), Value: []byte("+1"), Type: MERGE},
+	})
+	defer newer.Close()
+
+	older := build("000001.sst", []Entry{
+		{Key: []byte("a"), Value: []byte("A1"), Type: NO_TOMBSTONE},
+		{Key: []byte("b"), Value: []byte("B1"), Type: NO_TOMBSTONE},
+		{Key: []byte("c"), Type: TOMBSTONE},
+	})
+	defer older.Close()
+
+	{
+		value, ty, err := newer.GetEntry([]byte("d"), nil)
+		require.NoError(t, err)
+		require.Equal(t, MERGE, ty)
+		require.Equal(t, []byte("+1"), value)
+
+		// plain Get cannot resolve merge operands.
+		_, err = newer.Get([]byte("d"))
+		require.ErrorIs(t, err, ErrNotFound)
+		require.NotErrorIs(t, err, ErrDeleted)
+	}
+
+	itr := NewMergingIterator(nil, newer.NewIterator(nil), older.NewIterator(nil))
+
+	for _, expected := range []Entry{
+		{Key: []byte("a"), Value: []byte("A1"), Type: NO_TOMBSTONE, Source: 1},
+		{Key: []byte("b"), Value: []byte("B2"), Type: NO_TOMBSTONE, Source: 0},
+		{Key: []byte("b"), Value: []byte("B1"), Type: NO_TOMBSTONE, Source: 1},
+		{Key: []byte("c"), Value: []byte(""), Type: TOMBSTONE, Source: 1},
+		{Key: []byte("d"), Value: []byte("+1"), Type: MERGE, Source: 0},
+	} {
+		require.Equal(t, true, itr.HasNext())
+
+		e, err := itr.Next()
+		require.NoError(t, err)
+		require.Equal(t, expected, e)
+	}
+
+	require.Equal(t, false, itr.HasNext())
+
+	_, err = itr.Next()
+	require.ErrorIs(t, err, io.EOF)
+
+	// a seek skips the entries before the key in every source, and
+	// keeps those already read ahead.
+	itr = NewMergingIterator(nil, newer.NewIterator(nil), older.NewIterator(nil))
+	itr.Seek([]byte("b"))
+
+	e, err := itr.Next()
+	require.NoError(t, err)
+	require.Equal(t, Entry{Key: []byte("b"), Value: []byte("B2"), Type: NO_TOMBSTONE, Source: 0}, e)
+
+	itr.Seek([]byte("c"))
+	for _, expected := range []Entry{
+		{Key: []byte("c"), Value: []byte(""), Type: TOMBSTONE, Source: 1},
+		{Key: []byte("d"), Value: []byte("+1"), Type: MERGE, Source: 0},
+	} {
+		e, err := itr.Next()
+		require.NoError(t, err)
+		require.Equal(t, expected, e)
+	}
+
+	require.Equal(t, false, itr.HasNext())
+}
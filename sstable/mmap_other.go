@@ -0,0 +1,18 @@
+//go:build !unix
+
+package sstable
+
+import (
+	"errors"
+	"os"
+)
+
+var ErrMmapUnsupported = errors.New("sstable: mmap is not supported on this platform")
+
+func mmapFile(f *os.File, size int) ([]byte, error) {
+	return nil, ErrMmapUnsupported
+}
+
+func munmap(data []byte) error {
+	return nil
+}
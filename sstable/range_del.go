@@ -0,0 +1,67 @@
+package sstable
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/comparator"
+)
+
+var (
+	ErrEmptyRange = errors.New("sstable: range tombstone start must be before end")
+)
+
+// RangeTombstone deletes every key in [Start, End). It only applies to
+// entries older than itself: within a table or memtable, point entries
+// are always newer than the range tombstones stored next to them.
+type RangeTombstone struct {
+	Start []byte
+	End   []byte
+}
+
+func (rt RangeTombstone) Contains(cmp comparator.Comparator, key []byte) bool {
+	return cmp.Compare(rt.Start, key) <= 0 && cmp.Compare(key, rt.End) < 0
+}
+
+// Covers reports whether any of rts contains key.
+func Covers(cmp comparator.Comparator, rts []RangeTombstone, key []byte) bool {
+	for _, rt := range rts {
+		if rt.Contains(cmp, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// encodeRangeTombstones encodes rts as entries whose key is the start and
+// value the end of the range.
+func encodeRangeTombstones(rts []RangeTombstone) []byte {
+	var buf bytes.Buffer
+	for _, rt := range rts {
+		encodeEntry(&buf, rt.Start, rt.End, NO_TOMBSTONE)
+	}
+
+	return buf.Bytes()
+}
+
+// decodeRangeTombstones decodes a block written by
+// encodeRangeTombstones. The returned ranges do not alias buf.
+func decodeRangeTombstones(buf []byte) ([]RangeTombstone, error) {
+	var rts []RangeTombstone
+
+	for len(buf) > 0 {
+		start, end, _, n, err := decodeEntry(buf)
+		if err != nil {
+			return nil, err
+		}
+		buf = buf[n:]
+
+		rts = append(rts, RangeTombstone{
+			Start: append([]byte(nil), start...),
+			End:   append([]byte(nil), end...),
+		})
+	}
+
+	return rts, nil
+}
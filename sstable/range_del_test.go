@@ -0,0 +1,82 @@
+package sstable
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/comparator"
+)
+
+func TestRangeTombstone(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test_range_del_")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	t.Run("Covers", func(t *testing.T) {
+		cmp := comparator.Bytewise
+		rts := []RangeTombstone{
+			{Start: []byte("b"), End: []byte("d")},
+			{Start: []byte("x"), End: []byte("z")},
+		}
+
+		for key, expected := range map[string]bool{
+			"a":  false,
+			"b":  true,
+			"c":  true,
+			"cz": true,
+			"d":  false,
+			"y":  true,
+			"z":  false,
+		} {
+			require.Equal(t, expected, Covers(cmp, rts, []byte(key)), key)
+		}
+	})
+
+	t.Run("Table", func(t *testing.T) {
+		path := filepath.Join(dir, "000001.sst")
+
+		b, err := NewBuilder(path, nil)
+		require.NoError(t, err)
+
+		require.ErrorIs(t, b.AddRangeTombstone([]byte("b"), []byte("b")), ErrEmptyRange)
+		require.ErrorIs(t, b.AddRangeTombstone([]byte("c"), []byte("b")), ErrEmptyRange)
+
+		require.NoError(t, b.Add([]byte("c"), []byte("C"), false))
+		require.NoError(t, b.AddRangeTombstone([]byte("x"), []byte("z")))
+		require.NoError(t, b.AddRangeTombstone([]byte("b"), []byte("d")))
+		require.NoError(t, b.Finish())
+
+		tbl, err := OpenTable(path, nil)
+		require.NoError(t, err)
+		defer tbl.Close()
+
+		require.Equal(t, []RangeTombstone{
+			{Start: []byte("x"), End: []byte("z")},
+			{Start: []byte("b"), End: []byte("d")},
+		}, tbl.RangeTombstones())
+
+		// the table's own entries are newer than its range tombstones.
+		value, err := tbl.Get([]byte("c"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("C"), value)
+	})
+
+	t.Run("OnlyRangeTombstones", func(t *testing.T) {
+		path := filepath.Join(dir, "000002.sst")
+
+		b, err := NewBuilder(path, nil)
+		require.NoError(t, err)
+		require.NoError(t, b.AddRangeTombstone([]byte("a"), []byte("m")))
+		require.NoError(t, b.Finish())
+
+		tbl, err := OpenTable(path, nil)
+		require.NoError(t, err)
+		defer tbl.Close()
+
+		require.Equal(t, 1, len(tbl.RangeTombstones()))
+		require.Equal(t, false, tbl.NewIterator(nil).HasNext())
+	})
+}
@@ -4,8 +4,13 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
+	"io"
+	"log/slog"
 	"os"
 	"sync"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/logging"
+	"github.com/sosomasox/LSM-Tree-based-Storage/status"
 )
 
 var (
@@ -13,20 +18,22 @@ var (
 )
 
 type Segment struct {
-	rwmu sync.RWMutex
-	file *os.File
-	size uint64
+	rwmu   sync.RWMutex
+	file   *os.File
+	size   uint64
+	logger *slog.Logger
 }
 
-func newSegment(f *os.File) (*Segment, error) {
+func newSegment(f *os.File, logger *slog.Logger) (*Segment, error) {
 	fi, err := f.Stat()
 	if err != nil {
-		return nil, err
+		return nil, status.IO(err)
 	}
 
 	return &Segment{
-		file: f,
-		size: uint64(fi.Size()),
+		file:   f,
+		size:   uint64(fi.Size()),
+		logger: logger.With(logging.KEY_PATH, f.Name()),
 	}, nil
 }
 
@@ -95,7 +102,7 @@ func (seg *Segment) Append(key, value []byte, tombstone bool) error {
 	seg.size += uint64(len(value))
 
 	if err := bw.Flush(); err != nil {
-		return err
+		return status.IO(err)
 	}
 
 	/*
@@ -107,12 +114,22 @@ func (seg *Segment) Append(key, value []byte, tombstone bool) error {
 	return nil
 }
 
-func (seg *Segment) Get(offset uint64) (key, value []byte, found, tombstone bool) {
+// Get reads the record at offset. It returns the key with ErrDeleted for a
+// tombstone, and wraps ErrCorruption when the record is cut short.
+func (seg *Segment) Get(offset uint64) (key, value []byte, err error) {
+	start := offset
+
+	fail := func(err error) ([]byte, []byte, error) {
+		err = readError(err, "segment %s at %d", seg.file.Name(), start)
+		seg.logger.Error("segment read failed", logging.KEY_OFFSET, start, logging.KEY_ERROR, err)
+		return nil, nil, err
+	}
+
 	tombstoneBuf := make([]byte, TOMBSTONE_SIZE)
 	n, err := seg.file.ReadAt(tombstoneBuf, int64(offset))
 
 	if err != nil {
-		return []byte(""), []byte(""), false, false
+		return fail(err)
 	}
 
 	offset += uint64(n)
@@ -121,14 +138,14 @@ func (seg *Segment) Get(offset uint64) (key, value []byte, found, tombstone bool
 	err = binary.Read(bytes.NewReader(tombstoneBuf), enc, &ts)
 
 	if err != nil {
-		return []byte(""), []byte(""), false, false
+		return fail(err)
 	}
 
 	kvsizeBuf := make([]byte, KV_SIZE)
 	n, err = seg.file.ReadAt(kvsizeBuf, int64(offset))
 
 	if err != nil {
-		return []byte(""), []byte(""), false, false
+		return fail(err)
 	}
 
 	offset += uint64(n)
@@ -137,14 +154,14 @@ func (seg *Segment) Get(offset uint64) (key, value []byte, found, tombstone bool
 	err = binary.Read(bytes.NewReader(kvsizeBuf), enc, &kvsize)
 
 	if err != nil {
-		return []byte(""), []byte(""), false, false
+		return fail(err)
 	}
 
 	ksizeBuf := make([]byte, K_SIZE)
 	n, err = seg.file.ReadAt(ksizeBuf, int64(offset))
 
 	if err != nil {
-		return []byte(""), []byte(""), false, false
+		return fail(err)
 	}
 
 	offset += uint64(n)
@@ -153,14 +170,14 @@ func (seg *Segment) Get(offset uint64) (key, value []byte, found, tombstone bool
 	err = binary.Read(bytes.NewReader(ksizeBuf), enc, &ksize)
 
 	if err != nil {
-		return []byte(""), []byte(""), false, false
+		return fail(err)
 	}
 
 	vsizeBuf := make([]byte, V_SIZE)
 	n, err = seg.file.ReadAt(vsizeBuf, int64(offset))
 
 	if err != nil {
-		return []byte(""), []byte(""), false, false
+		return fail(err)
 	}
 
 	offset += uint64(n)
@@ -168,14 +185,24 @@ func (seg *Segment) Get(offset uint64) (key, value []byte, found, tombstone bool
 	var vsize uint64
 	err = binary.Read(bytes.NewReader(vsizeBuf), enc, &vsize)
 	if err != nil {
-		return []byte(""), []byte(""), false, false
+		return fail(err)
+	}
+
+	// sizes are checked one at a time against the bytes left after the
+	// header, as a corrupt header may make their sum overflow.
+	size := seg.Size()
+	if offset > size {
+		return fail(io.ErrUnexpectedEOF)
+	}
+	if rem := size - offset; ksize > rem || vsize > rem-ksize || ksize+vsize != kvsize {
+		return fail(io.ErrUnexpectedEOF)
 	}
 
 	keyBuf := make([]byte, ksize)
 	n, err = seg.file.ReadAt(keyBuf, int64(offset))
 
-	if err != nil {
-		return []byte(""), []byte(""), false, false
+	if err != nil && !(err == io.EOF && n == len(keyBuf)) {
+		return fail(err)
 	}
 
 	offset += uint64(n)
@@ -183,19 +210,19 @@ func (seg *Segment) Get(offset uint64) (key, value []byte, found, tombstone bool
 	key = keyBuf
 
 	if ts == TOMBSTONE {
-		return key, []byte(""), false, true
+		return key, nil, ErrDeleted
 	}
 
 	valueBuf := make([]byte, vsize)
-	_, err = seg.file.ReadAt(valueBuf, int64(offset))
+	n, err = seg.file.ReadAt(valueBuf, int64(offset))
 
-	if err != nil {
-		return []byte(""), []byte(""), false, false
+	if err != nil && !(err == io.EOF && n == len(valueBuf)) {
+		return fail(err)
 	}
 
 	value = valueBuf
 
-	return key, value, true, false
+	return key, value, nil
 }
 
 func (seg *Segment) Size() uint64 {
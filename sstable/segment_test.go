@@ -3,17 +3,22 @@ package sstable
 import (
 	"bytes"
 	"encoding/binary"
+	"io"
+	"log/slog"
+	"math"
 	"os"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/logging"
 )
 
 func TestSegment(t *testing.T) {
 	f, err := os.CreateTemp("", "test_segment_segfile_")
 	require.NoError(t, err)
 
-	seg, err := newSegment(f)
+	seg, err := newSegment(f, logging.Discard)
 	require.NoError(t, err)
 
 	for scenario, fn := range map[string]func(
@@ -26,6 +31,71 @@ func TestSegment(t *testing.T) {
 			fn(t, seg)
 		})
 	}
+
+	t.Run("Logger", func(t *testing.T) {
+		test_segment_Logger(t)
+	})
+
+	t.Run("CorruptSize", func(t *testing.T) {
+		test_segment_CorruptSize(t)
+	})
+}
+
+func test_segment_CorruptSize(t *testing.T) {
+	f, err := os.CreateTemp("", "test_segment_corrupt_size_segfile_")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	seg, err := newSegment(f, logging.Discard)
+	require.NoError(t, err)
+	defer seg.Close()
+
+	require.NoError(t, seg.Append([]byte("a"), []byte("A"), false))
+
+	// key and value sizes whose sum wraps around to the record size.
+	sizes := make([]byte, K_SIZE+V_SIZE)
+	enc.PutUint64(sizes, math.MaxUint64)
+	enc.PutUint64(sizes[K_SIZE:], 3)
+	_, err = f.WriteAt(sizes, int64(TOMBSTONE_SIZE+KV_SIZE))
+	require.NoError(t, err)
+
+	_, _, err = seg.Get(0)
+	require.ErrorIs(t, err, ErrCorruption)
+}
+
+func test_segment_Logger(t *testing.T) {
+	f, err := os.CreateTemp("", "test_segment_logger_segfile_")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	var buf bytes.Buffer
+	seg, err := newSegment(f, slog.New(slog.NewTextHandler(&buf, nil)))
+	require.NoError(t, err)
+
+	require.NoError(t, seg.Append([]byte("a"), []byte("A"), false))
+
+	_, value, err := seg.Get(0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("A"), value)
+	require.Empty(t, buf.String())
+
+	// a record cut short by a crash is corrupted, and is logged.
+	require.NoError(t, f.Truncate(int64(seg.Size())-1))
+
+	_, _, err = seg.Get(0)
+	require.ErrorIs(t, err, ErrCorruption)
+	require.ErrorIs(t, err, io.EOF)
+	require.Contains(t, buf.String(), `level=ERROR msg="segment read failed" path=`+f.Name()+" offset=0 err=")
+
+	// so is an offset past the end.
+	_, _, err = seg.Get(seg.Size())
+	require.ErrorIs(t, err, ErrCorruption)
+
+	// a closed file fails with the I/O error.
+	seg.Close()
+	_, _, err = seg.Get(0)
+	require.ErrorIs(t, err, os.ErrClosed)
+	require.NotErrorIs(t, err, ErrCorruption)
 }
 
 func test_segment_Append(t *testing.T, seg *Segment) {
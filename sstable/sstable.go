@@ -10,6 +10,17 @@ type TombstoneType uint8
 const (
 	NO_TOMBSTONE TombstoneType = iota
 	TOMBSTONE
+	// the value holds merge operands to apply to older entries.
+	MERGE
+	// the value is prefixed with the time it expires at. Expired entries
+	// read as tombstones.
+	EXPIRING
+	// a tombstone for a key written exactly once. Compaction drops it
+	// together with the value it deletes instead of carrying it down to
+	// the oldest table.
+	SINGLE_DELETE
+	// the value is a pointer to the actual value, stored in a value log.
+	VALUE_POINTER
 )
 
 const (
@@ -21,6 +32,8 @@ const (
 
 const (
 	OFFSET_SIZE int = 8 // Byte
+	// expiration time of EXPIRING entries, in Unix nanoseconds.
+	EXPIRE_AT_SIZE int = 8 // Byte
 )
 
 type SSTable struct {
@@ -30,12 +43,22 @@ type SSTable struct {
 }
 
 func New(idxfile, segfile *os.File) (*SSTable, error) {
+	return NewWithOptions(idxfile, segfile, nil)
+}
+
+// NewWithOptions is like New but logs the read failures of the segment to
+// opts.Logger.
+func NewWithOptions(idxfile, segfile *os.File, opts *Options) (*SSTable, error) {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+
 	index, err := newIndex(idxfile)
 	if err != nil {
 		return nil, err
 	}
 
-	segment, err := newSegment(segfile)
+	segment, err := newSegment(segfile, opts.logger())
 	if err != nil {
 		return nil, err
 	}
@@ -56,17 +79,22 @@ func (sst *SSTable) Close() {
 	sst.Segment.Close()
 }
 
-func (sst *SSTable) Get(key []byte) (value []byte, found, tombstone bool) {
+// Get returns the value of key. It returns ErrNotFound when the table has
+// no record of the key, ErrDeleted when the record is a tombstone, and
+// wraps ErrCorruption or the I/O error when the record cannot be read.
+func (sst *SSTable) Get(key []byte) (value []byte, err error) {
 	sst.rwmu.RLock()
 	defer sst.rwmu.RUnlock()
 
-	offset, found := sst.Index.Get([]byte(key))
-
-	if !found {
-		return []byte(""), found, false
+	offset, err := sst.Index.Get([]byte(key))
+	if err != nil {
+		return nil, err
 	}
 
-	_, value, found, tombstone = sst.Segment.Get(offset)
+	_, value, err = sst.Segment.Get(offset)
+	if err != nil {
+		return nil, err
+	}
 
-	return value, found, tombstone
+	return value, nil
 }
@@ -96,38 +96,33 @@ func TestSSTable(t *testing.T) {
 func test_Get(t *testing.T, sst *SSTable) {
 
 	{
-		value, found, tombstone := sst.Get([]byte("a"))
+		value, err := sst.Get([]byte("a"))
+		require.NoError(t, err)
 		require.Equal(t, []byte("A"), value)
-		require.Equal(t, true, found)
-		require.Equal(t, false, tombstone)
 	}
 
 	{
-		value, found, tombstone := sst.Get([]byte("b"))
+		value, err := sst.Get([]byte("b"))
+		require.NoError(t, err)
 		require.Equal(t, []byte("BB"), value)
-		require.Equal(t, true, found)
-		require.Equal(t, false, tombstone)
 	}
 
 	{
-		value, found, tombstone := sst.Get([]byte("d"))
-		require.Equal(t, []byte(""), value)
-		require.Equal(t, false, found)
-		require.Equal(t, true, tombstone)
+		value, err := sst.Get([]byte("d"))
+		require.ErrorIs(t, err, ErrDeleted)
+		require.Nil(t, value)
 	}
 
 	{
-		value, found, tombstone := sst.Get([]byte("c"))
+		value, err := sst.Get([]byte("c"))
+		require.NoError(t, err)
 		require.Equal(t, []byte("CCC"), value)
-		require.Equal(t, true, found)
-		require.Equal(t, false, tombstone)
 	}
 
 	{
-		value, found, tombstone := sst.Get([]byte("e"))
-		require.Equal(t, []byte(""), value)
-		require.Equal(t, false, found)
-		require.Equal(t, true, tombstone)
+		value, err := sst.Get([]byte("e"))
+		require.ErrorIs(t, err, ErrDeleted)
+		require.Nil(t, value)
 	}
 
 }
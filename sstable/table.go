@@ -0,0 +1,833 @@
+package sstable
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bits-and-blooms/bloom"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/clock"
+	"github.com/sosomasox/LSM-Tree-based-Storage/comparator"
+	"github.com/sosomasox/LSM-Tree-based-Storage/logging"
+	"github.com/sosomasox/LSM-Tree-based-Storage/statistics"
+	"github.com/sosomasox/LSM-Tree-based-Storage/status"
+)
+
+// A table file holds everything needed to serve reads in a single file:
+//
+//	[data block 1] ... [data block N] [filter block] [index block]
+//	[range deletion block] [meta block] [footer]
+//
+// Data blocks are a sequence of entries encoded like segment records.
+// The index block holds, for every data block, a separator key that is
+// >= every key of the block and < every key of the next block, and the
+// block's handle. The optional range deletion block holds the table's
+// range tombstones and is located through a meta property. The meta block
+// holds named properties such as the comparator name. Every block is
+// followed by a trailer holding the crc32c of its contents, which handles
+// do not count. The footer is fixed-size: it points at the filter, index
+// and meta blocks, and ends with the crc32c of those handles and the
+// magic.
+
+const (
+	BLOCK_HANDLE_SIZE  int = 16 // Byte
+	BLOCK_TRAILER_SIZE int = 4  // Byte
+	MAGIC_SIZE         int = 8  // Byte
+	FOOTER_SIZE        int = 3*BLOCK_HANDLE_SIZE + BLOCK_TRAILER_SIZE + MAGIC_SIZE
+)
+
+const (
+	TABLE_MAGIC uint64 = 0x4c534d54424c3033 // "LSMTBL03"
+	TMP_SUFFIX  string = ".tmp"
+)
+
+const (
+	// meta block property holding the comparator name.
+	PROP_COMPARATOR string = "lsm.comparator"
+	// meta block property holding the handle of the range deletion block.
+	PROP_RANGE_DEL string = "lsm.range_del"
+	// meta block properties holding the first and last keys of the
+	// entries, left out when the table has none.
+	PROP_SMALLEST_KEY string = "lsm.smallest_key"
+	PROP_LARGEST_KEY  string = "lsm.largest_key"
+)
+
+const (
+	DEFAULT_BLOCK_SIZE           int     = 4 * 1024 // Byte
+	DEFAULT_BLOOM_FALSE_POSITIVE float64 = 0.01
+)
+
+var (
+	// the table holds no value for the key.
+	ErrNotFound = fmt.Errorf("sstable: %w", status.ErrNotFound)
+	// the table holds a tombstone for the key, hiding the values of older
+	// tables.
+	ErrDeleted = fmt.Errorf("%w: deleted", ErrNotFound)
+	// a file does not decode, or is shorter than its metadata tells.
+	ErrCorruption = fmt.Errorf("sstable: %w", status.ErrCorruption)
+	ErrBadMagic   = fmt.Errorf("%w: bad table magic", ErrCorruption)
+	ErrTruncated  = fmt.Errorf("%w: truncated table", ErrCorruption)
+	// the contents of a block or the footer do not match their checksum.
+	ErrChecksumMismatch = fmt.Errorf("%w: checksum mismatch", ErrCorruption)
+	// the table was built with a different comparator than the one it is
+	// opened with.
+	ErrComparatorMismatch = errors.New("sstable: comparator mismatch")
+	// the table is read after Close, rather than missing the key.
+	ErrTableClosed = fmt.Errorf("sstable: table %w", status.ErrClosed)
+)
+
+type Options struct {
+	// approximate size of uncompressed user data packed per block.
+	BlockSize int
+	// false positive rate of the bloom filter stored in each table.
+	BloomFalsePositiveRate float64
+	// serve reads from a read-only memory mapping of the table file
+	// instead of pread. Values returned by Get then alias the mapping and
+	// are only valid until the table is closed.
+	UseMmap bool
+	// cache of data blocks shared by every table opened with it. Tables
+	// read through pread bypass the cache when it is nil; mmap tables
+	// never use it.
+	BlockCache *Cache
+	// maximum number of tables a TableCache keeps open.
+	MaxOpenFiles int
+	// order of keys in the table; defaults to comparator.Bytewise.
+	Comparator comparator.Comparator
+	// tells whether EXPIRING entries have expired; defaults to
+	// clock.System.
+	Clock clock.Clock
+	// counts block reads and block cache hits; nil counts nothing.
+	Statistics *statistics.Statistics
+	// receives records of tables written, opened and found corrupted;
+	// defaults to logging.Discard.
+	Logger *slog.Logger
+}
+
+func DefaultOptions() *Options {
+	return &Options{
+		BlockSize:              DEFAULT_BLOCK_SIZE,
+		BloomFalsePositiveRate: DEFAULT_BLOOM_FALSE_POSITIVE,
+		MaxOpenFiles:           DEFAULT_MAX_OPEN_FILES,
+		Comparator:             comparator.Bytewise,
+	}
+}
+
+func (opts *Options) comparator() comparator.Comparator {
+	return comparator.OrDefault(opts.Comparator)
+}
+
+func (opts *Options) now() time.Time {
+	return clock.OrDefault(opts.Clock).Now()
+}
+
+func (opts *Options) logger() *slog.Logger {
+	return logging.OrDefault(opts.Logger)
+}
+
+type ReadOptions struct {
+	// populate the block cache with blocks read from disk. Large scans
+	// should disable it to avoid evicting the hot set.
+	FillCache bool
+}
+
+func DefaultReadOptions() *ReadOptions {
+	return &ReadOptions{
+		FillCache: true,
+	}
+}
+
+// nextTableID hands out process-unique table IDs used as block cache keys.
+var nextTableID atomic.Uint64
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// filterHash returns the 64-bit FNV-1a hash of a filter key. Bloom filters
+// hold the hashes of the keys rather than the keys, so that builders only
+// keep the hashes until Finish.
+func filterHash(fk []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(fk)
+
+	return h.Sum64()
+}
+
+func filterHashBytes(h uint64) []byte {
+	buf := make([]byte, 8)
+	enc.PutUint64(buf, h)
+
+	return buf
+}
+
+type blockHandle struct {
+	offset uint64
+	size   uint64
+}
+
+type indexEntry struct {
+	// last key of the data block.
+	key    []byte
+	handle blockHandle
+}
+
+// Table is a read-only view of a table file written by Builder.
+type Table struct {
+	rwmu sync.RWMutex
+	id   uint64
+	file *os.File
+	size uint64
+	// the memory mapped file, or nil when reading with pread.
+	data   []byte
+	opts   *Options
+	index  []indexEntry
+	filter *bloom.BloomFilter
+	props  map[string][]byte
+	// range tombstones, applying to older tables.
+	rangeDels []RangeTombstone
+	logger    *slog.Logger
+	closed    bool
+}
+
+func OpenTable(path string, opts *Options) (*Table, error) {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, status.IO(err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, status.IO(err)
+	}
+
+	logger := opts.logger().With(logging.KEY_PATH, path)
+
+	if fi.Size() < int64(FOOTER_SIZE) {
+		f.Close()
+		logger.Error("table corrupted", "size", fi.Size(), logging.KEY_ERROR, ErrTruncated)
+		return nil, ErrTruncated
+	}
+
+	tbl := &Table{
+		id:     nextTableID.Add(1),
+		file:   f,
+		size:   uint64(fi.Size()),
+		opts:   opts,
+		logger: logger,
+	}
+
+	if opts.UseMmap {
+		data, err := mmapFile(f, int(fi.Size()))
+		if err != nil {
+			f.Close()
+			return nil, status.IO(err)
+		}
+		tbl.data = data
+	}
+
+	if err := tbl.readMeta(); err != nil {
+		tbl.Close()
+		if errors.Is(err, ErrComparatorMismatch) {
+			logger.Warn("table rejected", logging.KEY_ERROR, err)
+		} else {
+			logger.Error("table corrupted", logging.KEY_ERROR, err)
+		}
+		return nil, err
+	}
+	logger.Debug("table opened", "size", tbl.size, "blocks", len(tbl.index))
+
+	return tbl, nil
+}
+
+func (tbl *Table) readMeta() error {
+	footer, err := tbl.read(tbl.size-uint64(FOOTER_SIZE), uint64(FOOTER_SIZE))
+	if err != nil {
+		return err
+	}
+
+	if enc.Uint64(footer[FOOTER_SIZE-MAGIC_SIZE:]) != TABLE_MAGIC {
+		return ErrBadMagic
+	}
+
+	handles := footer[:3*BLOCK_HANDLE_SIZE]
+	if crc32.Checksum(handles, crcTable) != enc.Uint32(footer[3*BLOCK_HANDLE_SIZE:]) {
+		return fmt.Errorf("%w: footer of %s", ErrChecksumMismatch, tbl.file.Name())
+	}
+
+	filterHandle := blockHandle{offset: enc.Uint64(footer[0:]), size: enc.Uint64(footer[8:])}
+	indexHandle := blockHandle{offset: enc.Uint64(footer[16:]), size: enc.Uint64(footer[24:])}
+	metaHandle := blockHandle{offset: enc.Uint64(footer[32:]), size: enc.Uint64(footer[40:])}
+
+	// read meta block
+	{
+		buf, err := tbl.readBlock(metaHandle)
+		if err != nil {
+			return err
+		}
+
+		props, err := decodeProperties(buf)
+		if err != nil {
+			return err
+		}
+		tbl.props = props
+
+		if name := tbl.opts.comparator().Name(); string(props[PROP_COMPARATOR]) != name {
+			return fmt.Errorf("%w: table uses %q, opened with %q", ErrComparatorMismatch, props[PROP_COMPARATOR], name)
+		}
+	}
+
+	// read range deletion block
+	if v, ok := tbl.props[PROP_RANGE_DEL]; ok {
+		if len(v) != BLOCK_HANDLE_SIZE {
+			return ErrTruncated
+		}
+
+		buf, err := tbl.readBlock(blockHandle{offset: enc.Uint64(v[0:]), size: enc.Uint64(v[8:])})
+		if err != nil {
+			return err
+		}
+
+		rangeDels, err := decodeRangeTombstones(buf)
+		if err != nil {
+			return err
+		}
+		tbl.rangeDels = rangeDels
+	}
+
+	// read filter block
+	{
+		buf, err := tbl.readBlock(filterHandle)
+		if err != nil {
+			return err
+		}
+
+		filter := &bloom.BloomFilter{}
+		if _, err := filter.ReadFrom(bytes.NewReader(buf)); err != nil {
+			return fmt.Errorf("%w: filter block: %w", ErrCorruption, err)
+		}
+		tbl.filter = filter
+	}
+
+	// read index block
+	{
+		buf, err := tbl.readBlock(indexHandle)
+		if err != nil {
+			return err
+		}
+
+		for len(buf) > 0 {
+			if len(buf) < K_SIZE {
+				return ErrTruncated
+			}
+			ksize := enc.Uint64(buf)
+			buf = buf[K_SIZE:]
+
+			if rem := uint64(len(buf)); ksize > rem || uint64(BLOCK_HANDLE_SIZE) > rem-ksize {
+				return ErrTruncated
+			}
+			key := buf[:ksize]
+			buf = buf[ksize:]
+
+			tbl.index = append(tbl.index, indexEntry{
+				key: key,
+				handle: blockHandle{
+					offset: enc.Uint64(buf[0:]),
+					size:   enc.Uint64(buf[8:]),
+				},
+			})
+			buf = buf[BLOCK_HANDLE_SIZE:]
+		}
+	}
+
+	return nil
+}
+
+// readBlock returns the contents of the block once checked against its
+// trailer. With mmap enabled the result aliases the mapping.
+func (tbl *Table) readBlock(handle blockHandle) ([]byte, error) {
+	if handle.size > tbl.size {
+		return nil, ErrTruncated
+	}
+
+	buf, err := tbl.read(handle.offset, handle.size+uint64(BLOCK_TRAILER_SIZE))
+	if err != nil {
+		return nil, err
+	}
+
+	block := buf[:handle.size:handle.size]
+	if crc32.Checksum(block, crcTable) != enc.Uint32(buf[handle.size:]) {
+		return nil, fmt.Errorf("%w: block of %s at %d", ErrChecksumMismatch, tbl.file.Name(), handle.offset)
+	}
+
+	return block, nil
+}
+
+// read returns size bytes of the file at offset. With mmap enabled the
+// result aliases the mapping.
+func (tbl *Table) read(offset, size uint64) ([]byte, error) {
+	if offset > tbl.size || size > tbl.size-offset {
+		return nil, ErrTruncated
+	}
+
+	if tbl.data != nil {
+		return tbl.data[offset : offset+size : offset+size], nil
+	}
+
+	buf := make([]byte, size)
+	if _, err := tbl.file.ReadAt(buf, int64(offset)); err != nil {
+		return nil, readError(err, "block of %s at %d", tbl.file.Name(), offset)
+	}
+
+	return buf, nil
+}
+
+// readError wraps an error of reading from a file. Reads cut short mean
+// that the file is shorter than the offsets and sizes recorded in it;
+// other failures are I/O errors.
+func readError(err error, format string, args ...interface{}) error {
+	what := fmt.Sprintf(format, args...)
+
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: %s cut short: %w", ErrCorruption, what, err)
+	}
+
+	return fmt.Errorf("sstable: read %s: %w", what, status.IO(err))
+}
+
+// readDataBlock reads a data block through the block cache. cached
+// reports whether the block is shared with the cache and must be copied
+// before being handed to callers.
+func (tbl *Table) readDataBlock(handle blockHandle, ro *ReadOptions) (block []byte, cached bool, err error) {
+	stats := tbl.opts.Statistics
+
+	cache := tbl.opts.BlockCache
+	if cache == nil || tbl.data != nil {
+		block, err = tbl.readBlock(handle)
+		if err == nil {
+			stats.Add(statistics.BLOCK_READS, 1)
+			stats.Add(statistics.BLOCK_BYTES_READ, uint64(len(block)))
+		}
+		return block, false, err
+	}
+
+	if block, found := cache.Get(tbl.id, handle.offset); found {
+		stats.Add(statistics.BLOCK_CACHE_HITS, 1)
+		return block, true, nil
+	}
+	stats.Add(statistics.BLOCK_CACHE_MISSES, 1)
+
+	block, err = tbl.readBlock(handle)
+	if err != nil {
+		return nil, false, err
+	}
+	stats.Add(statistics.BLOCK_READS, 1)
+	stats.Add(statistics.BLOCK_BYTES_READ, uint64(len(block)))
+
+	if ro.FillCache {
+		cache.Set(tbl.id, handle.offset, block)
+		return block, true, nil
+	}
+
+	return block, false, nil
+}
+
+// ID returns the process-unique ID of the table.
+func (tbl *Table) ID() uint64 {
+	return tbl.id
+}
+
+func (tbl *Table) Close() {
+	tbl.rwmu.Lock()
+	defer tbl.rwmu.Unlock()
+
+	if tbl.data != nil {
+		munmap(tbl.data)
+		tbl.data = nil
+	}
+
+	tbl.file.Close()
+	tbl.index = nil
+	tbl.filter = nil
+	tbl.props = nil
+	tbl.closed = true
+
+	// the blocks can no longer be read once the table is closed.
+	if tbl.opts.BlockCache != nil {
+		tbl.opts.BlockCache.EraseTable(tbl.id)
+	}
+}
+
+// Property returns the value of a meta block property.
+func (tbl *Table) Property(name string) (value []byte, found bool) {
+	tbl.rwmu.RLock()
+	defer tbl.rwmu.RUnlock()
+
+	value, found = tbl.props[name]
+
+	return value, found
+}
+
+// RangeTombstones returns the range tombstones of the table. They cover
+// entries of older tables only.
+func (tbl *Table) RangeTombstones() []RangeTombstone {
+	tbl.rwmu.RLock()
+	defer tbl.rwmu.RUnlock()
+
+	return tbl.rangeDels
+}
+
+// Get looks up key in the table. It returns ErrDeleted when the key is
+// deleted or expired, and ErrNotFound when the table has no value for it
+// or holds merge operands or a value log pointer, which only the store can
+// resolve. When the table was opened with UseMmap the returned value is a
+// zero-copy slice of the mapping and must not be used after Close.
+func (tbl *Table) Get(key []byte) (value []byte, err error) {
+	return tbl.GetWithOptions(key, DefaultReadOptions())
+}
+
+func (tbl *Table) GetWithOptions(key []byte, ro *ReadOptions) (value []byte, err error) {
+	value, t, err := tbl.GetEntry(key, ro)
+	if err != nil {
+		return nil, err
+	}
+
+	switch t {
+	case TOMBSTONE, SINGLE_DELETE:
+		return nil, ErrDeleted
+	case MERGE, VALUE_POINTER:
+		return nil, ErrNotFound
+	case EXPIRING:
+		value, expired, err := tbl.decodeExpiring(value)
+		if err != nil {
+			tbl.logger.Error("table entry corrupted", logging.KEY_ERROR, err)
+			return nil, err
+		}
+		if expired {
+			return nil, ErrDeleted
+		}
+
+		return value, nil
+	}
+
+	return value, nil
+}
+
+// decodeExpiring strips the expiration time off an EXPIRING value and
+// reports whether it has passed.
+func (tbl *Table) decodeExpiring(buf []byte) (value []byte, expired bool, err error) {
+	value, expireAt, err := DecodeExpiring(buf)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return value, Expired(expireAt, tbl.opts.now()), nil
+}
+
+// GetEntry returns the raw entry stored for key along with its type, or
+// ErrNotFound. For MERGE entries the value holds the encoded operands and
+// for EXPIRING entries the value is prefixed with the expiration time.
+func (tbl *Table) GetEntry(key []byte, ro *ReadOptions) (value []byte, t TombstoneType, err error) {
+	tbl.rwmu.RLock()
+	defer tbl.rwmu.RUnlock()
+
+	if tbl.closed {
+		return nil, NO_TOMBSTONE, ErrTableClosed
+	}
+
+	if ro == nil {
+		ro = DefaultReadOptions()
+	}
+
+	cmp := tbl.opts.comparator()
+
+	if fk, ok := comparator.FilterKey(cmp, key); ok && tbl.filter != nil && !tbl.filter.Test(filterHashBytes(filterHash(fk))) {
+		return nil, NO_TOMBSTONE, ErrNotFound
+	}
+
+	// find the first block whose separator is >= key.
+	i := sort.Search(len(tbl.index), func(i int) bool {
+		return cmp.Compare(tbl.index[i].key, key) >= 0
+	})
+
+	if i == len(tbl.index) {
+		return nil, NO_TOMBSTONE, ErrNotFound
+	}
+
+	block, cached, err := tbl.readDataBlock(tbl.index[i].handle, ro)
+	if err != nil {
+		tbl.logger.Error("table read failed", logging.KEY_OFFSET, tbl.index[i].handle.offset, logging.KEY_ERROR, err)
+		return nil, NO_TOMBSTONE, err
+	}
+
+	for len(block) > 0 {
+		k, v, t, n, err := decodeEntry(block)
+		if err != nil {
+			tbl.logger.Error("table block corrupted", logging.KEY_OFFSET, tbl.index[i].handle.offset, logging.KEY_ERROR, err)
+			return nil, NO_TOMBSTONE, fmt.Errorf("%w: block of %s at %d", err, tbl.file.Name(), tbl.index[i].handle.offset)
+		}
+		block = block[n:]
+
+		switch c := cmp.Compare(k, key); {
+		case c < 0:
+			continue
+		case c > 0:
+			return nil, NO_TOMBSTONE, ErrNotFound
+		}
+
+		if cached {
+			v = append([]byte(nil), v...)
+		}
+
+		return v, t, nil
+	}
+
+	return nil, NO_TOMBSTONE, ErrNotFound
+}
+
+// TableIterator walks the entries of a table in key order.
+type TableIterator struct {
+	tbl    *Table
+	ro     *ReadOptions
+	next   int
+	block  []byte
+	cached bool
+	err    error
+	// releases the table reference taken by TableCache.NewIterator.
+	release func()
+}
+
+func (tbl *Table) NewIterator(ro *ReadOptions) *TableIterator {
+	if ro == nil {
+		ro = DefaultReadOptions()
+	}
+
+	return &TableIterator{tbl: tbl, ro: ro}
+}
+
+func (itr *TableIterator) HasNext() bool {
+	itr.tbl.rwmu.RLock()
+	defer itr.tbl.rwmu.RUnlock()
+
+	for len(itr.block) == 0 {
+		if itr.err == nil && itr.tbl.closed {
+			itr.err = ErrTableClosed
+		}
+		if itr.err != nil || itr.next >= len(itr.tbl.index) {
+			return false
+		}
+
+		itr.block, itr.cached, itr.err = itr.tbl.readDataBlock(itr.tbl.index[itr.next].handle, itr.ro)
+		itr.next += 1
+	}
+
+	return true
+}
+
+// Seek moves the iterator to the first entry whose key is >= key. Only the
+// data block that may hold it is read, found by a search of the index.
+func (itr *TableIterator) Seek(key []byte) {
+	itr.tbl.rwmu.RLock()
+	cmp := itr.tbl.opts.comparator()
+	itr.next = sort.Search(len(itr.tbl.index), func(i int) bool {
+		return cmp.Compare(itr.tbl.index[i].key, key) >= 0
+	})
+	itr.block = nil
+	itr.tbl.rwmu.RUnlock()
+
+	for itr.HasNext() {
+		k, _, _, n, err := decodeEntry(itr.block)
+		if err != nil {
+			itr.err = err
+			itr.block = nil
+			return
+		}
+
+		if cmp.Compare(k, key) >= 0 {
+			return
+		}
+		itr.block = itr.block[n:]
+	}
+}
+
+// Next returns the next entry. Expired entries are reported as
+// tombstones.
+func (itr *TableIterator) Next() (key, value []byte, tombstone bool, err error) {
+	key, value, t, err := itr.NextEntry()
+	if err != nil || t != EXPIRING {
+		return key, value, t == TOMBSTONE || t == SINGLE_DELETE, err
+	}
+
+	value, expired, err := itr.tbl.decodeExpiring(value)
+	if err != nil {
+		return []byte(""), []byte(""), false, err
+	}
+	if expired {
+		return key, []byte(""), true, nil
+	}
+
+	return key, value, false, nil
+}
+
+// NextEntry is like Next but reports the raw entry and its type, which
+// tells merge and expiring entries apart from values.
+func (itr *TableIterator) NextEntry() (key, value []byte, t TombstoneType, err error) {
+	if !itr.HasNext() {
+		if itr.err != nil {
+			return []byte(""), []byte(""), NO_TOMBSTONE, itr.err
+		}
+		return []byte(""), []byte(""), NO_TOMBSTONE, io.EOF
+	}
+
+	key, value, t, n, err := decodeEntry(itr.block)
+	if err != nil {
+		itr.err = err
+		itr.block = nil
+		return []byte(""), []byte(""), NO_TOMBSTONE, err
+	}
+	itr.block = itr.block[n:]
+
+	if itr.cached {
+		key = append([]byte(nil), key...)
+		value = append([]byte(nil), value...)
+	}
+
+	return key, value, t, nil
+}
+
+// Close releases the table reference held by the iterator, if any.
+func (itr *TableIterator) Close() {
+	if itr.release != nil {
+		itr.release()
+		itr.release = nil
+	}
+}
+
+// EncodeExpiring prefixes value with expireAt, forming the value of an
+// EXPIRING entry.
+func EncodeExpiring(value []byte, expireAt time.Time) []byte {
+	buf := make([]byte, EXPIRE_AT_SIZE, EXPIRE_AT_SIZE+len(value))
+	enc.PutUint64(buf, uint64(expireAt.UnixNano()))
+
+	return append(buf, value...)
+}
+
+// DecodeExpiring splits the value of an EXPIRING entry. The returned
+// value aliases buf.
+func DecodeExpiring(buf []byte) (value []byte, expireAt time.Time, err error) {
+	if len(buf) < EXPIRE_AT_SIZE {
+		return nil, time.Time{}, ErrTruncated
+	}
+
+	return buf[EXPIRE_AT_SIZE:], time.Unix(0, int64(enc.Uint64(buf))), nil
+}
+
+// Expired reports whether an entry expiring at expireAt has expired at
+// now.
+func Expired(expireAt, now time.Time) bool {
+	return !now.Before(expireAt)
+}
+
+// encodeEntry appends an entry to buf using the segment record layout.
+func encodeEntry(buf *bytes.Buffer, key, value []byte, t TombstoneType) {
+	if t == TOMBSTONE {
+		value = nil
+	}
+	buf.WriteByte(byte(t))
+
+	var hdr [KV_SIZE + K_SIZE + V_SIZE]byte
+	enc.PutUint64(hdr[0:], uint64(len(key)+len(value)))
+	enc.PutUint64(hdr[KV_SIZE:], uint64(len(key)))
+	enc.PutUint64(hdr[KV_SIZE+K_SIZE:], uint64(len(value)))
+	buf.Write(hdr[:])
+
+	buf.Write(key)
+	buf.Write(value)
+}
+
+// decodeEntry decodes the entry at the head of buf and reports how many
+// bytes it occupied. The returned key and value alias buf.
+func decodeEntry(buf []byte) (key, value []byte, t TombstoneType, n int, err error) {
+	hdrSize := TOMBSTONE_SIZE + KV_SIZE + K_SIZE + V_SIZE
+	if len(buf) < hdrSize {
+		return nil, nil, NO_TOMBSTONE, 0, ErrTruncated
+	}
+
+	t = TombstoneType(buf[0])
+	ksize := enc.Uint64(buf[TOMBSTONE_SIZE+KV_SIZE:])
+	vsize := enc.Uint64(buf[TOMBSTONE_SIZE+KV_SIZE+K_SIZE:])
+
+	// sizes are checked one at a time, as a corrupt header may make
+	// their sum overflow.
+	if rem := uint64(len(buf) - hdrSize); ksize > rem || vsize > rem-ksize {
+		return nil, nil, NO_TOMBSTONE, 0, ErrTruncated
+	}
+
+	key = buf[hdrSize : hdrSize+int(ksize)]
+	value = buf[hdrSize+int(ksize) : hdrSize+int(ksize+vsize)]
+
+	return key, value, t, hdrSize + int(ksize+vsize), nil
+}
+
+// encodeProperties encodes props as a sequence of ksize, key, vsize,
+// value records sorted by key.
+func encodeProperties(props map[string][]byte) []byte {
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		var size [K_SIZE]byte
+
+		enc.PutUint64(size[:], uint64(len(name)))
+		buf.Write(size[:])
+		buf.WriteString(name)
+
+		enc.PutUint64(size[:], uint64(len(props[name])))
+		buf.Write(size[:])
+		buf.Write(props[name])
+	}
+
+	return buf.Bytes()
+}
+
+func decodeProperties(buf []byte) (map[string][]byte, error) {
+	props := make(map[string][]byte)
+
+	for len(buf) > 0 {
+		var fields [2][]byte
+
+		for i := range fields {
+			if len(buf) < K_SIZE {
+				return nil, ErrTruncated
+			}
+			size := enc.Uint64(buf)
+			buf = buf[K_SIZE:]
+
+			if uint64(len(buf)) < size {
+				return nil, ErrTruncated
+			}
+			fields[i] = buf[:size]
+			buf = buf[size:]
+		}
+
+		props[string(fields[0])] = fields[1]
+	}
+
+	return props, nil
+}
@@ -0,0 +1,270 @@
+package sstable
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/status"
+)
+
+const (
+	DEFAULT_MAX_OPEN_FILES int = 1000
+)
+
+var (
+	ErrTableCacheClosed = fmt.Errorf("sstable: table cache %w", status.ErrClosed)
+)
+
+// TableHandle is a reference to a table held open by a TableCache. The
+// table stays open until every handle is released, even if the cache has
+// evicted it in the meantime.
+type TableHandle struct {
+	tc   *TableCache
+	path string
+	tbl  *Table
+	// one reference is held by the cache while the table is in the LRU.
+	refs atomic.Int32
+	// set on every hit so that eviction gives the table a second chance.
+	used atomic.Bool
+	elem *list.Element
+}
+
+func (h *TableHandle) Table() *Table {
+	return h.tbl
+}
+
+func (h *TableHandle) Release() {
+	h.unref()
+}
+
+func (h *TableHandle) unref() {
+	if h.refs.Add(-1) == 0 {
+		h.tbl.Close()
+	}
+}
+
+// openCall lets concurrent Acquires of the same path wait for a single
+// OpenTable instead of opening the file once each.
+type openCall struct {
+	done chan struct{}
+	err  error
+}
+
+// TableCache opens tables lazily and keeps at most Options.MaxOpenFiles of
+// them open, closing the least recently used once they are released.
+// Tables are opened and closed outside the cache lock, and hits only take
+// the read lock.
+type TableCache struct {
+	rwmu    sync.RWMutex
+	opts    *Options
+	lru     *list.List
+	tables  map[string]*TableHandle
+	opening map[string]*openCall
+	closed  bool
+}
+
+func NewTableCache(opts *Options) *TableCache {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+
+	return &TableCache{
+		opts:    opts,
+		lru:     list.New(),
+		tables:  make(map[string]*TableHandle),
+		opening: make(map[string]*openCall),
+	}
+}
+
+func (tc *TableCache) maxOpenFiles() int {
+	if tc.opts.MaxOpenFiles <= 0 {
+		return DEFAULT_MAX_OPEN_FILES
+	}
+
+	return tc.opts.MaxOpenFiles
+}
+
+// lookup returns a new reference to the cached table at path, or nil. The
+// cache lock must be held; the cache's own reference keeps refs above zero.
+func (tc *TableCache) lookup(path string) *TableHandle {
+	h, found := tc.tables[path]
+	if !found {
+		return nil
+	}
+
+	h.refs.Add(1)
+	h.used.Store(true)
+
+	return h
+}
+
+// Acquire returns a handle to the table at path, opening it if needed.
+// The caller must Release the handle when done.
+func (tc *TableCache) Acquire(path string) (*TableHandle, error) {
+	for {
+		tc.rwmu.RLock()
+		if tc.closed {
+			tc.rwmu.RUnlock()
+			return nil, ErrTableCacheClosed
+		}
+		h := tc.lookup(path)
+		tc.rwmu.RUnlock()
+
+		if h != nil {
+			return h, nil
+		}
+
+		tc.rwmu.Lock()
+		if tc.closed {
+			tc.rwmu.Unlock()
+			return nil, ErrTableCacheClosed
+		}
+
+		if h := tc.lookup(path); h != nil {
+			tc.rwmu.Unlock()
+			return h, nil
+		}
+
+		if call, found := tc.opening[path]; found {
+			tc.rwmu.Unlock()
+
+			<-call.done
+			if call.err != nil {
+				return nil, call.err
+			}
+
+			// the table is in the cache now, unless it was evicted again.
+			continue
+		}
+
+		call := &openCall{done: make(chan struct{})}
+		tc.opening[path] = call
+		tc.rwmu.Unlock()
+
+		return tc.open(path, call)
+	}
+}
+
+// open opens the table at path on behalf of call and adds it to the cache.
+func (tc *TableCache) open(path string, call *openCall) (*TableHandle, error) {
+	tbl, err := OpenTable(path, tc.opts)
+
+	tc.rwmu.Lock()
+	delete(tc.opening, path)
+	if err == nil && tc.closed {
+		err = ErrTableCacheClosed
+	}
+	call.err = err
+	close(call.done)
+
+	if err != nil {
+		tc.rwmu.Unlock()
+
+		if tbl != nil {
+			tbl.Close()
+		}
+
+		return nil, err
+	}
+
+	h := &TableHandle{
+		tc:   tc,
+		path: path,
+		tbl:  tbl,
+	}
+	h.refs.Store(2)
+	h.used.Store(true)
+	h.elem = tc.lru.PushFront(h)
+	tc.tables[path] = h
+
+	evicted := tc.evict()
+	tc.rwmu.Unlock()
+
+	for _, h := range evicted {
+		h.unref()
+	}
+
+	return h, nil
+}
+
+// evict removes tables from the cache until at most MaxOpenFiles remain,
+// giving tables used since the last pass a second chance. It must be called
+// with the cache lock held, and the caller unrefs the returned handles once
+// the lock is released.
+func (tc *TableCache) evict() []*TableHandle {
+	var evicted []*TableHandle
+	for tc.lru.Len() > tc.maxOpenFiles() {
+		h := tc.lru.Back().Value.(*TableHandle)
+		if h.used.Swap(false) {
+			tc.lru.MoveToFront(h.elem)
+			continue
+		}
+
+		tc.remove(h)
+		evicted = append(evicted, h)
+	}
+
+	return evicted
+}
+
+// NewIterator returns an iterator over the table at path which holds a
+// reference to the table until the iterator is closed.
+func (tc *TableCache) NewIterator(path string, ro *ReadOptions) (*TableIterator, error) {
+	h, err := tc.Acquire(path)
+	if err != nil {
+		return nil, err
+	}
+
+	itr := h.Table().NewIterator(ro)
+	itr.release = h.Release
+
+	return itr, nil
+}
+
+// Evict drops the table at path from the cache, e.g. after its file has
+// been deleted. Outstanding handles keep working until released.
+func (tc *TableCache) Evict(path string) {
+	tc.rwmu.Lock()
+	h, found := tc.tables[path]
+	if found {
+		tc.remove(h)
+	}
+	tc.rwmu.Unlock()
+
+	if found {
+		h.unref()
+	}
+}
+
+// remove must be called with the cache lock held. The caller drops the
+// cache's reference with unref after unlocking.
+func (tc *TableCache) remove(h *TableHandle) {
+	tc.lru.Remove(h.elem)
+	delete(tc.tables, h.path)
+}
+
+// Len returns the number of tables held by the cache.
+func (tc *TableCache) Len() int {
+	tc.rwmu.RLock()
+	defer tc.rwmu.RUnlock()
+
+	return tc.lru.Len()
+}
+
+func (tc *TableCache) Close() {
+	tc.rwmu.Lock()
+	var removed []*TableHandle
+	for tc.lru.Len() > 0 {
+		h := tc.lru.Back().Value.(*TableHandle)
+		tc.remove(h)
+		removed = append(removed, h)
+	}
+	tc.closed = true
+	tc.rwmu.Unlock()
+
+	for _, h := range removed {
+		h.unref()
+	}
+}
@@ -0,0 +1,185 @@
+package sstable
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTableCache(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test_table_cache_")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var paths []string
+	for i := 0; i < 3; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("%06d.sst", i))
+
+		b, err := NewBuilder(path, nil)
+		require.NoError(t, err)
+		require.NoError(t, b.Add([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)), false))
+		require.NoError(t, b.Finish())
+
+		paths = append(paths, path)
+	}
+
+	for scenario, fn := range map[string]func(
+		t *testing.T, tc *TableCache, paths []string,
+	){
+		"Acquire":    test_table_cache_Acquire,
+		"BlockCache": test_table_cache_BlockCache,
+		"Concurrent": test_table_cache_Concurrent,
+		"Evict":      test_table_cache_Evict,
+		"Iterator":   test_table_cache_Iterator,
+	} {
+		fn := fn // https://github.com/golang/go/wiki/CommonMistakes
+		t.Run(scenario, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.MaxOpenFiles = 2
+
+			tc := NewTableCache(opts)
+			defer tc.Close()
+
+			fn(t, tc, paths)
+		})
+	}
+}
+
+func test_table_cache_Acquire(t *testing.T, tc *TableCache, paths []string) {
+	h0, err := tc.Acquire(paths[0])
+	require.NoError(t, err)
+
+	// acquiring an open table shares it.
+	{
+		h, err := tc.Acquire(paths[0])
+		require.NoError(t, err)
+		require.Same(t, h0, h)
+		h.Release()
+	}
+
+	h0.Release()
+
+	for _, path := range paths[1:] {
+		h, err := tc.Acquire(path)
+		require.NoError(t, err)
+		h.Release()
+	}
+
+	// the least recently used table was closed.
+	require.Equal(t, 2, tc.Len())
+	require.Nil(t, h0.Table().index)
+
+	// and is reopened transparently.
+	h, err := tc.Acquire(paths[0])
+	require.NoError(t, err)
+	defer h.Release()
+
+	value, err := h.Table().Get([]byte("key0"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value0"), value)
+}
+
+func test_table_cache_BlockCache(t *testing.T, tc *TableCache, paths []string) {
+	tc.opts.BlockCache = NewCache(1 << 20)
+
+	h, err := tc.Acquire(paths[0])
+	require.NoError(t, err)
+	_, err = h.Table().Get([]byte("key0"))
+	require.NoError(t, err)
+	require.Greater(t, tc.opts.BlockCache.Size(), int64(0))
+
+	// blocks of a table dropped from the cache, e.g. once deleted, go
+	// when its last handle is released.
+	tc.Evict(paths[0])
+	require.Greater(t, tc.opts.BlockCache.Size(), int64(0))
+
+	h.Release()
+	require.Equal(t, int64(0), tc.opts.BlockCache.Size())
+}
+
+func test_table_cache_Concurrent(t *testing.T, tc *TableCache, paths []string) {
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+
+			for i := 0; i < 200; i++ {
+				n := (g + i) % len(paths)
+				h, err := tc.Acquire(paths[n])
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				value, err := h.Table().Get([]byte(fmt.Sprintf("key%d", n)))
+				h.Release()
+				if err != nil {
+					errs <- err
+					return
+				}
+				if want := fmt.Sprintf("value%d", n); string(value) != want {
+					errs <- fmt.Errorf("got %q, want %q", value, want)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	require.LessOrEqual(t, tc.Len(), 2)
+	require.Empty(t, tc.opening)
+}
+
+func test_table_cache_Evict(t *testing.T, tc *TableCache, paths []string) {
+	h0, err := tc.Acquire(paths[0])
+	require.NoError(t, err)
+
+	for _, path := range paths[1:] {
+		h, err := tc.Acquire(path)
+		require.NoError(t, err)
+		h.Release()
+	}
+
+	// evicted by LRU but still referenced, so it must stay readable.
+	require.Equal(t, 2, tc.Len())
+	value, err := h0.Table().Get([]byte("key0"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value0"), value)
+
+	h0.Release()
+	require.Nil(t, h0.Table().index)
+
+	tc.Evict(paths[2])
+	require.Equal(t, 1, tc.Len())
+}
+
+func test_table_cache_Iterator(t *testing.T, tc *TableCache, paths []string) {
+	itr, err := tc.NewIterator(paths[0], nil)
+	require.NoError(t, err)
+
+	tc.Evict(paths[0])
+	require.Equal(t, 0, tc.Len())
+
+	require.Equal(t, true, itr.HasNext())
+	key, value, _, err := itr.Next()
+	require.NoError(t, err)
+	require.Equal(t, []byte("key0"), key)
+	require.Equal(t, []byte("value0"), value)
+
+	itr.Close()
+	require.Nil(t, itr.tbl.index)
+
+	tc.Close()
+	_, err = tc.Acquire(paths[0])
+	require.ErrorIs(t, err, ErrTableCacheClosed)
+}
@@ -0,0 +1,542 @@
+package sstable
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/clock"
+	"github.com/sosomasox/LSM-Tree-based-Storage/statistics"
+	"github.com/sosomasox/LSM-Tree-based-Storage/status"
+)
+
+func TestTable(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test_table_")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "000001.sst")
+
+	// use a tiny block size so that the entries span several blocks.
+	opts := DefaultOptions()
+	opts.BlockSize = 32
+
+	b, err := NewBuilder(path, opts)
+	require.NoError(t, err)
+
+	require.NoError(t, b.Add([]byte("a"), []byte("A"), false))
+	require.NoError(t, b.Add([]byte("b"), []byte("BB"), false))
+	require.NoError(t, b.Add([]byte("c"), []byte("CCC"), false))
+	require.NoError(t, b.Add([]byte("d"), []byte(""), true))
+	require.NoError(t, b.Add([]byte("e"), []byte(""), true))
+
+	require.NoError(t, b.Finish())
+
+	for scenario, fn := range map[string]func(
+		t *testing.T, tbl *Table,
+	){
+		"Get":      test_table_Get,
+		"Get/Mmap": test_table_Get,
+	} {
+		fn := fn // https://github.com/golang/go/wiki/CommonMistakes
+		scenario := scenario
+		t.Run(scenario, func(t *testing.T) {
+			opts := *opts
+			opts.UseMmap = strings.HasSuffix(scenario, "/Mmap")
+
+			tbl, err := OpenTable(path, &opts)
+			require.NoError(t, err)
+			defer tbl.Close()
+
+			require.Greater(t, len(tbl.index), 1)
+			require.Equal(t, opts.UseMmap, tbl.data != nil)
+
+			fn(t, tbl)
+		})
+	}
+
+	t.Run("Iterator", func(t *testing.T) {
+		tbl, err := OpenTable(path, opts)
+		require.NoError(t, err)
+		defer tbl.Close()
+
+		test_table_Iterator(t, tbl)
+	})
+
+	t.Run("Seek", func(t *testing.T) {
+		opts := *opts
+		opts.Statistics = statistics.New()
+
+		tbl, err := OpenTable(path, &opts)
+		require.NoError(t, err)
+		defer tbl.Close()
+
+		test_table_Seek(t, tbl)
+	})
+
+	t.Run("BlockCache", func(t *testing.T) {
+		opts := *opts
+		opts.BlockCache = NewCache(1 << 20)
+		opts.Statistics = statistics.New()
+
+		tbl, err := OpenTable(path, &opts)
+		require.NoError(t, err)
+		defer tbl.Close()
+
+		// a scan that does not fill the cache leaves it empty.
+		itr := tbl.NewIterator(&ReadOptions{FillCache: false})
+		for itr.HasNext() {
+			_, _, _, err := itr.Next()
+			require.NoError(t, err)
+		}
+		require.Equal(t, int64(0), opts.BlockCache.Size())
+
+		test_table_Get(t, tbl)
+		misses := opts.BlockCache.Misses()
+		require.Greater(t, opts.BlockCache.Size(), int64(0))
+
+		test_table_Get(t, tbl)
+		require.Equal(t, misses, opts.BlockCache.Misses())
+		require.Greater(t, opts.BlockCache.Hits(), uint64(0))
+
+		stats := opts.Statistics
+		require.Equal(t, opts.BlockCache.Hits(), stats.Ticker(statistics.BLOCK_CACHE_HITS))
+		require.Equal(t, opts.BlockCache.Misses(), stats.Ticker(statistics.BLOCK_CACHE_MISSES))
+		require.Equal(t, stats.Ticker(statistics.BLOCK_CACHE_MISSES), stats.Ticker(statistics.BLOCK_READS))
+		require.Greater(t, stats.Ticker(statistics.BLOCK_BYTES_READ), uint64(0))
+
+		// values handed out must not alias cached blocks.
+		value, err := tbl.Get([]byte("a"))
+		require.NoError(t, err)
+		value[0] = 'X'
+		value, err = tbl.Get([]byte("a"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("A"), value)
+	})
+
+	t.Run("Closed", func(t *testing.T) {
+		tbl, err := OpenTable(path, opts)
+		require.NoError(t, err)
+
+		itr := tbl.NewIterator(nil)
+		tbl.Close()
+
+		// a closed table is not mistaken for one missing the key.
+		_, err = tbl.Get([]byte("a"))
+		require.ErrorIs(t, err, ErrTableClosed)
+		require.ErrorIs(t, err, status.ErrClosed)
+		require.NotErrorIs(t, err, ErrNotFound)
+
+		require.Equal(t, false, itr.HasNext())
+		_, _, _, err = itr.Next()
+		require.ErrorIs(t, err, ErrTableClosed)
+	})
+
+	t.Run("Expiring", func(t *testing.T) {
+		test_table_Expiring(t, dir)
+	})
+
+	t.Run("BadMagic", func(t *testing.T) {
+		bad := filepath.Join(dir, "bad.sst")
+		require.NoError(t, os.WriteFile(bad, make([]byte, FOOTER_SIZE), 0600))
+
+		_, err := OpenTable(bad, opts)
+		require.ErrorIs(t, err, ErrBadMagic)
+	})
+
+	t.Run("Logger", func(t *testing.T) {
+		test_table_Logger(t, dir)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		test_table_Errors(t, dir)
+	})
+
+	t.Run("CorruptSize", func(t *testing.T) {
+		test_table_CorruptSize(t, dir)
+	})
+
+	t.Run("Checksum", func(t *testing.T) {
+		test_table_Checksum(t, dir)
+	})
+}
+
+func test_table_CorruptSize(t *testing.T, dir string) {
+	// sizes whose sum overflows.
+	var buf bytes.Buffer
+	encodeEntry(&buf, []byte("a"), []byte("A"), NO_TOMBSTONE)
+	entry := buf.Bytes()
+	enc.PutUint64(entry[TOMBSTONE_SIZE+KV_SIZE:], math.MaxUint64)
+	enc.PutUint64(entry[TOMBSTONE_SIZE+KV_SIZE+K_SIZE:], 1)
+
+	_, _, _, _, err := decodeEntry(entry)
+	require.ErrorIs(t, err, ErrCorruption)
+
+	path := filepath.Join(dir, "corrupt_size.sst")
+
+	b, err := NewBuilder(path, nil)
+	require.NoError(t, err)
+	require.NoError(t, b.Add([]byte("a"), []byte("A"), false))
+	require.NoError(t, b.Finish())
+
+	// the key size of the first entry of the first data block.
+	f, err := os.OpenFile(path, os.O_RDWR, 0600)
+	require.NoError(t, err)
+	size := make([]byte, K_SIZE)
+	enc.PutUint64(size, math.MaxUint64)
+	_, err = f.WriteAt(size, int64(TOMBSTONE_SIZE+KV_SIZE))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	tbl, err := OpenTable(path, nil)
+	require.NoError(t, err)
+	defer tbl.Close()
+
+	_, err = tbl.Get([]byte("a"))
+	require.ErrorIs(t, err, ErrCorruption)
+	require.Equal(t, status.ErrCorruption, status.Kind(err))
+
+	itr := tbl.NewIterator(nil)
+	_, _, _, err = itr.Next()
+	require.ErrorIs(t, err, ErrCorruption)
+}
+
+func test_table_Checksum(t *testing.T, dir string) {
+	path := filepath.Join(dir, "checksum.sst")
+
+	b, err := NewBuilder(path, nil)
+	require.NoError(t, err)
+	require.NoError(t, b.Add([]byte("a"), []byte("A"), false))
+	require.NoError(t, b.Finish())
+
+	flip := func(offset int64) {
+		f, err := os.OpenFile(path, os.O_RDWR, 0600)
+		require.NoError(t, err)
+		defer f.Close()
+
+		buf := make([]byte, 1)
+		_, err = f.ReadAt(buf, offset)
+		require.NoError(t, err)
+		buf[0] ^= 0x01
+		_, err = f.WriteAt(buf, offset)
+		require.NoError(t, err)
+	}
+
+	// a flipped bit of the value is not served.
+	value := int64(TOMBSTONE_SIZE + KV_SIZE + K_SIZE + V_SIZE + 1)
+	flip(value)
+
+	for _, mmap := range []bool{false, true} {
+		opts := DefaultOptions()
+		opts.UseMmap = mmap
+
+		tbl, err := OpenTable(path, opts)
+		require.NoError(t, err)
+
+		_, err = tbl.Get([]byte("a"))
+		require.ErrorIs(t, err, ErrChecksumMismatch)
+		require.ErrorIs(t, err, ErrCorruption)
+
+		tbl.Close()
+	}
+
+	// nor is a flipped bit of the footer handles.
+	flip(value)
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	flip(fi.Size() - int64(FOOTER_SIZE))
+
+	_, err = OpenTable(path, nil)
+	require.ErrorIs(t, err, ErrChecksumMismatch)
+}
+
+func test_table_Errors(t *testing.T, dir string) {
+	_, err := OpenTable(filepath.Join(dir, "missing.sst"), nil)
+	require.ErrorIs(t, err, status.ErrIO)
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	_, err = NewBuilder(filepath.Join(dir, "missing", "000001.sst"), nil)
+	require.ErrorIs(t, err, status.ErrIO)
+
+	// the directory of the table is removed before Finish.
+	sub := filepath.Join(dir, "errors")
+	require.NoError(t, os.Mkdir(sub, 0755))
+	b, err := NewBuilder(filepath.Join(sub, "000001.sst"), nil)
+	require.NoError(t, err)
+	require.NoError(t, b.Add([]byte("a"), []byte("A"), false))
+	require.NoError(t, os.RemoveAll(sub))
+
+	err = b.Finish()
+	require.ErrorIs(t, err, status.ErrIO)
+	require.Equal(t, status.ErrClosed, status.Kind(b.Finish()))
+
+	// the errors of tables read as their kinds.
+	require.Equal(t, status.ErrNotFound, status.Kind(ErrDeleted))
+	require.Equal(t, status.ErrCorruption, status.Kind(ErrBadMagic))
+	require.Equal(t, status.ErrCorruption, status.Kind(ErrTruncated))
+	require.Equal(t, status.ErrClosed, status.Kind(ErrTableCacheClosed))
+	require.Nil(t, status.Kind(ErrComparatorMismatch))
+}
+
+func test_table_Logger(t *testing.T, dir string) {
+	path := filepath.Join(dir, "logger.sst")
+
+	var buf bytes.Buffer
+	opts := DefaultOptions()
+	opts.Logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	b, err := NewBuilder(path, opts)
+	require.NoError(t, err)
+	require.NoError(t, b.Add([]byte("a"), []byte("A"), false))
+	require.NoError(t, b.Finish())
+	require.Contains(t, buf.String(), `level=DEBUG msg="table written" path=`+path+" entries=1 size=")
+
+	tbl, err := OpenTable(path, opts)
+	require.NoError(t, err)
+	defer tbl.Close()
+	require.Contains(t, buf.String(), `level=DEBUG msg="table opened" path=`+path)
+
+	// a read failure is returned, and logged.
+	require.NoError(t, os.Truncate(path, 0))
+
+	buf.Reset()
+	_, err = tbl.Get([]byte("a"))
+	require.ErrorIs(t, err, ErrCorruption)
+	require.NotErrorIs(t, err, ErrNotFound)
+	require.Contains(t, buf.String(), `level=ERROR msg="table read failed" path=`+path+" offset=0 err=")
+
+	buf.Reset()
+	_, err = OpenTable(path, opts)
+	require.ErrorIs(t, err, ErrTruncated)
+	require.Contains(t, buf.String(), `level=ERROR msg="table corrupted" path=`+path+" size=0 err=")
+}
+
+func test_table_Get(t *testing.T, tbl *Table) {
+
+	{
+		value, err := tbl.Get([]byte("a"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("A"), value)
+	}
+
+	{
+		value, err := tbl.Get([]byte("c"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("CCC"), value)
+	}
+
+	{
+		value, err := tbl.Get([]byte("d"))
+		require.ErrorIs(t, err, ErrDeleted)
+		require.Nil(t, value)
+	}
+
+	{
+		value, err := tbl.Get([]byte("bb"))
+		require.ErrorIs(t, err, ErrNotFound)
+		require.NotErrorIs(t, err, ErrDeleted)
+		require.Nil(t, value)
+	}
+
+	{
+		value, err := tbl.Get([]byte("z"))
+		require.ErrorIs(t, err, ErrNotFound)
+		require.NotErrorIs(t, err, ErrDeleted)
+		require.Nil(t, value)
+	}
+
+}
+
+func test_table_Iterator(t *testing.T, tbl *Table) {
+	itr := tbl.NewIterator(nil)
+
+	expected := []struct {
+		key, value string
+		tombstone  bool
+	}{
+		{"a", "A", false},
+		{"b", "BB", false},
+		{"c", "CCC", false},
+		{"d", "", true},
+		{"e", "", true},
+	}
+
+	for _, e := range expected {
+		require.Equal(t, true, itr.HasNext())
+
+		key, value, tombstone, err := itr.Next()
+		require.NoError(t, err)
+		require.Equal(t, []byte(e.key), key)
+		require.Equal(t, []byte(e.value), value)
+		require.Equal(t, e.tombstone, tombstone)
+	}
+
+	require.Equal(t, false, itr.HasNext())
+
+	_, _, _, err := itr.Next()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func test_table_Seek(t *testing.T, tbl *Table) {
+	stats := tbl.opts.Statistics
+	itr := tbl.NewIterator(nil)
+
+	// only the block that may hold the key is read.
+	itr.Seek([]byte("bb"))
+	require.Equal(t, uint64(1), stats.Ticker(statistics.BLOCK_READS))
+
+	require.Equal(t, true, itr.HasNext())
+	key, value, _, err := itr.Next()
+	require.NoError(t, err)
+	require.Equal(t, []byte("c"), key)
+	require.Equal(t, []byte("CCC"), value)
+
+	// and the iterator can be moved back.
+	itr.Seek([]byte("a"))
+	key, _, _, err = itr.Next()
+	require.NoError(t, err)
+	require.Equal(t, []byte("a"), key)
+
+	itr.Seek([]byte("z"))
+	require.Equal(t, false, itr.HasNext())
+}
+
+func test_table_Expiring(t *testing.T, dir string) {
+	path := filepath.Join(dir, "expiring.sst")
+	now := time.Unix(1000, 0)
+
+	opts := DefaultOptions()
+	opts.Clock = clock.NewManual(now)
+
+	b, err := NewBuilder(path, opts)
+	require.NoError(t, err)
+	require.NoError(t, b.AddEntry([]byte("a"), EncodeExpiring([]byte("A"), now.Add(-time.Second)), EXPIRING))
+	require.NoError(t, b.AddEntry([]byte("b"), EncodeExpiring([]byte("B"), now.Add(time.Second)), EXPIRING))
+	require.NoError(t, b.Finish())
+
+	tbl, err := OpenTable(path, opts)
+	require.NoError(t, err)
+	defer tbl.Close()
+
+	{
+		value, err := tbl.Get([]byte("a"))
+		require.ErrorIs(t, err, ErrDeleted)
+		require.Nil(t, value)
+	}
+
+	{
+		value, err := tbl.Get([]byte("b"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("B"), value)
+
+		value, ty, err := tbl.GetEntry([]byte("b"), nil)
+		require.NoError(t, err)
+		require.Equal(t, EXPIRING, ty)
+
+		value, expireAt, err := DecodeExpiring(value)
+		require.NoError(t, err)
+		require.Equal(t, []byte("B"), value)
+		require.Equal(t, now.Add(time.Second).UnixNano(), expireAt.UnixNano())
+	}
+
+	itr := tbl.NewIterator(nil)
+	for _, e := range []struct {
+		key, value string
+		tombstone  bool
+	}{
+		{"a", "", true},
+		{"b", "B", false},
+	} {
+		key, value, tombstone, err := itr.Next()
+		require.NoError(t, err)
+		require.Equal(t, []byte(e.key), key)
+		require.Equal(t, []byte(e.value), value)
+		require.Equal(t, e.tombstone, tombstone)
+	}
+}
+
+// BenchmarkTableGet compares pread and mmap lookups on the TestSSTable
+// workload, with the two-file SSTable as a baseline.
+func BenchmarkTableGet(b *testing.B) {
+	dir, err := os.MkdirTemp("", "bench_table_")
+	require.NoError(b, err)
+	defer os.RemoveAll(dir)
+
+	entries := []struct {
+		key, value string
+		tombstone  bool
+	}{
+		{"a", "A", false},
+		{"b", "BB", false},
+		{"c", "CCC", false},
+		{"d", "", true},
+		{"e", "", true},
+	}
+
+	path := filepath.Join(dir, "000001.sst")
+	{
+		bld, err := NewBuilder(path, nil)
+		require.NoError(b, err)
+
+		for _, e := range entries {
+			require.NoError(b, bld.Add([]byte(e.key), []byte(e.value), e.tombstone))
+		}
+		require.NoError(b, bld.Finish())
+	}
+
+	keys := [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d"), []byte("e")}
+
+	for _, mmap := range []bool{false, true} {
+		name := "pread"
+		if mmap {
+			name = "mmap"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			opts := DefaultOptions()
+			opts.UseMmap = mmap
+
+			tbl, err := OpenTable(path, opts)
+			require.NoError(b, err)
+			defer tbl.Close()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				tbl.Get(keys[i%len(keys)])
+			}
+		})
+	}
+
+	b.Run("sstable", func(b *testing.B) {
+		idxfile, err := os.CreateTemp(dir, "bench_sstable_idxfile_")
+		require.NoError(b, err)
+
+		segfile, err := os.CreateTemp(dir, "bench_sstable_segfile_")
+		require.NoError(b, err)
+
+		sst, err := New(idxfile, segfile)
+		require.NoError(b, err)
+		defer sst.Close()
+
+		for _, e := range entries {
+			require.NoError(b, sst.Index.Append([]byte(e.key), sst.Segment.Size()))
+			require.NoError(b, sst.Segment.Append([]byte(e.key), []byte(e.value), e.tombstone))
+		}
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			sst.Get(keys[i%len(keys)])
+		}
+	})
+}
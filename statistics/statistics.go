@@ -0,0 +1,356 @@
+package statistics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Ticker counts events.
+type Ticker int
+
+const (
+	// keys written by Put, Del, Merge and the other writes, and the bytes
+	// of their keys and values.
+	KEYS_WRITTEN Ticker = iota
+	BYTES_WRITTEN
+	// appends to the WAL, each synced, and the bytes appended.
+	WAL_SYNCS
+	WAL_BYTES_WRITTEN
+	// Get calls, those which found a value, and the bytes of the values.
+	KEYS_READ
+	KEYS_FOUND
+	BYTES_READ
+	// Get calls settled by the memtable and the others.
+	MEMTABLE_HITS
+	MEMTABLE_MISSES
+	// lookups of a key in a table by Get.
+	TABLE_READS
+	// data blocks read from table files, and their bytes.
+	BLOCK_READS
+	BLOCK_BYTES_READ
+	BLOCK_CACHE_HITS
+	BLOCK_CACHE_MISSES
+	// bytes of the values returned by iterators.
+	ITER_BYTES_READ
+	// memtables flushed and the bytes of the tables written.
+	FLUSHES
+	FLUSH_BYTES_WRITTEN
+	// compactions, and the bytes of their input and output tables.
+	COMPACTIONS
+	COMPACTION_BYTES_READ
+	COMPACTION_BYTES_WRITTEN
+	// bytes read from and written to the tables of each tier: the
+	// bottommost table of a column family, holding its oldest data, and
+	// the tables above it. Reads are the entries found by Get and the
+	// tables compacted; writes are the tables flushed and compacted.
+	TOP_BYTES_READ
+	TOP_BYTES_WRITTEN
+	BOTTOM_BYTES_READ
+	BOTTOM_BYTES_WRITTEN
+	TICKER_MAX
+)
+
+var tickerNames = [TICKER_MAX]string{
+	KEYS_WRITTEN:             "lsm.keys.written",
+	BYTES_WRITTEN:            "lsm.bytes.written",
+	WAL_SYNCS:                "lsm.wal.syncs",
+	WAL_BYTES_WRITTEN:        "lsm.wal.bytes.written",
+	KEYS_READ:                "lsm.keys.read",
+	KEYS_FOUND:               "lsm.keys.found",
+	BYTES_READ:               "lsm.bytes.read",
+	MEMTABLE_HITS:            "lsm.memtable.hits",
+	MEMTABLE_MISSES:          "lsm.memtable.misses",
+	TABLE_READS:              "lsm.table.reads",
+	BLOCK_READS:              "lsm.block.reads",
+	BLOCK_BYTES_READ:         "lsm.block.bytes.read",
+	BLOCK_CACHE_HITS:         "lsm.block.cache.hits",
+	BLOCK_CACHE_MISSES:       "lsm.block.cache.misses",
+	ITER_BYTES_READ:          "lsm.iter.bytes.read",
+	FLUSHES:                  "lsm.flushes",
+	FLUSH_BYTES_WRITTEN:      "lsm.flush.bytes.written",
+	COMPACTIONS:              "lsm.compactions",
+	COMPACTION_BYTES_READ:    "lsm.compaction.bytes.read",
+	COMPACTION_BYTES_WRITTEN: "lsm.compaction.bytes.written",
+	TOP_BYTES_READ:           "lsm.top.bytes.read",
+	TOP_BYTES_WRITTEN:        "lsm.top.bytes.written",
+	BOTTOM_BYTES_READ:        "lsm.bottom.bytes.read",
+	BOTTOM_BYTES_WRITTEN:     "lsm.bottom.bytes.written",
+}
+
+func (t Ticker) String() string {
+	if t < 0 || t >= TICKER_MAX {
+		return fmt.Sprintf("Ticker(%d)", int(t))
+	}
+
+	return tickerNames[t]
+}
+
+// Histogram records the distribution of a measure.
+type Histogram int
+
+const (
+	// latencies, in microseconds.
+	GET_MICROS Histogram = iota
+	WRITE_MICROS
+	FLUSH_MICROS
+	COMPACTION_MICROS
+	// sizes of the values returned by Get and of the writes.
+	BYTES_PER_READ
+	BYTES_PER_WRITE
+	HISTOGRAM_MAX
+)
+
+var histogramNames = [HISTOGRAM_MAX]string{
+	GET_MICROS:        "lsm.get.micros",
+	WRITE_MICROS:      "lsm.write.micros",
+	FLUSH_MICROS:      "lsm.flush.micros",
+	COMPACTION_MICROS: "lsm.compaction.micros",
+	BYTES_PER_READ:    "lsm.bytes.per.read",
+	BYTES_PER_WRITE:   "lsm.bytes.per.write",
+}
+
+func (h Histogram) String() string {
+	if h < 0 || h >= HISTOGRAM_MAX {
+		return fmt.Sprintf("Histogram(%d)", int(h))
+	}
+
+	return histogramNames[h]
+}
+
+// upper bounds of the histogram buckets, growing by half each, so that
+// percentiles are estimated within a few percent.
+var bucketLimits = func() []uint64 {
+	limits := []uint64{1, 2}
+	for limits[len(limits)-1] < math.MaxUint64/2 {
+		last := limits[len(limits)-1]
+		limits = append(limits, last+last/2)
+	}
+
+	return append(limits, math.MaxUint64)
+}()
+
+type histogram struct {
+	sum     atomic.Uint64
+	min     atomic.Uint64
+	max     atomic.Uint64
+	buckets []atomic.Uint64
+}
+
+func (h *histogram) record(v uint64) {
+	h.buckets[sort.Search(len(bucketLimits), func(i int) bool { return bucketLimits[i] >= v })].Add(1)
+	h.sum.Add(v)
+
+	// min holds the minimum plus one, so that zero means no value.
+	for old := h.min.Load(); old == 0 || v+1 < old; old = h.min.Load() {
+		if h.min.CompareAndSwap(old, v+1) {
+			break
+		}
+	}
+
+	for old := h.max.Load(); v > old; old = h.max.Load() {
+		if h.max.CompareAndSwap(old, v) {
+			break
+		}
+	}
+}
+
+func (h *histogram) reset() {
+	h.sum.Store(0)
+	h.min.Store(0)
+	h.max.Store(0)
+	for i := range h.buckets {
+		h.buckets[i].Store(0)
+	}
+}
+
+// HistogramData summarizes a histogram. Percentiles are interpolated
+// within buckets.
+type HistogramData struct {
+	Count   uint64
+	Sum     uint64
+	Min     uint64
+	Max     uint64
+	Average float64
+	P50     float64
+	P95     float64
+	P99     float64
+}
+
+func (h *histogram) data() HistogramData {
+	counts := make([]uint64, len(h.buckets))
+	var count uint64
+	for i := range h.buckets {
+		counts[i] = h.buckets[i].Load()
+		count += counts[i]
+	}
+
+	if count == 0 {
+		return HistogramData{}
+	}
+
+	d := HistogramData{
+		Count: count,
+		Sum:   h.sum.Load(),
+		Max:   h.max.Load(),
+	}
+	if min := h.min.Load(); min > 0 {
+		d.Min = min - 1
+	}
+	d.Average = float64(d.Sum) / float64(count)
+	d.P50 = d.percentile(counts, 50)
+	d.P95 = d.percentile(counts, 95)
+	d.P99 = d.percentile(counts, 99)
+
+	return d
+}
+
+func (d HistogramData) percentile(counts []uint64, p float64) float64 {
+	threshold := float64(d.Count) * p / 100
+
+	var cumulative uint64
+	for i, n := range counts {
+		cumulative += n
+		if float64(cumulative) < threshold {
+			continue
+		}
+
+		low := float64(0)
+		if i > 0 {
+			low = float64(bucketLimits[i-1])
+		}
+		high := float64(bucketLimits[i])
+
+		// the values fall within [Min, Max].
+		low = math.Max(low, float64(d.Min))
+		high = math.Min(high, float64(d.Max))
+
+		if n == 0 || high <= low {
+			return high
+		}
+
+		before := float64(cumulative - n)
+		return low + (high-low)*(threshold-before)/float64(n)
+	}
+
+	return float64(d.Max)
+}
+
+// Statistics counts the events of one or more stores. Updates are atomic
+// adds, cheap enough to leave statistics on. A nil *Statistics records
+// nothing.
+type Statistics struct {
+	tickers    [TICKER_MAX]atomic.Uint64
+	histograms [HISTOGRAM_MAX]histogram
+}
+
+func New() *Statistics {
+	s := &Statistics{}
+	for i := range s.histograms {
+		s.histograms[i].buckets = make([]atomic.Uint64, len(bucketLimits))
+	}
+
+	return s
+}
+
+// Add adds n to the ticker.
+func (s *Statistics) Add(t Ticker, n uint64) {
+	if s == nil {
+		return
+	}
+
+	s.tickers[t].Add(n)
+}
+
+// Record adds v to the histogram.
+func (s *Statistics) Record(h Histogram, v uint64) {
+	if s == nil {
+		return
+	}
+
+	s.histograms[h].record(v)
+}
+
+// RecordSince adds the microseconds elapsed since start to the histogram.
+func (s *Statistics) RecordSince(h Histogram, start time.Time) {
+	if s == nil {
+		return
+	}
+
+	s.histograms[h].record(uint64(time.Since(start).Microseconds()))
+}
+
+// Ticker returns the value of the ticker.
+func (s *Statistics) Ticker(t Ticker) uint64 {
+	if s == nil {
+		return 0
+	}
+
+	return s.tickers[t].Load()
+}
+
+// Histogram returns a summary of the histogram.
+func (s *Statistics) Histogram(h Histogram) HistogramData {
+	if s == nil {
+		return HistogramData{}
+	}
+
+	return s.histograms[h].data()
+}
+
+// Reset sets every ticker and histogram back to zero.
+func (s *Statistics) Reset() {
+	if s == nil {
+		return
+	}
+
+	for i := range s.tickers {
+		s.tickers[i].Store(0)
+	}
+	for i := range s.histograms {
+		s.histograms[i].reset()
+	}
+}
+
+// Snapshot is a copy of the tickers and histograms at some point in time.
+// Updates made while it is taken may be partially seen.
+type Snapshot struct {
+	Tickers    [TICKER_MAX]uint64
+	Histograms [HISTOGRAM_MAX]HistogramData
+}
+
+// Snapshot copies the tickers and histograms.
+func (s *Statistics) Snapshot() Snapshot {
+	var snap Snapshot
+	if s == nil {
+		return snap
+	}
+
+	for i := range s.tickers {
+		snap.Tickers[i] = s.tickers[i].Load()
+	}
+	for i := range s.histograms {
+		snap.Histograms[i] = s.histograms[i].data()
+	}
+
+	return snap
+}
+
+// String lists the tickers and histograms one per line.
+func (snap Snapshot) String() string {
+	var b strings.Builder
+
+	for t, v := range snap.Tickers {
+		fmt.Fprintf(&b, "%s COUNT : %d\n", Ticker(t), v)
+	}
+
+	for h, d := range snap.Histograms {
+		fmt.Fprintf(&b, "%s P50 : %.1f P95 : %.1f P99 : %.1f COUNT : %d SUM : %d MIN : %d MAX : %d\n",
+			Histogram(h), d.P50, d.P95, d.P99, d.Count, d.Sum, d.Min, d.Max)
+	}
+
+	return b.String()
+}
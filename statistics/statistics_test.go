@@ -0,0 +1,125 @@
+package statistics
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatistics(t *testing.T) {
+	for scenario, fn := range map[string]func(
+		t *testing.T,
+	){
+		"Tickers":    test_Tickers,
+		"Histograms": test_Histograms,
+		"Nil":        test_Nil,
+		"Snapshot":   test_Snapshot,
+	} {
+		fn := fn // https://github.com/golang/go/wiki/CommonMistakes
+		t.Run(scenario, func(t *testing.T) {
+			fn(t)
+		})
+	}
+}
+
+func test_Tickers(t *testing.T) {
+	s := New()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				s.Add(KEYS_WRITTEN, 1)
+				s.Add(BYTES_WRITTEN, 10)
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, uint64(8000), s.Ticker(KEYS_WRITTEN))
+	require.Equal(t, uint64(80000), s.Ticker(BYTES_WRITTEN))
+	require.Equal(t, uint64(0), s.Ticker(KEYS_READ))
+
+	s.Reset()
+	require.Equal(t, uint64(0), s.Ticker(KEYS_WRITTEN))
+
+	require.Equal(t, "lsm.keys.written", KEYS_WRITTEN.String())
+	require.Equal(t, "Ticker(-1)", Ticker(-1).String())
+
+	// every ticker is named.
+	for ticker := Ticker(0); ticker < TICKER_MAX; ticker++ {
+		require.NotEmpty(t, tickerNames[ticker], int(ticker))
+	}
+	require.Equal(t, "lsm.bottom.bytes.read", BOTTOM_BYTES_READ.String())
+}
+
+func test_Histograms(t *testing.T) {
+	s := New()
+
+	require.Equal(t, HistogramData{}, s.Histogram(GET_MICROS))
+
+	for v := uint64(1); v <= 1000; v++ {
+		s.Record(GET_MICROS, v)
+	}
+
+	d := s.Histogram(GET_MICROS)
+	require.Equal(t, uint64(1000), d.Count)
+	require.Equal(t, uint64(500500), d.Sum)
+	require.Equal(t, uint64(1), d.Min)
+	require.Equal(t, uint64(1000), d.Max)
+	require.InDelta(t, 500.5, d.Average, 0.001)
+	// buckets grow by half, bounding the error of the estimates.
+	require.InEpsilon(t, 500, d.P50, 0.25)
+	require.InEpsilon(t, 950, d.P95, 0.25)
+	require.InEpsilon(t, 990, d.P99, 0.25)
+	require.LessOrEqual(t, d.P50, d.P95)
+	require.LessOrEqual(t, d.P95, d.P99)
+	require.LessOrEqual(t, d.P99, float64(d.Max))
+
+	s.Record(WRITE_MICROS, 0)
+	d = s.Histogram(WRITE_MICROS)
+	require.Equal(t, uint64(1), d.Count)
+	require.Equal(t, uint64(0), d.Min)
+	require.Equal(t, float64(0), d.P99)
+
+	s.RecordSince(FLUSH_MICROS, time.Now().Add(-time.Millisecond))
+	require.GreaterOrEqual(t, s.Histogram(FLUSH_MICROS).Min, uint64(1000))
+
+	s.Reset()
+	require.Equal(t, HistogramData{}, s.Histogram(GET_MICROS))
+}
+
+func test_Nil(t *testing.T) {
+	var s *Statistics
+
+	s.Add(KEYS_WRITTEN, 1)
+	s.Record(GET_MICROS, 1)
+	s.RecordSince(GET_MICROS, time.Now())
+	s.Reset()
+
+	require.Equal(t, uint64(0), s.Ticker(KEYS_WRITTEN))
+	require.Equal(t, HistogramData{}, s.Histogram(GET_MICROS))
+	require.Equal(t, Snapshot{}, s.Snapshot())
+}
+
+func test_Snapshot(t *testing.T) {
+	s := New()
+	s.Add(FLUSHES, 2)
+	s.Record(BYTES_PER_WRITE, 100)
+
+	snap := s.Snapshot()
+	s.Add(FLUSHES, 1)
+
+	require.Equal(t, uint64(2), snap.Tickers[FLUSHES])
+	require.Equal(t, uint64(1), snap.Histograms[BYTES_PER_WRITE].Count)
+	require.Equal(t, uint64(3), s.Ticker(FLUSHES))
+
+	out := snap.String()
+	require.True(t, strings.Contains(out, "lsm.flushes COUNT : 2\n"))
+	require.True(t, strings.Contains(out, "lsm.bytes.per.write P50 : 100.0"))
+}
@@ -0,0 +1,64 @@
+package status
+
+import (
+	"errors"
+)
+
+// Kinds of the errors of the engine. The errors of the wal, memtable,
+// sstable and db packages wrap one of them, so that callers can tell
+// what went wrong with errors.Is without knowing which package failed.
+var (
+	// the key or file has no value.
+	ErrNotFound = errors.New("not found")
+	// a file holds data that does not decode.
+	ErrCorruption = errors.New("corruption")
+	// the WAL, table, builder or store was closed.
+	ErrClosed = errors.New("closed")
+	// the store refuses writes until it is resumed.
+	ErrReadOnly = errors.New("read-only")
+	// the file system failed.
+	ErrIO = errors.New("i/o error")
+	// the store is in use by another handle.
+	ErrBusy = errors.New("busy")
+)
+
+var kinds = []error{ErrNotFound, ErrCorruption, ErrClosed, ErrReadOnly, ErrIO, ErrBusy}
+
+// Kind returns the first kind err wraps, in the order they are declared,
+// or nil when it wraps none.
+func Kind(err error) error {
+	for _, kind := range kinds {
+		if errors.Is(err, kind) {
+			return kind
+		}
+	}
+
+	return nil
+}
+
+// IOError is a failure of the file system. It reads as the error it wraps
+// and is an ErrIO.
+type IOError struct {
+	Err error
+}
+
+func (e *IOError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *IOError) Unwrap() error {
+	return e.Err
+}
+
+func (e *IOError) Is(target error) bool {
+	return target == ErrIO
+}
+
+// IO classifies err as an IOError, unless it is nil or already of a kind.
+func IO(err error) error {
+	if err == nil || Kind(err) != nil {
+		return err
+	}
+
+	return &IOError{Err: err}
+}
@@ -0,0 +1,62 @@
+package status
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatus(t *testing.T) {
+	for scenario, fn := range map[string]func(
+		t *testing.T,
+	){
+		"Kind": test_Kind,
+		"IO":   test_IO,
+	} {
+		fn := fn // https://github.com/golang/go/wiki/CommonMistakes
+		t.Run(scenario, func(t *testing.T) {
+			fn(t)
+		})
+	}
+}
+
+func test_Kind(t *testing.T) {
+	require.Nil(t, Kind(nil))
+	require.Nil(t, Kind(errors.New("other")))
+
+	for _, kind := range kinds {
+		require.Equal(t, kind, Kind(kind))
+		require.Equal(t, kind, Kind(fmt.Errorf("sstable: %w", kind)))
+	}
+
+	// the first kind wins.
+	err := fmt.Errorf("db: %w: %w", ErrReadOnly, IO(errors.New("disk full")))
+	require.Equal(t, ErrReadOnly, Kind(err))
+	require.ErrorIs(t, err, ErrIO)
+}
+
+func test_IO(t *testing.T) {
+	require.Nil(t, IO(nil))
+
+	_, err := os.Open("/nonexistent/000001.sst")
+	err = IO(err)
+
+	require.ErrorIs(t, err, ErrIO)
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	require.Equal(t, "open /nonexistent/000001.sst: no such file or directory", err.Error())
+
+	var ioErr *IOError
+	require.ErrorAs(t, err, &ioErr)
+	var pathErr *fs.PathError
+	require.ErrorAs(t, err, &pathErr)
+	require.Equal(t, "/nonexistent/000001.sst", pathErr.Path)
+
+	// errors of a kind are left alone.
+	corrupt := fmt.Errorf("sstable: %w", ErrCorruption)
+	require.Equal(t, corrupt, IO(corrupt))
+	require.Equal(t, err, IO(err))
+}
@@ -0,0 +1,243 @@
+package vlog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"sync"
+)
+
+// A value log is an append-only file of records holding values moved out
+// of tables, which keep a Pointer to them instead:
+//
+//	crc(4) ksize(8) vsize(8) key value
+//
+// The checksum covers the key and value. The key lets the garbage
+// collector of the store tell which record a pointer refers to.
+const (
+	CRC_SIZE     int = 4                          // Byte
+	K_SIZE       int = 8                          // Byte
+	V_SIZE       int = 8                          // Byte
+	HEADER_SIZE  int = CRC_SIZE + K_SIZE + V_SIZE // Byte
+	POINTER_SIZE int = 24                         // Byte
+)
+
+var (
+	enc = binary.BigEndian
+)
+
+var (
+	ErrCorruptRecord  = errors.New("vlog: corrupt record")
+	ErrInvalidPointer = errors.New("vlog: invalid pointer")
+	ErrWriterClosed   = errors.New("vlog: writer closed")
+)
+
+// Pointer locates a record in the value log numbered File.
+type Pointer struct {
+	File   uint64
+	Offset uint64
+	// size of the whole record.
+	Size uint64
+}
+
+func (p Pointer) Encode() []byte {
+	buf := make([]byte, POINTER_SIZE)
+	enc.PutUint64(buf[0:], p.File)
+	enc.PutUint64(buf[8:], p.Offset)
+	enc.PutUint64(buf[16:], p.Size)
+
+	return buf
+}
+
+func DecodePointer(buf []byte) (Pointer, error) {
+	if len(buf) != POINTER_SIZE {
+		return Pointer{}, ErrInvalidPointer
+	}
+
+	return Pointer{
+		File:   enc.Uint64(buf[0:]),
+		Offset: enc.Uint64(buf[8:]),
+		Size:   enc.Uint64(buf[16:]),
+	}, nil
+}
+
+// Writer appends records to a new value log.
+type Writer struct {
+	rwmu   sync.RWMutex
+	number uint64
+	file   *os.File
+	bw     *bufio.Writer
+	offset uint64
+	closed bool
+}
+
+// Create creates the value log numbered number at path.
+func Create(path string, number uint64) (*Writer, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		number: number,
+		file:   f,
+		bw:     bufio.NewWriter(f),
+	}, nil
+}
+
+// Append writes key and value and returns the pointer to the record. The
+// record is durable once Finish returns.
+func (w *Writer) Append(key, value []byte) (Pointer, error) {
+	w.rwmu.Lock()
+	defer w.rwmu.Unlock()
+
+	if w.closed {
+		return Pointer{}, ErrWriterClosed
+	}
+
+	header := make([]byte, HEADER_SIZE)
+	crc := crc32.NewIEEE()
+	crc.Write(key)
+	crc.Write(value)
+	enc.PutUint32(header[0:], crc.Sum32())
+	enc.PutUint64(header[CRC_SIZE:], uint64(len(key)))
+	enc.PutUint64(header[CRC_SIZE+K_SIZE:], uint64(len(value)))
+
+	for _, buf := range [][]byte{header, key, value} {
+		if _, err := w.bw.Write(buf); err != nil {
+			return Pointer{}, err
+		}
+	}
+
+	p := Pointer{
+		File:   w.number,
+		Offset: w.offset,
+		Size:   uint64(HEADER_SIZE + len(key) + len(value)),
+	}
+	w.offset += p.Size
+
+	return p, nil
+}
+
+// Number returns the number of the value log.
+func (w *Writer) Number() uint64 {
+	return w.number
+}
+
+// Size returns the number of bytes appended.
+func (w *Writer) Size() uint64 {
+	w.rwmu.RLock()
+	defer w.rwmu.RUnlock()
+
+	return w.offset
+}
+
+// Finish syncs and closes the value log.
+func (w *Writer) Finish() error {
+	w.rwmu.Lock()
+	defer w.rwmu.Unlock()
+
+	if w.closed {
+		return ErrWriterClosed
+	}
+	w.closed = true
+
+	if err := w.bw.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+
+	if err := w.file.Sync(); err != nil {
+		w.file.Close()
+		return err
+	}
+
+	return w.file.Close()
+}
+
+// Abandon closes and removes the value log.
+func (w *Writer) Abandon() {
+	w.rwmu.Lock()
+	defer w.rwmu.Unlock()
+
+	if !w.closed {
+		w.closed = true
+		w.file.Close()
+	}
+	os.Remove(w.file.Name())
+}
+
+// Reader reads records of a value log. It is safe for concurrent use.
+type Reader struct {
+	number uint64
+	file   *os.File
+	size   uint64
+}
+
+// Open opens the value log numbered number at path.
+func Open(path string, number uint64) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &Reader{
+		number: number,
+		file:   f,
+		size:   uint64(fi.Size()),
+	}, nil
+}
+
+// Read returns the key and value of the record p points to.
+func (r *Reader) Read(p Pointer) (key, value []byte, err error) {
+	// compared one at a time so that corrupt pointers cannot overflow.
+	if p.File != r.number || p.Size < uint64(HEADER_SIZE) || p.Size > r.size || p.Offset > r.size-p.Size {
+		return nil, nil, fmt.Errorf("%w: %+v", ErrInvalidPointer, p)
+	}
+
+	buf := make([]byte, p.Size)
+	if _, err := r.file.ReadAt(buf, int64(p.Offset)); err != nil {
+		return nil, nil, err
+	}
+
+	ksize := enc.Uint64(buf[CRC_SIZE:])
+	vsize := enc.Uint64(buf[CRC_SIZE+K_SIZE:])
+	if rem := p.Size - uint64(HEADER_SIZE); ksize > rem || vsize != rem-ksize {
+		return nil, nil, ErrCorruptRecord
+	}
+
+	key = buf[HEADER_SIZE : uint64(HEADER_SIZE)+ksize]
+	value = buf[uint64(HEADER_SIZE)+ksize:]
+
+	crc := crc32.NewIEEE()
+	crc.Write(key)
+	crc.Write(value)
+	if crc.Sum32() != enc.Uint32(buf) {
+		return nil, nil, ErrCorruptRecord
+	}
+
+	return key, value, nil
+}
+
+// Number returns the number of the value log.
+func (r *Reader) Number() uint64 {
+	return r.number
+}
+
+// Size returns the size of the value log.
+func (r *Reader) Size() uint64 {
+	return r.size
+}
+
+func (r *Reader) Close() error {
+	return r.file.Close()
+}
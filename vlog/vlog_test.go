@@ -0,0 +1,117 @@
+package vlog
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValueLog(t *testing.T) {
+	for scenario, fn := range map[string]func(
+		t *testing.T, path string,
+	){
+		"AppendRead": test_vlog_AppendRead,
+		"Corrupt":    test_vlog_Corrupt,
+		"Abandon":    test_vlog_Abandon,
+	} {
+		fn := fn // https://github.com/golang/go/wiki/CommonMistakes
+		t.Run(scenario, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "test_vlog_")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			fn(t, filepath.Join(dir, "000007.vlog"))
+		})
+	}
+}
+
+func test_vlog_AppendRead(t *testing.T, path string) {
+	w, err := Create(path, 7)
+	require.NoError(t, err)
+
+	p1, err := w.Append([]byte("a"), []byte("A"))
+	require.NoError(t, err)
+	p2, err := w.Append([]byte("bb"), []byte(""))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+
+	require.Equal(t, Pointer{File: 7, Offset: 0, Size: uint64(HEADER_SIZE + 2)}, p1)
+	require.Equal(t, Pointer{File: 7, Offset: p1.Size, Size: uint64(HEADER_SIZE + 2)}, p2)
+	require.Equal(t, p1.Size+p2.Size, w.Size())
+
+	_, err = w.Append([]byte("c"), []byte("C"))
+	require.ErrorIs(t, err, ErrWriterClosed)
+
+	decoded, err := DecodePointer(p2.Encode())
+	require.NoError(t, err)
+	require.Equal(t, p2, decoded)
+
+	_, err = DecodePointer([]byte("short"))
+	require.ErrorIs(t, err, ErrInvalidPointer)
+
+	r, err := Open(path, 7)
+	require.NoError(t, err)
+	defer r.Close()
+
+	key, value, err := r.Read(p1)
+	require.NoError(t, err)
+	require.Equal(t, []byte("a"), key)
+	require.Equal(t, []byte("A"), value)
+
+	key, value, err = r.Read(p2)
+	require.NoError(t, err)
+	require.Equal(t, []byte("bb"), key)
+	require.Equal(t, []byte(""), value)
+
+	// pointers to another value log or past the end.
+	_, _, err = r.Read(Pointer{File: 8, Offset: 0, Size: p1.Size})
+	require.ErrorIs(t, err, ErrInvalidPointer)
+	_, _, err = r.Read(Pointer{File: 7, Offset: p2.Offset, Size: p2.Size + 1})
+	require.ErrorIs(t, err, ErrInvalidPointer)
+	_, _, err = r.Read(Pointer{File: 7, Offset: math.MaxUint64, Size: p1.Size})
+	require.ErrorIs(t, err, ErrInvalidPointer)
+}
+
+func test_vlog_Corrupt(t *testing.T, path string) {
+	w, err := Create(path, 7)
+	require.NoError(t, err)
+
+	p, err := w.Append([]byte("a"), []byte("value"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+
+	buf, err := os.ReadFile(path)
+	require.NoError(t, err)
+	buf[len(buf)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(path, buf, 0644))
+
+	r, err := Open(path, 7)
+	require.NoError(t, err)
+	defer r.Close()
+
+	_, _, err = r.Read(p)
+	require.ErrorIs(t, err, ErrCorruptRecord)
+
+	// sizes whose sum wraps around to the record size.
+	enc.PutUint64(buf[CRC_SIZE:], math.MaxUint64)
+	enc.PutUint64(buf[CRC_SIZE+K_SIZE:], uint64(len("avalue"))+1)
+	require.NoError(t, os.WriteFile(path, buf, 0644))
+
+	_, _, err = r.Read(p)
+	require.ErrorIs(t, err, ErrCorruptRecord)
+}
+
+func test_vlog_Abandon(t *testing.T, path string) {
+	w, err := Create(path, 7)
+	require.NoError(t, err)
+
+	_, err = w.Append([]byte("a"), []byte("A"))
+	require.NoError(t, err)
+	w.Abandon()
+
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+}
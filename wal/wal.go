@@ -4,11 +4,17 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"sync"
+	"time"
 
+	"github.com/sosomasox/LSM-Tree-based-Storage/logging"
 	"github.com/sosomasox/LSM-Tree-based-Storage/memtable"
+	"github.com/sosomasox/LSM-Tree-based-Storage/status"
 )
 
 type OpeType uint8
@@ -16,6 +22,16 @@ type OpeType uint8
 const (
 	OPE_DEL OpeType = iota
 	OPE_PUT
+	// the value is a merge operand for the key.
+	OPE_MERGE
+	// a put whose value is prefixed with its expiration time.
+	OPE_PUT_TTL
+	// deletes the keys from Key, inclusive, to Value, exclusive.
+	OPE_DEL_RANGE
+	// deletes a key written exactly once.
+	OPE_SINGLE_DEL
+	// the value holds the records of Batch, applied atomically.
+	OPE_BATCH
 )
 
 const (
@@ -23,33 +39,72 @@ const (
 	KV_SIZE      int = 8 // Byte
 	K_SIZE       int = 8 // Byte
 	V_SIZE       int = 8 // Byte
+	// expiration time of OPE_PUT_TTL records, in Unix nanoseconds.
+	EXPIRE_AT_SIZE int = 8 // Byte
+	// column family of the records of an OPE_BATCH.
+	CF_SIZE int = 4 // Byte
 )
 
 var (
 	enc = binary.BigEndian
 )
 
+var (
+	ErrNestedBatch = errors.New("wal: batch inside a batch")
+	ErrClosed      = fmt.Errorf("wal: %w", status.ErrClosed)
+	// a record is cut short, e.g. by a crash during its append.
+	ErrCorruption = fmt.Errorf("wal: %w", status.ErrCorruption)
+)
+
 type Recode struct {
 	Ope   OpeType
 	Key   []byte
 	Value []byte
+	// expiration time of OPE_PUT_TTL records.
+	ExpireAt time.Time
+	// column family of a record of a batch. Records outside batches belong
+	// to the default column family 0.
+	ColumnFamily uint32
+	// records of an OPE_BATCH.
+	Batch []Recode
+}
+
+type Options struct {
+	// receives records of replays, corruption and removals; defaults to
+	// logging.Discard.
+	Logger *slog.Logger
+}
+
+func DefaultOptions() *Options {
+	return &Options{}
 }
 
 type WAL struct {
-	rwmu sync.RWMutex
-	file *os.File
-	size uint64
+	rwmu   sync.RWMutex
+	file   *os.File
+	size   uint64
+	logger *slog.Logger
+	closed bool
 }
 
 func New(f *os.File) (*WAL, error) {
+	return NewWithOptions(f, nil)
+}
+
+func NewWithOptions(f *os.File, opts *Options) (*WAL, error) {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+
 	fi, err := f.Stat()
 	if err != nil {
-		return nil, err
+		return nil, status.IO(err)
 	}
 
 	return &WAL{
-		file: f,
-		size: uint64(fi.Size()),
+		file:   f,
+		size:   uint64(fi.Size()),
+		logger: logging.OrDefault(opts.Logger).With(logging.KEY_PATH, f.Name()),
 	}, nil
 }
 
@@ -63,8 +118,9 @@ func Destroy(wal *WAL) error {
 	}
 
 	if err := os.Remove(fileName); err != nil {
-		return err
+		return status.IO(err)
 	}
+	wal.logger.Debug("wal removed")
 
 	wal.file = nil
 	wal.size = 0
@@ -75,164 +131,408 @@ func Destroy(wal *WAL) error {
 
 func Recover(wal *WAL) (*memtable.MemTable, error) {
 	mt := memtable.New()
+
+	if err := recoverInto(wal, mt); err != nil {
+		return nil, err
+	}
+
+	return mt, nil
+}
+
+// RecoverWithOptions replays the WAL into a new memtable built from opts.
+func RecoverWithOptions(wal *WAL, opts *memtable.Options) (memtable.MemTableRep, error) {
+	mt := memtable.NewRepWithOptions(opts)
+
+	if err := recoverInto(wal, mt); err != nil {
+		return nil, err
+	}
+
+	return mt, nil
+}
+
+func recoverInto(wal *WAL, mt memtable.MemTableRep) error {
+	return Replay(wal, func(recode Recode) {
+		if recode.ColumnFamily == 0 {
+			recode.Apply(mt)
+		}
+	})
+}
+
+// Replay calls fn with the records of the WAL in order, those of batches
+// included. A batch is decoded as a whole, so either all of its records
+// are passed to fn or an error is returned before any is. A record cut
+// short fails with ErrCorruption.
+func Replay(wal *WAL, fn func(recode Recode)) error {
+	return replay(wal, fn, false)
+}
+
+// ReplayTruncate is like Replay for the newest WAL of a store, whose last
+// record may be cut short by a crash during Append. Such a record is taken
+// as the end of the log: it is logged and truncated away, and the records
+// before it are replayed. Any other damage fails with ErrCorruption. The
+// WAL's file must be writable.
+func ReplayTruncate(wal *WAL, fn func(recode Recode)) error {
+	return replay(wal, fn, true)
+}
+
+func replay(wal *WAL, fn func(recode Recode), truncate bool) error {
 	offset := int64(0)
+	records := 0
+
+	fi, err := wal.file.Stat()
+	if err != nil {
+		wal.logger.Error("wal read failed", logging.KEY_ERROR, err)
+		return status.IO(err)
+	}
 
 	for {
-		var ope OpeType
-		var kvsize uint64
-		var ksize uint64
-		var vsize uint64
-		var key []byte
-		var value []byte
-
-		// read ope type
-		{
-			opeBuf := make([]byte, OPETYPE_SIZE)
-
-			n, err := wal.file.ReadAt(opeBuf, offset)
-			if err != nil && err != io.EOF {
-				return nil, err
-			} else if err == io.EOF {
-				break
+		recode, n, err := readRecode(wal.file, offset, fi.Size())
+		if err == io.EOF {
+			break
+		} else if err == io.ErrUnexpectedEOF && truncate {
+			if err := wal.truncate(offset); err != nil {
+				return err
 			}
-			ope = OpeType(opeBuf[0])
+			break
+		} else if err == io.ErrUnexpectedEOF {
+			wal.logger.Error("wal corrupted", logging.KEY_OFFSET, offset, "records", records, logging.KEY_ERROR, err)
+			return fmt.Errorf("%w: record at offset %d of %s cut short: %w", ErrCorruption, offset, wal.file.Name(), err)
+		} else if errors.Is(err, ErrCorruption) {
+			wal.logger.Error("wal corrupted", logging.KEY_OFFSET, offset, "records", records, logging.KEY_ERROR, err)
+			return fmt.Errorf("%w: record at offset %d of %s", err, offset, wal.file.Name())
+		} else if err != nil {
+			wal.logger.Error("wal read failed", logging.KEY_OFFSET, offset, logging.KEY_ERROR, err)
+			return status.IO(err)
+		}
+
+		offset += n
 
-			offset += int64(n)
+		if recode.Ope != OPE_BATCH {
+			fn(recode)
+			records += 1
+			continue
 		}
 
-		// read k/v size
-		{
-			kvsizeBuf := make([]byte, KV_SIZE)
-			n, err := wal.file.ReadAt(kvsizeBuf, offset)
-			if err != nil {
-				return nil, err
-			}
+		for _, r := range recode.Batch {
+			fn(r)
+		}
+		records += len(recode.Batch)
+	}
 
-			offset += int64(n)
+	wal.logger.Info("wal replayed", "records", records, "bytes", offset)
 
-			if err := binary.Read(bytes.NewReader(kvsizeBuf), enc, &kvsize); err != nil {
-				return nil, err
-			}
+	return nil
+}
+
+// truncate cuts the WAL at offset, dropping a record cut short.
+func (wal *WAL) truncate(offset int64) error {
+	wal.rwmu.Lock()
+	defer wal.rwmu.Unlock()
+
+	wal.logger.Warn("wal tail truncated", logging.KEY_OFFSET, offset)
+
+	if err := wal.file.Truncate(offset); err != nil {
+		wal.logger.Error("wal truncate failed", logging.KEY_OFFSET, offset, logging.KEY_ERROR, err)
+		return status.IO(err)
+	}
+
+	if err := wal.file.Sync(); err != nil {
+		wal.logger.Error("wal sync failed", logging.KEY_ERROR, err)
+		return status.IO(err)
+	}
+	wal.size = uint64(offset)
+
+	return nil
+}
+
+// Apply applies the record to mt.
+func (recode Recode) Apply(mt memtable.MemTableRep) {
+	switch recode.Ope {
+	case OPE_PUT:
+		mt.Put(recode.Key, recode.Value)
+	case OPE_DEL:
+		mt.Del(recode.Key)
+	case OPE_MERGE:
+		mt.Merge(recode.Key, recode.Value)
+	case OPE_PUT_TTL:
+		mt.PutWithExpiry(recode.Key, recode.Value, recode.ExpireAt)
+	case OPE_DEL_RANGE:
+		mt.DeleteRange(recode.Key, recode.Value)
+	case OPE_SINGLE_DEL:
+		mt.SingleDelete(recode.Key)
+	}
+}
+
+// readRecode reads the record at offset of r, which holds size bytes, and
+// returns it with its size. It returns io.EOF when there is no record at
+// offset, and io.ErrUnexpectedEOF when the record runs past size.
+func readRecode(r io.ReaderAt, offset, size int64) (Recode, int64, error) {
+	var recode Recode
+	var kvsize uint64
+	var ksize uint64
+	var vsize uint64
+	start := offset
+
+	// read ope type
+	{
+		opeBuf := make([]byte, OPETYPE_SIZE)
+
+		n, err := r.ReadAt(opeBuf, offset)
+		if err != nil {
+			return recode, 0, err
 		}
+		recode.Ope = OpeType(opeBuf[0])
 
-		// read key size
-		{
-			ksizeBuf := make([]byte, K_SIZE)
-			n, err := wal.file.ReadAt(ksizeBuf, offset)
-			if err != nil {
-				return nil, err
-			}
+		offset += int64(n)
+	}
 
-			offset += int64(n)
+	// read k/v size
+	{
+		kvsizeBuf := make([]byte, KV_SIZE)
+		n, err := r.ReadAt(kvsizeBuf, offset)
+		if err != nil {
+			return recode, 0, unexpected(err)
+		}
 
-			if err := binary.Read(bytes.NewReader(ksizeBuf), enc, &ksize); err != nil {
-				return nil, err
-			}
+		offset += int64(n)
+
+		if err := binary.Read(bytes.NewReader(kvsizeBuf), enc, &kvsize); err != nil {
+			return recode, 0, err
 		}
+	}
 
-		// read value size
-		{
-			vsizeBuf := make([]byte, V_SIZE)
-			n, err := wal.file.ReadAt(vsizeBuf, offset)
-			if err != nil {
-				return nil, err
-			}
+	// read key size
+	{
+		ksizeBuf := make([]byte, K_SIZE)
+		n, err := r.ReadAt(ksizeBuf, offset)
+		if err != nil {
+			return recode, 0, unexpected(err)
+		}
 
-			offset += int64(n)
+		offset += int64(n)
 
-			if err := binary.Read(bytes.NewReader(vsizeBuf), enc, &vsize); err != nil {
-				return nil, err
-			}
+		if err := binary.Read(bytes.NewReader(ksizeBuf), enc, &ksize); err != nil {
+			return recode, 0, err
 		}
+	}
 
-		// read key
-		{
-			keyBuf := make([]byte, ksize)
-			n, err := wal.file.ReadAt(keyBuf, offset)
-			if err != nil {
-				return nil, err
-			}
-			key = keyBuf
+	// read value size
+	{
+		vsizeBuf := make([]byte, V_SIZE)
+		n, err := r.ReadAt(vsizeBuf, offset)
+		if err != nil {
+			return recode, 0, unexpected(err)
+		}
+
+		offset += int64(n)
 
-			offset += int64(n)
+		if err := binary.Read(bytes.NewReader(vsizeBuf), enc, &vsize); err != nil {
+			return recode, 0, err
 		}
+	}
 
-		// read value
-		{
-			valueBuf := make([]byte, vsize)
-			n, err := wal.file.ReadAt(valueBuf, offset)
-			if err != nil {
-				return nil, err
-			}
-			value = valueBuf
+	// the sizes are checked one at a time against the bytes left, so that
+	// a corrupt header can neither overflow nor allocate past the input.
+	if offset > size {
+		return recode, 0, io.ErrUnexpectedEOF
+	}
+	if rem := uint64(size - offset); ksize > rem || vsize > rem-ksize {
+		return recode, 0, io.ErrUnexpectedEOF
+	}
+	if ksize+vsize != kvsize {
+		return recode, 0, fmt.Errorf("%w: sizes %d+%d of a record of %d bytes", ErrCorruption, ksize, vsize, kvsize)
+	}
+
+	// read key
+	{
+		keyBuf := make([]byte, ksize)
+		n, err := r.ReadAt(keyBuf, offset)
+		if err != nil && !(err == io.EOF && n == len(keyBuf)) {
+			return recode, 0, unexpected(err)
+		}
+		recode.Key = keyBuf
 
-			offset += int64(n)
+		offset += int64(n)
+	}
+
+	// read value
+	{
+		valueBuf := make([]byte, vsize)
+		n, err := r.ReadAt(valueBuf, offset)
+		if err != nil && !(err == io.EOF && n == len(valueBuf)) {
+			return recode, 0, unexpected(err)
 		}
+		recode.Value = valueBuf
 
-		switch ope {
-		case OPE_PUT:
-			mt.Put(key, value)
-		case OPE_DEL:
-			mt.Del(key)
+		offset += int64(n)
+	}
+
+	// the record is whole, so what follows is damage rather than a crash
+	// during its append.
+	switch recode.Ope {
+	case OPE_PUT_TTL:
+		if len(recode.Value) < EXPIRE_AT_SIZE {
+			return recode, 0, fmt.Errorf("%w: expiring value of %d bytes", ErrCorruption, len(recode.Value))
+		}
+		recode.ExpireAt = time.Unix(0, int64(enc.Uint64(recode.Value)))
+		recode.Value = recode.Value[EXPIRE_AT_SIZE:]
+	case OPE_BATCH:
+		batch, err := decodeBatch(recode.Value)
+		if errors.Is(err, ErrCorruption) {
+			return recode, 0, err
+		} else if err != nil {
+			return recode, 0, fmt.Errorf("%w: batch: %w", ErrCorruption, err)
 		}
+		recode.Batch = batch
+		recode.Value = nil
 	}
 
-	return mt, nil
+	return recode, offset - start, nil
 }
 
-func (wal *WAL) Append(recode Recode) error {
-	wal.rwmu.Lock()
-	defer wal.rwmu.Unlock()
+// unexpected reports a record cut short as io.ErrUnexpectedEOF.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
 
-	bw := bufio.NewWriter(wal.file)
+	return err
+}
 
-	if err := binary.Write(bw, enc, uint8(recode.Ope)); err != nil {
-		return err
+// encodeBatch encodes the records of a batch, each prefixed with its
+// column family.
+func encodeBatch(batch []Recode) ([]byte, error) {
+	var buf bytes.Buffer
+
+	for _, recode := range batch {
+		if recode.Ope == OPE_BATCH {
+			return nil, ErrNestedBatch
+		}
+
+		if err := binary.Write(&buf, enc, recode.ColumnFamily); err != nil {
+			return nil, err
+		}
+
+		if _, err := writeRecode(&buf, recode); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decodeBatch(buf []byte) ([]Recode, error) {
+	r := bytes.NewReader(buf)
+
+	var batch []Recode
+	for offset := int64(0); offset < int64(len(buf)); {
+		if int64(len(buf))-offset < int64(CF_SIZE) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		cf := enc.Uint32(buf[offset:])
+		offset += int64(CF_SIZE)
+
+		recode, n, err := readRecode(r, offset, int64(len(buf)))
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		recode.ColumnFamily = cf
+		batch = append(batch, recode)
+
+		offset += n
+	}
+
+	return batch, nil
+}
+
+// writeRecode writes the record to w and returns the number of bytes
+// written.
+func writeRecode(w io.Writer, recode Recode) (uint64, error) {
+	var size uint64
+
+	if recode.Ope == OPE_PUT_TTL {
+		value := make([]byte, EXPIRE_AT_SIZE, EXPIRE_AT_SIZE+len(recode.Value))
+		enc.PutUint64(value, uint64(recode.ExpireAt.UnixNano()))
+		recode.Value = append(value, recode.Value...)
+	}
+
+	if recode.Ope == OPE_BATCH {
+		value, err := encodeBatch(recode.Batch)
+		if err != nil {
+			return 0, err
+		}
+		recode.Key, recode.Value = nil, value
 	}
 
-	wal.size += uint64(OPETYPE_SIZE)
+	if err := binary.Write(w, enc, uint8(recode.Ope)); err != nil {
+		return 0, err
+	}
+
+	size += uint64(OPETYPE_SIZE)
 
 	// write kvsize(key/value size)
-	if err := binary.Write(bw, enc, uint64(len(recode.Key)+len(recode.Value))); err != nil {
-		return err
+	if err := binary.Write(w, enc, uint64(len(recode.Key)+len(recode.Value))); err != nil {
+		return 0, err
 	}
 
-	wal.size += uint64(KV_SIZE)
+	size += uint64(KV_SIZE)
 
 	// write ksize(key size)
-	if err := binary.Write(bw, enc, uint64(len(recode.Key))); err != nil {
-		return err
+	if err := binary.Write(w, enc, uint64(len(recode.Key))); err != nil {
+		return 0, err
 	}
 
-	wal.size += uint64(K_SIZE)
+	size += uint64(K_SIZE)
 
 	// write vsize(value size)
-	if err := binary.Write(bw, enc, uint64(len(recode.Value))); err != nil {
-		return err
+	if err := binary.Write(w, enc, uint64(len(recode.Value))); err != nil {
+		return 0, err
 	}
 
-	wal.size += uint64(V_SIZE)
+	size += uint64(V_SIZE)
 
 	// write key
-	if _, err := bw.Write(recode.Key); err != nil {
-		return err
+	if _, err := w.Write(recode.Key); err != nil {
+		return 0, err
 	}
 
-	wal.size += uint64(len(recode.Key))
+	size += uint64(len(recode.Key))
 
 	// write value
-	if _, err := bw.Write(recode.Value); err != nil {
-		return err
+	if _, err := w.Write(recode.Value); err != nil {
+		return 0, err
 	}
 
-	wal.size += uint64(len(recode.Value))
+	size += uint64(len(recode.Value))
 
-	if err := bw.Flush(); err != nil {
+	return size, nil
+}
+
+func (wal *WAL) Append(recode Recode) error {
+	wal.rwmu.Lock()
+	defer wal.rwmu.Unlock()
+
+	if wal.closed {
+		return ErrClosed
+	}
+
+	bw := bufio.NewWriter(wal.file)
+
+	n, err := writeRecode(bw, recode)
+	if err != nil {
 		return err
 	}
 
+	if err := bw.Flush(); err != nil {
+		wal.logger.Error("wal append failed", logging.KEY_OFFSET, wal.size, logging.KEY_ERROR, err)
+		return status.IO(err)
+	}
+
+	wal.size += n
+
 	if err := wal.file.Sync(); err != nil {
-		return err
+		wal.logger.Error("wal sync failed", logging.KEY_ERROR, err)
+		return status.IO(err)
 	}
 
 	return nil
@@ -249,12 +549,17 @@ func (wal *WAL) Close() error {
 	wal.rwmu.Lock()
 	defer wal.rwmu.Unlock()
 
+	if wal.closed {
+		return ErrClosed
+	}
+
 	if err := wal.file.Sync(); err != nil {
-		return err
+		return status.IO(err)
 	}
 
 	wal.file.Close()
 	wal.size = 0
+	wal.closed = true
 
 	return nil
 }
@@ -3,11 +3,21 @@ package wal
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
+	"log/slog"
+	"math"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/sosomasox/LSM-Tree-based-Storage/clock"
+	"github.com/sosomasox/LSM-Tree-based-Storage/memtable"
+	"github.com/sosomasox/LSM-Tree-based-Storage/merge"
+	"github.com/sosomasox/LSM-Tree-based-Storage/sstable"
+	"github.com/sosomasox/LSM-Tree-based-Storage/status"
 )
 
 func TestWal(t *testing.T) {
@@ -32,6 +42,230 @@ func TestWal(t *testing.T) {
 		test_wal_Close(t, wal)
 	})
 
+	t.Run("RecoverMerge", func(t *testing.T) {
+		test_wal_RecoverMerge(t)
+	})
+
+	t.Run("RecoverDeleteRange", func(t *testing.T) {
+		test_wal_RecoverDeleteRange(t)
+	})
+
+	t.Run("RecoverSingleDelete", func(t *testing.T) {
+		test_wal_RecoverSingleDelete(t)
+	})
+
+	t.Run("ReplayBatch", func(t *testing.T) {
+		test_wal_ReplayBatch(t)
+	})
+
+	t.Run("Logger", func(t *testing.T) {
+		test_wal_Logger(t)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		test_wal_Errors(t)
+	})
+
+	t.Run("CorruptSize", func(t *testing.T) {
+		test_wal_CorruptSize(t)
+	})
+}
+
+func test_wal_RecoverDeleteRange(t *testing.T) {
+	f, err := os.CreateTemp("", "test_wal_delete_range_walfile_")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	wal, err := New(f)
+	require.NoError(t, err)
+	defer wal.Close()
+
+	require.NoError(t, wal.Append(Recode{Ope: OPE_PUT, Key: []byte("a"), Value: []byte("A")}))
+	require.NoError(t, wal.Append(Recode{Ope: OPE_PUT, Key: []byte("b"), Value: []byte("B")}))
+	require.NoError(t, wal.Append(Recode{Ope: OPE_DEL_RANGE, Key: []byte("a"), Value: []byte("b")}))
+
+	opts := memtable.DefaultOptions()
+	opts.Rep = memtable.SKIPLIST_REP
+
+	mt, err := RecoverWithOptions(wal, opts)
+	require.NoError(t, err)
+
+	_, found, tombstone := mt.Get([]byte("a"))
+	require.Equal(t, false, found)
+	require.Equal(t, true, tombstone)
+
+	value, found, _ := mt.Get([]byte("b"))
+	require.Equal(t, true, found)
+	require.Equal(t, []byte("B"), value)
+
+	require.Equal(t, []sstable.RangeTombstone{
+		{Start: []byte("a"), End: []byte("b")},
+	}, mt.RangeTombstones())
+}
+
+func test_wal_RecoverSingleDelete(t *testing.T) {
+	f, err := os.CreateTemp("", "test_wal_single_delete_walfile_")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	wal, err := New(f)
+	require.NoError(t, err)
+	defer wal.Close()
+
+	require.NoError(t, wal.Append(Recode{Ope: OPE_PUT, Key: []byte("a"), Value: []byte("A")}))
+	require.NoError(t, wal.Append(Recode{Ope: OPE_SINGLE_DEL, Key: []byte("a")}))
+	require.NoError(t, wal.Append(Recode{Ope: OPE_SINGLE_DEL, Key: []byte("b")}))
+
+	opts := memtable.DefaultOptions()
+	opts.Rep = memtable.SKIPLIST_REP
+
+	mt, err := RecoverWithOptions(wal, opts)
+	require.NoError(t, err)
+
+	_, found, tombstone := mt.Get([]byte("a"))
+	require.Equal(t, false, found)
+	require.Equal(t, false, tombstone)
+
+	_, found, tombstone = mt.Get([]byte("b"))
+	require.Equal(t, false, found)
+	require.Equal(t, true, tombstone)
+}
+
+func test_wal_ReplayBatch(t *testing.T) {
+	f, err := os.CreateTemp("", "test_wal_batch_walfile_")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	wal, err := New(f)
+	require.NoError(t, err)
+	defer wal.Close()
+
+	expireAt := time.Now().Add(time.Hour)
+	batch := []Recode{
+		{Ope: OPE_PUT, Key: []byte("a"), Value: []byte("A"), ColumnFamily: 1},
+		{Ope: OPE_PUT_TTL, Key: []byte("b"), Value: []byte("B"), ExpireAt: expireAt},
+		{Ope: OPE_DEL, Key: []byte("c"), Value: []byte{}, ColumnFamily: 2},
+	}
+
+	require.NoError(t, wal.Append(Recode{Ope: OPE_PUT, Key: []byte("x"), Value: []byte("X")}))
+	require.NoError(t, wal.Append(Recode{Ope: OPE_BATCH, Batch: batch}))
+	require.ErrorIs(t, wal.Append(Recode{Ope: OPE_BATCH, Batch: []Recode{{Ope: OPE_BATCH}}}), ErrNestedBatch)
+
+	var recodes []Recode
+	require.NoError(t, Replay(wal, func(recode Recode) {
+		recodes = append(recodes, recode)
+	}))
+
+	require.Equal(t, 4, len(recodes))
+	require.Equal(t, []byte("X"), recodes[0].Value)
+	for i, recode := range batch {
+		require.Equal(t, recode.Ope, recodes[i+1].Ope)
+		require.Equal(t, recode.Key, recodes[i+1].Key)
+		require.Equal(t, recode.Value, recodes[i+1].Value)
+		require.Equal(t, recode.ColumnFamily, recodes[i+1].ColumnFamily)
+	}
+	require.True(t, expireAt.Equal(recodes[2].ExpireAt))
+
+	// only the records of the default column family are recovered.
+	opts := memtable.DefaultOptions()
+	opts.Rep = memtable.SKIPLIST_REP
+
+	mt, err := RecoverWithOptions(wal, opts)
+	require.NoError(t, err)
+
+	_, found, _ := mt.Get([]byte("a"))
+	require.Equal(t, false, found)
+
+	value, found, _ := mt.Get([]byte("b"))
+	require.Equal(t, true, found)
+	require.Equal(t, []byte("B"), value)
+}
+
+func test_wal_Logger(t *testing.T) {
+	f, err := os.CreateTemp("", "test_wal_logger_walfile_")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	var buf bytes.Buffer
+	opts := DefaultOptions()
+	opts.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+
+	wal, err := NewWithOptions(f, opts)
+	require.NoError(t, err)
+	defer wal.Close()
+
+	require.NoError(t, wal.Append(Recode{Ope: OPE_PUT, Key: []byte("a"), Value: []byte("A")}))
+	require.NoError(t, wal.Append(Recode{Ope: OPE_BATCH, Batch: []Recode{
+		{Ope: OPE_PUT, Key: []byte("b"), Value: []byte("B")},
+		{Ope: OPE_DEL, Key: []byte("c"), Value: []byte{}},
+	}}))
+
+	require.NoError(t, Replay(wal, func(Recode) {}))
+	require.Contains(t, buf.String(), `msg="wal replayed" path=`+f.Name()+" records=3 bytes=")
+
+	// a record cut short by a crash.
+	size := wal.Size()
+	require.NoError(t, wal.Append(Recode{Ope: OPE_PUT, Key: []byte("d"), Value: []byte("D")}))
+	require.NoError(t, f.Truncate(int64(size)+3))
+
+	buf.Reset()
+	require.ErrorIs(t, Replay(wal, func(Recode) {}), io.ErrUnexpectedEOF)
+	require.Contains(t, buf.String(), fmt.Sprintf(`level=ERROR msg="wal corrupted" path=%s offset=%d records=3`, f.Name(), size))
+
+	// unless it ends the newest WAL.
+	buf.Reset()
+	require.NoError(t, ReplayTruncate(wal, func(Recode) {}))
+	require.Contains(t, buf.String(), fmt.Sprintf(`level=WARN msg="wal tail truncated" path=%s offset=%d`, f.Name(), size))
+}
+
+func test_wal_RecoverMerge(t *testing.T) {
+	f, err := os.CreateTemp("", "test_wal_merge_walfile_")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	wal, err := New(f)
+	require.NoError(t, err)
+	defer wal.Close()
+
+	require.NoError(t, wal.Append(Recode{Ope: OPE_PUT, Key: []byte("a"), Value: []byte("1")}))
+	require.NoError(t, wal.Append(Recode{Ope: OPE_MERGE, Key: []byte("a"), Value: []byte("2")}))
+	require.NoError(t, wal.Append(Recode{Ope: OPE_MERGE, Key: []byte("b"), Value: []byte("3")}))
+	require.NoError(t, wal.Append(Recode{Ope: OPE_PUT_TTL, Key: []byte("c"), Value: []byte("C"), ExpireAt: time.Unix(1060, 0)}))
+
+	c := clock.NewManual(time.Unix(1000, 0))
+
+	opts := memtable.DefaultOptions()
+	opts.MergeOperator = merge.Uint64Add{}
+	opts.Clock = c
+
+	mt, err := RecoverWithOptions(wal, opts)
+	require.NoError(t, err)
+
+	{
+		value, found, _ := mt.Get([]byte("a"))
+		require.Equal(t, true, found)
+		require.Equal(t, []byte("3"), value)
+	}
+
+	{
+		_, operands, ty, found := mt.Lookup([]byte("b"))
+		require.Equal(t, true, found)
+		require.Equal(t, sstable.MERGE, ty)
+		require.Equal(t, [][]byte{[]byte("3")}, operands)
+	}
+
+	// the expiration time is replayed, not reset.
+	{
+		value, found, _ := mt.Get([]byte("c"))
+		require.Equal(t, true, found)
+		require.Equal(t, []byte("C"), value)
+
+		c.Advance(time.Minute)
+
+		_, found, tombstone := mt.Get([]byte("c"))
+		require.Equal(t, false, found)
+		require.Equal(t, true, tombstone)
+	}
 }
 
 func test_wal_Close(t *testing.T, wal *WAL) {
@@ -153,3 +387,112 @@ func openFile(name string) (file *os.File, size uint64, err error) {
 	return f, uint64(fi.Size()), nil
 }
 */
+
+func test_wal_Errors(t *testing.T) {
+	f, err := os.CreateTemp("", "test_wal_errors_walfile_")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	wal, err := New(f)
+	require.NoError(t, err)
+
+	require.NoError(t, wal.Append(Recode{Ope: OPE_PUT, Key: []byte("a"), Value: []byte("A")}))
+	size := wal.Size()
+	require.NoError(t, wal.Append(Recode{Ope: OPE_PUT, Key: []byte("b"), Value: []byte("B")}))
+	require.NoError(t, f.Truncate(int64(size)+3))
+
+	err = Replay(wal, func(Recode) {})
+	require.ErrorIs(t, err, ErrCorruption)
+	require.ErrorIs(t, err, status.ErrCorruption)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	require.Contains(t, err.Error(), fmt.Sprintf("offset %d of %s", size, f.Name()))
+
+	// the newest WAL is truncated after its last whole record instead.
+	var keys []string
+	require.NoError(t, ReplayTruncate(wal, func(recode Recode) {
+		keys = append(keys, string(recode.Key))
+	}))
+	require.Equal(t, []string{"a"}, keys)
+	require.Equal(t, size, wal.Size())
+
+	fi, err := f.Stat()
+	require.NoError(t, err)
+	require.Equal(t, int64(size), fi.Size())
+
+	// but a whole record that does not decode is still corruption.
+	var rec bytes.Buffer
+	_, err = writeRecode(&rec, Recode{Ope: OPE_PUT, Key: []byte("b"), Value: []byte("B")})
+	require.NoError(t, err)
+	rec.Bytes()[0] = byte(OPE_PUT_TTL)
+	_, err = f.WriteAt(rec.Bytes(), int64(size))
+	require.NoError(t, err)
+
+	err = ReplayTruncate(wal, func(Recode) {})
+	require.ErrorIs(t, err, ErrCorruption)
+	require.NotErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	require.NoError(t, wal.Close())
+	require.ErrorIs(t, wal.Close(), ErrClosed)
+	require.ErrorIs(t, wal.Append(Recode{Ope: OPE_PUT, Key: []byte("c"), Value: []byte("C")}), status.ErrClosed)
+
+	// the file is gone from under the WAL.
+	f, err = os.CreateTemp("", "test_wal_errors_walfile_")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	defer os.Remove(f.Name())
+
+	_, err = New(f)
+	require.ErrorIs(t, err, status.ErrIO)
+	require.ErrorIs(t, err, os.ErrClosed)
+}
+
+func test_wal_CorruptSize(t *testing.T) {
+	f, err := os.CreateTemp("", "test_wal_corrupt_size_walfile_")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	wal, err := New(f)
+	require.NoError(t, err)
+	defer wal.Close()
+
+	record := func(recode Recode) []byte {
+		var buf bytes.Buffer
+		_, err := writeRecode(&buf, recode)
+		require.NoError(t, err)
+
+		return buf.Bytes()
+	}
+	ksizeAt := OPETYPE_SIZE + KV_SIZE
+	vsizeAt := ksizeAt + K_SIZE
+
+	// sizes past the end of the file must not be allocated.
+	buf := record(Recode{Ope: OPE_PUT, Key: []byte("a"), Value: []byte("AAA")})
+	enc.PutUint64(buf[ksizeAt:], math.MaxUint64)
+	enc.PutUint64(buf[vsizeAt:], 3)
+	_, err = f.WriteAt(buf, 0)
+	require.NoError(t, err)
+
+	err = Replay(wal, func(Recode) {})
+	require.ErrorIs(t, err, ErrCorruption)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// sizes within the file which do not add up to the record size.
+	buf = record(Recode{Ope: OPE_PUT, Key: []byte("a"), Value: []byte("AAA")})
+	enc.PutUint64(buf[ksizeAt:], 0)
+	_, err = f.WriteAt(buf, 0)
+	require.NoError(t, err)
+
+	err = ReplayTruncate(wal, func(Recode) {})
+	require.ErrorIs(t, err, ErrCorruption)
+	require.NotErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// and sizes past the end of a batch, whose record is whole.
+	buf = record(Recode{Ope: OPE_BATCH, Batch: []Recode{{Ope: OPE_PUT, Key: []byte("a"), Value: []byte("AAA")}}})
+	enc.PutUint64(buf[OPETYPE_SIZE+KV_SIZE+K_SIZE+V_SIZE+CF_SIZE+ksizeAt:], math.MaxUint64)
+	require.NoError(t, f.Truncate(0))
+	_, err = f.WriteAt(buf, 0)
+	require.NoError(t, err)
+
+	err = ReplayTruncate(wal, func(Recode) {})
+	require.ErrorIs(t, err, ErrCorruption)
+}